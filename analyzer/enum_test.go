@@ -0,0 +1,96 @@
+package analyzer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kvarenzn/pinecone/builtins"
+	"github.com/kvarenzn/pinecone/metainfo"
+)
+
+const enumScript = `//@version=6
+indicator("x")
+enum Signal
+    buy = "Buy"
+    sell = "Sell"
+    hold
+enum Side
+    buy
+    sell
+`
+
+// analyzeEnums checks the lines after the declarations of enumScript
+func analyzeEnums(t *testing.T, lines string) []error {
+	t.Helper()
+	return AnalyzeTypeIn(metainfo.V6, builtins.GlobalNamespace, parseScript(t, enumScript+lines))
+}
+
+func TestEnumDeclaration(t *testing.T) {
+	if errs := analyzeEnums(t, ""); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	errs := analyzeEnums(t, "enum Twice\n    a\n    a\n")
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "'Twice.a' is declared more than once") {
+		t.Errorf("got %v, want a duplicate field", errs)
+	}
+
+	errs = analyzeEnums(t, "f() =>\n    enum Local\n        a\n    0\n")
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "global scope") {
+		t.Errorf("got %v, want an enum outside the global scope", errs)
+	}
+}
+
+func TestEnumMemberAccess(t *testing.T) {
+	if errs := analyzeEnums(t, "s = Signal.buy\nSignal t = Signal.hold\n"); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	errs := analyzeEnums(t, "s = Signal.short\n")
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "enum 'Signal' has no field 'short'") {
+		t.Errorf("got %v, want an unknown field", errs)
+	}
+
+	errs = analyzeEnums(t, "x = ta.nosuch\n")
+	if len(errs) != 1 || errs[0].Error() != "'ta' has no member 'nosuch'" {
+		t.Errorf("got %v, want an unknown member of 'ta'", errs)
+	}
+}
+
+func TestEnumEquality(t *testing.T) {
+	if errs := analyzeEnums(t, "b = Signal.buy == Signal.sell\n"); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	// fields of the same name in different enums are different values
+	if errs := analyzeEnums(t, "b = Signal.buy == Side.buy\n"); len(errs) == 0 {
+		t.Errorf("comparing fields of different enums was accepted")
+	}
+	if errs := analyzeEnums(t, "Signal s = Side.buy\n"); len(errs) == 0 {
+		t.Errorf("assigning a field of another enum was accepted")
+	}
+}
+
+func TestInputEnum(t *testing.T) {
+	tests := []struct {
+		call string
+		err  string
+	}{
+		{`input.enum(Signal.buy, "Signal")`, ""},
+		{`input.enum(Signal.buy, "Signal", options = [Signal.buy, Signal.sell])`, ""},
+		{`input.enum(1, "Signal")`, "must be an enum field"},
+		{`input.enum(Signal.buy, "Signal", options = [Signal.buy, Side.sell])`, "must be fields of 'Signal'"},
+		{`input.enum(title = "Signal")`, "requires the 'defval' argument"},
+	}
+
+	for _, test := range tests {
+		errs := analyzeEnums(t, "Signal s = "+test.call+"\n")
+		if test.err == "" {
+			if len(errs) > 0 {
+				t.Errorf("%s: %v", test.call, errs)
+			}
+		} else if len(errs) == 0 || !strings.Contains(errs[0].Error(), test.err) {
+			t.Errorf("%s: got %v, want an error containing %q", test.call, errs, test.err)
+		}
+	}
+}
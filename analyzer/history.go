@@ -0,0 +1,92 @@
+package analyzer
+
+import (
+	"github.com/kvarenzn/pinecone/ast"
+	"github.com/kvarenzn/pinecone/types"
+)
+
+// DynamicHistoryDepth is the history depth of variables whose history is
+// referenced with an offset that is not known before execution, see
+// Symbol.HistoryDepth.
+const DynamicHistoryDepth = -1
+
+func constInt(node ast.Node) (int64, bool) {
+	switch n := node.(type) {
+	case *ast.IntLiteral:
+		return n.Value, true
+	case *ast.UnaryExpr:
+		v, ok := constInt(n.Expr)
+		if !ok {
+			return 0, false
+		}
+		switch n.Op {
+		case "+":
+			return v, true
+		case "-":
+			return -v, true
+		}
+	case *ast.BinaryExpr:
+		l, ok := constInt(n.Left)
+		if !ok {
+			return 0, false
+		}
+		r, ok := constInt(n.Right)
+		if !ok {
+			return 0, false
+		}
+		switch n.Op {
+		case "+":
+			return l + r, true
+		case "-":
+			return l - r, true
+		case "*":
+			return l * r, true
+		}
+	}
+	return 0, false
+}
+
+// hasSideEffect reports whether a call, which is analyzed already, changes
+// anything but its result
+func hasSideEffect(call *ast.CallExpr) bool {
+	if call.Func.NodeType() == nil {
+		return false
+	}
+	fn, ok := types.Peel(call.Func.NodeType()).(types.CallableType)
+	return ok && types.HasSideEffect(fn.Callable)
+}
+
+// callsSideEffect reports whether the body of a function calls a function
+// with side effects
+func callsSideEffect(body ast.Node) bool {
+	found := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok && hasSideEffect(call) {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+func (ta *typeAnalyzer) recordHistory(node *ast.HRefExpr) {
+	id, ok := node.Series.(*ast.Identifier)
+	if !ok {
+		return
+	}
+	// variables are told apart by their symbols, as locals of different
+	// functions may have the same name
+	v, ok := ta.lookupVariable(id.Name)
+	if !ok || v.symbol == nil {
+		return
+	}
+
+	depth := DynamicHistoryDepth
+	if offset, ok := constInt(node.Offset); ok {
+		depth = int(offset)
+	}
+
+	if prev := v.symbol.HistoryDepth; prev != DynamicHistoryDepth && (depth == DynamicHistoryDepth || depth > prev) {
+		v.symbol.HistoryDepth = depth
+	}
+}
@@ -0,0 +1,74 @@
+package analyzer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kvarenzn/pinecone/ast"
+	"github.com/kvarenzn/pinecone/builtins"
+	"github.com/kvarenzn/pinecone/metainfo"
+)
+
+func TestMaxBarsBackPerSymbol(t *testing.T) {
+	root := parseScript(t, `//@version=5
+indicator("x")
+f(float src) =>
+    src[1]
+g(float src) =>
+    src[5]
+src = close
+a = src[2]
+`)
+
+	info, errs := Analyze(metainfo.V5, builtins.GlobalNamespace, root)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	got := map[string]int{}
+	for sym, depth := range info.MaxBarsBack() {
+		// the parameters are told apart by the function declaring them
+		name := sym.Name
+		if p, ok := sym.Decl.Parent().(*ast.FuncDeclStmt); ok {
+			name = p.Name + "." + name
+		}
+		got[name] = depth
+	}
+
+	want := map[string]int{"f.src": 1, "g.src": 5, "src": 2}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for name, depth := range want {
+		if got[name] != depth {
+			t.Errorf("%s: got %d, want %d", name, got[name], depth)
+		}
+	}
+}
+
+func TestHistoryOfSideEffects(t *testing.T) {
+	root := parseScript(t, `//@version=5
+indicator("x")
+a = array.new<float>(3)
+x = a.pop()[1]
+y = array.pop(a)[1]
+f() =>
+    array.push(a, 1.0)
+    1
+z = f()[1]
+w = array.get(a, 0)[1]
+`)
+
+	errs := AnalyzeTypeIn(metainfo.V5, builtins.GlobalNamespace, root)
+	rows := []int{}
+	for _, err := range errs {
+		e := err.(Error)
+		if !strings.Contains(e.Error(), "side effects") {
+			t.Errorf("unexpected error %v", err)
+			continue
+		}
+		rows = append(rows, e.Begin.Row)
+	}
+	if len(rows) != 3 || rows[0] != 4 || rows[1] != 5 || rows[2] != 9 {
+		t.Errorf("side effects reported on rows %v, want [4 5 9]", rows)
+	}
+}
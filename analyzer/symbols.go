@@ -0,0 +1,211 @@
+package analyzer
+
+import (
+	"github.com/kvarenzn/pinecone/ast"
+	"github.com/kvarenzn/pinecone/base"
+	"github.com/kvarenzn/pinecone/metainfo"
+	"github.com/kvarenzn/pinecone/types"
+)
+
+type SymbolKind byte
+
+const (
+	VariableSymbol SymbolKind = iota
+	ConstantSymbol
+	ParameterSymbol
+	LoopCounterSymbol
+	FunctionSymbol
+	TypeSymbol
+	FieldSymbol
+	EnumSymbol
+	EnumFieldSymbol
+)
+
+var symbolKindNames = map[SymbolKind]string{
+	VariableSymbol:    "variable",
+	ConstantSymbol:    "constant",
+	ParameterSymbol:   "parameter",
+	LoopCounterSymbol: "loop counter",
+	FunctionSymbol:    "function",
+	TypeSymbol:        "type",
+	FieldSymbol:       "field",
+	EnumSymbol:        "enum",
+	EnumFieldSymbol:   "enum field",
+}
+
+func (k SymbolKind) String() string {
+	return symbolKindNames[k]
+}
+
+// Symbol is an entity declared by a script. Decl is the node declaring it:
+// a VarDeclStmt, TupleDeclStmt, ParamDecl, ForStmt, FuncDeclStmt,
+// TypeDeclStmt, MemberDecl, EnumDeclStmt or EnumField.
+type Symbol struct {
+	Name      string
+	Kind      SymbolKind
+	Type      types.Type
+	Qualifier types.QualifierKind
+	Mode      DeclMode
+	Decl      ast.Node
+	Scope     *Scope
+	// the fields of a user defined type or of an enum
+	Members *Scope
+	// the identifiers referring to the symbol, and the attribute expressions
+	// and type names for fields and types
+	References []ast.Node
+	// the largest offset the history of a variable is referenced with by
+	// the '[]' operator, DynamicHistoryDepth if it is not known before
+	// execution, and 0 if its history is never referenced
+	HistoryDepth int
+}
+
+// Scope holds the symbols declared directly in a suite, a function, a for
+// loop or a type declaration. The outermost scope has no node and holds the
+// user defined types and enums.
+type Scope struct {
+	Node     ast.Node
+	Parent   *Scope
+	Children []*Scope
+	Symbols  map[string]*Symbol
+}
+
+func newScope(node ast.Node, parent *Scope) *Scope {
+	s := &Scope{
+		Node:    node,
+		Parent:  parent,
+		Symbols: map[string]*Symbol{},
+	}
+	if parent != nil {
+		parent.Children = append(parent.Children, s)
+	}
+	return s
+}
+
+// Lookup finds a symbol in the scope or in its parents
+func (s *Scope) Lookup(name string) *Symbol {
+	for ; s != nil; s = s.Parent {
+		if sym, ok := s.Symbols[name]; ok {
+			return sym
+		}
+	}
+	return nil
+}
+
+// Info is the semantic model of a script built by Analyze
+type Info struct {
+	Root *Scope
+	// every symbol in declaration order
+	Symbols []*Symbol
+	// the symbol each identifier, attribute expression or type name refers to
+	Uses map[ast.Node]*Symbol
+	// the symbol each node declares, the first name of a tuple declaration
+	Decls  map[ast.Node]*Symbol
+	Scopes map[ast.Node]*Scope
+}
+
+func newInfo() *Info {
+	return &Info{
+		Root:   newScope(nil, nil),
+		Uses:   map[ast.Node]*Symbol{},
+		Decls:  map[ast.Node]*Symbol{},
+		Scopes: map[ast.Node]*Scope{},
+	}
+}
+
+// SymbolOf is the symbol a node declares or refers to
+func (info *Info) SymbolOf(node ast.Node) *Symbol {
+	if sym, ok := info.Uses[node]; ok {
+		return sym
+	}
+	return info.Decls[node]
+}
+
+// ScopeAt is the innermost scope opened by node or by one of its ancestors
+func (info *Info) ScopeAt(node ast.Node) *Scope {
+	for n := node; n != nil; n = n.Parent() {
+		if s, ok := info.Scopes[n]; ok {
+			return s
+		}
+	}
+	return info.Root
+}
+
+// MaxBarsBack returns, for every variable whose history is referenced with
+// the '[]' operator, the largest offset used on it
+func (info *Info) MaxBarsBack() map[*Symbol]int {
+	result := map[*Symbol]int{}
+	for _, sym := range info.Symbols {
+		if sym.HistoryDepth != 0 {
+			result[sym] = sym.HistoryDepth
+		}
+	}
+	return result
+}
+
+// Unused lists the variables which are never referred to
+func (info *Info) Unused() []*Symbol {
+	result := []*Symbol{}
+	for _, sym := range info.Symbols {
+		switch sym.Kind {
+		case VariableSymbol, ConstantSymbol, ParameterSymbol, LoopCounterSymbol:
+			if len(sym.References) == 0 {
+				result = append(result, sym)
+			}
+		}
+	}
+	return result
+}
+
+func (ta *typeAnalyzer) declare(name string, kind SymbolKind, t types.Type, decl ast.Node) *Symbol {
+	sym := &Symbol{
+		Name:  name,
+		Kind:  kind,
+		Type:  types.Peel(t),
+		Decl:  decl,
+		Scope: ta.scope,
+	}
+	if twq, ok := t.(types.TypeWithQualifier); ok {
+		sym.Qualifier = twq.Qualifier
+	}
+	ta.scope.Symbols[name] = sym
+	ta.info.Symbols = append(ta.info.Symbols, sym)
+	if _, ok := ta.info.Decls[decl]; !ok {
+		ta.info.Decls[decl] = sym
+	}
+	return sym
+}
+
+func (ta *typeAnalyzer) declareMember(members *Scope, name string, kind SymbolKind, t types.Type, decl ast.Node) {
+	saved := ta.scope
+	ta.scope = members
+	ta.declare(name, kind, t, decl)
+	ta.scope = saved
+}
+
+func (ta *typeAnalyzer) use(node ast.Node, sym *Symbol) {
+	if sym == nil {
+		return
+	}
+	ta.info.Uses[node] = sym
+	sym.References = append(sym.References, node)
+}
+
+// member finds the symbol of a field of a user defined type or an enum
+func (ta *typeAnalyzer) member(t types.Type, name string) *Symbol {
+	sym := ta.info.Root.Symbols[types.Peel(t).String()]
+	if sym == nil || sym.Members == nil {
+		return nil
+	}
+	return sym.Members.Symbols[name]
+}
+
+// Analyze checks a script like AnalyzeTypeIn, and also returns its semantic
+// model
+func Analyze(version metainfo.LanguageVersion, namespace base.Namespace, root ast.Node) (*Info, []error) {
+	ast.SetParents(root)
+	analyzer := newTypeAnalyzer(version, namespace, root)
+	analyzer.declareTypes(root)
+	analyzer.markType(root)
+
+	return analyzer.info, analyzer.errors
+}
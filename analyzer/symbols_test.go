@@ -0,0 +1,190 @@
+package analyzer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kvarenzn/pinecone/ast"
+	"github.com/kvarenzn/pinecone/builtins"
+	"github.com/kvarenzn/pinecone/metainfo"
+	"github.com/kvarenzn/pinecone/types"
+)
+
+const symbolScript = `//@version=5
+indicator("x")
+length = 14
+unused = 1
+f(float src, int n) =>
+    tmp = src * 2
+    src
+pair() => [1, 2]
+[a, b] = pair()
+y = f(close, length) + a + b
+for i = 0 to 3
+    z = i
+`
+
+func analyzeSymbols(t *testing.T) (ast.Node, *Info) {
+	t.Helper()
+	root := parseScript(t, symbolScript)
+	info, errs := Analyze(metainfo.V5, builtins.GlobalNamespace, root)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	return root, info
+}
+
+// findIdentifier is the first identifier with the name in the tree
+func findIdentifier(root ast.Node, name string) *ast.Identifier {
+	var found *ast.Identifier
+	ast.Inspect(root, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Identifier); ok && found == nil && id.Name == name {
+			found = id
+		}
+		return found == nil
+	})
+	return found
+}
+
+func TestSymbolOf(t *testing.T) {
+	root, info := analyzeSymbols(t)
+
+	id := findIdentifier(root, "length")
+	sym := info.SymbolOf(id)
+	if sym == nil || sym.Name != "length" || sym.Kind != VariableSymbol {
+		t.Fatalf("the symbol of 'length' is %+v", sym)
+	}
+	if decl, ok := sym.Decl.(*ast.VarDeclStmt); !ok || decl.Name != "length" {
+		t.Errorf("'length' is declared by %#v", sym.Decl)
+	}
+	if info.SymbolOf(sym.Decl) != sym {
+		t.Errorf("the declaration of 'length' declares %+v", info.SymbolOf(sym.Decl))
+	}
+
+	// a tuple declaration stands for its first name
+	a := info.SymbolOf(findIdentifier(root, "a"))
+	if a == nil || info.SymbolOf(a.Decl) != a {
+		t.Errorf("the tuple declaration of 'a' declares %+v", info.SymbolOf(a.Decl))
+	}
+
+	if sym := info.SymbolOf(findIdentifier(root, "close")); sym != nil {
+		t.Errorf("builtins have no symbols, got %+v", sym)
+	}
+}
+
+func TestScopeAt(t *testing.T) {
+	root, info := analyzeSymbols(t)
+
+	// 'src' in the body of 'f'
+	var src *ast.Identifier
+	ast.Inspect(root, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Identifier); ok && id.Name == "src" {
+			src = id
+		}
+		return true
+	})
+	inner := info.ScopeAt(src)
+	if sym := inner.Lookup("src"); sym == nil || sym.Kind != ParameterSymbol {
+		t.Errorf("'src' in 'f' is %+v", sym)
+	}
+	if sym := inner.Lookup("tmp"); sym == nil || sym.Kind != VariableSymbol {
+		t.Errorf("'tmp' in 'f' is %+v", sym)
+	}
+	if sym := inner.Lookup("length"); sym == nil {
+		t.Error("the global 'length' is not visible in 'f'")
+	}
+
+	outer := info.ScopeAt(findIdentifier(root, "length"))
+	if outer.Lookup("tmp") != nil || outer.Lookup("src") != nil {
+		t.Error("the locals of 'f' are visible in the global scope")
+	}
+	if sym := outer.Lookup("f"); sym == nil || sym.Kind != FunctionSymbol {
+		t.Errorf("'f' in the global scope is %+v", sym)
+	}
+
+	if info.ScopeAt(root) == info.Root {
+		t.Error("the script has no scope of its own")
+	}
+}
+
+func TestUnused(t *testing.T) {
+	_, info := analyzeSymbols(t)
+
+	names := []string{}
+	for _, sym := range info.Unused() {
+		names = append(names, sym.Name)
+	}
+	// 'i' is read by 'z', and functions are not reported
+	if got := strings.Join(names, " "); got != "unused n tmp y z" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestSymbolsAndReferences(t *testing.T) {
+	root := parseScript(t, `//@version=5
+indicator("x")
+type Point
+    float x = 0.0
+enum Side
+    buy
+    sell
+var float total = 0.0
+const int n = 3
+p = Point.new()
+for i = 0 to n
+    total += p.x + i
+s = Side.buy
+isBuy = s == Side.buy
+f(float v) => v
+total := f(total)
+`)
+	info, errs := Analyze(metainfo.V5, builtins.GlobalNamespace, root)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	// the kind and the type of each symbol, and the positions referring to it
+	want := map[string]string{
+		"Point": "type Point 10:5",
+		"x":     "field float 12:14",
+		"Side":  "enum Side 13:5 14:14",
+		"buy":   "enum field Side 13:5 14:14",
+		"sell":  "enum field Side",
+		"total": "variable float 12:5 16:1 16:12",
+		"n":     "constant int 11:14",
+		"p":     "variable Point 12:14",
+		"i":     "loop counter int 12:20",
+		"s":     "variable Side 14:9",
+		"isBuy": "variable bool",
+		"f":     "function (v: float) -> float 16:10",
+		"v":     "parameter float 15:15",
+	}
+	if len(info.Symbols) != len(want) {
+		t.Errorf("got %d symbols, want %d", len(info.Symbols), len(want))
+	}
+	for _, sym := range info.Symbols {
+		parts := []string{sym.Kind.String(), sym.Type.String()}
+		for _, ref := range sym.References {
+			parts = append(parts, fmt.Sprintf("%d:%d", ref.Begin().Row, ref.Begin().Column))
+			if info.SymbolOf(ref) != sym {
+				t.Errorf("%s: a reference is resolved to %+v", sym.Name, info.SymbolOf(ref))
+			}
+		}
+		if got := strings.Join(parts, " "); got != want[sym.Name] {
+			t.Errorf("%s: got %q, want %q", sym.Name, got, want[sym.Name])
+		}
+	}
+
+	total := info.Root.Children[0].Symbols["total"]
+	if total == nil || total.Mode != DeclVar || total.Qualifier != types.NoQualifier {
+		t.Errorf("total: got %+v", total)
+	}
+	if n := info.Root.Children[0].Symbols["n"]; n == nil || n.Qualifier != types.Const {
+		t.Errorf("n: got %+v", n)
+	}
+	point := info.Root.Symbols["Point"]
+	if point == nil || point.Members.Symbols["x"] == nil || point.Members.Symbols["x"].Decl.(*ast.MemberDecl).Name != "x" {
+		t.Errorf("the fields of Point are not its members: %+v", point)
+	}
+}
@@ -2,40 +2,260 @@ package analyzer
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/kvarenzn/pinecone/ast"
 	"github.com/kvarenzn/pinecone/base"
 	"github.com/kvarenzn/pinecone/builtins"
+	"github.com/kvarenzn/pinecone/metainfo"
 	"github.com/kvarenzn/pinecone/types"
 )
 
+type variableKind byte
+
+const (
+	plainVariable variableKind = iota
+	constVariable
+	paramVariable
+)
+
+// DeclMode tells whether a variable is re-initialized on every bar, or keeps
+// its value across bars ('var') and across realtime updates ('varip').
+type DeclMode byte
+
+const (
+	DeclDefault DeclMode = iota
+	DeclVar
+	DeclVarip
+)
+
+func declModeOf(mode *string) DeclMode {
+	if mode == nil {
+		return DeclDefault
+	}
+
+	switch *mode {
+	case "var":
+		return DeclVar
+	case "varip":
+		return DeclVarip
+	}
+	return DeclDefault
+}
+
+func (m DeclMode) String() string {
+	switch m {
+	case DeclVar:
+		return "var"
+	case DeclVarip:
+		return "varip"
+	}
+	return ""
+}
+
+func (m DeclMode) Persistent() bool {
+	return m != DeclDefault
+}
+
+type scriptKind byte
+
+const (
+	unknownScript scriptKind = iota
+	indicatorScript
+	strategyScript
+	libraryScript
+)
+
+type variable struct {
+	kind   variableKind
+	mode   DeclMode
+	twq    types.TypeWithQualifier
+	symbol *Symbol
+}
+
+// Error is an error found in a node
+type Error struct {
+	Begin metainfo.Location
+	End   metainfo.Location
+	Msg   string
+}
+
+func (e Error) Error() string {
+	return e.Msg
+}
+
+func (e Error) Diagnostic(file *metainfo.File) metainfo.Diagnostic {
+	return file.Diagnostic(e.Begin, e.End, metainfo.SeverityError, e.Msg)
+}
+
+type Warning struct {
+	Begin metainfo.Location
+	End   metainfo.Location
+	Msg   string
+}
+
+func (w Warning) Error() string {
+	return w.Msg
+}
+
+func (w Warning) Diagnostic(file *metainfo.File) metainfo.Diagnostic {
+	return file.Diagnostic(w.Begin, w.End, metainfo.SeverityWarning, w.Msg)
+}
+
+// Diagnostics attributes the errors returned by AnalyzeType to the file the
+// script was parsed from
+func Diagnostics(file *metainfo.File, errs []error) []metainfo.Diagnostic {
+	result := []metainfo.Diagnostic{}
+	for _, err := range errs {
+		switch e := err.(type) {
+		case Error:
+			result = append(result, e.Diagnostic(file))
+		case Warning:
+			result = append(result, e.Diagnostic(file))
+		default:
+			result = append(result, metainfo.Diagnostic{
+				Severity: metainfo.SeverityError,
+				Msg:      err.Error(),
+			})
+		}
+	}
+	return result
+}
+
+// allowsVarip tells whether 'varip' can be used, which is only in scripts
+// declared with indicator() or strategy()
+func (k scriptKind) allowsVarip() bool {
+	return k == indicatorScript || k == strategyScript
+}
+
 type typeAnalyzer struct {
-	scopes    []map[string]types.TypeWithQualifier
-	namespace base.Namespace
-	userNS    base.Namespace
-	errors    []error
+	version    metainfo.LanguageVersion
+	scopes     []map[string]variable
+	namespace  base.Namespace
+	userNS     base.Namespace
+	scriptKind scriptKind
+	// see detectDynamicRequests
+	dynamicRequests bool
+	// user defined types, declared before their fields are analyzed
+	structs map[*ast.TypeDeclStmt]types.Type
+	enums   map[*ast.EnumDeclStmt]types.Type
+	errors  []error
+	info    *Info
+	scope   *Scope
 }
 
-func AnalyzeType(namespace base.Namespace, root ast.Node) []error {
-	analyzer := typeAnalyzer{
-		scopes:    []map[string]types.TypeWithQualifier{make(map[string]types.TypeWithQualifier)},
+func newTypeAnalyzer(version metainfo.LanguageVersion, namespace base.Namespace, root ast.Node) *typeAnalyzer {
+	info := newInfo()
+	return &typeAnalyzer{
+		version:   version,
+		scopes:    []map[string]variable{make(map[string]variable)},
 		namespace: namespace,
 		userNS: base.Namespace{
 			Callables: map[string]types.Callable{},
 			Types:     map[string]types.TypeOrCtor{},
 		},
-		errors: []error{},
+		scriptKind:      detectScriptKind(root),
+		dynamicRequests: detectDynamicRequests(version, root),
+		structs:         map[*ast.TypeDeclStmt]types.Type{},
+		enums:           map[*ast.EnumDeclStmt]types.Type{},
+		errors:          []error{},
+		info:            info,
+		scope:           info.Root,
+	}
+}
+
+func AnalyzeType(namespace base.Namespace, root ast.Node) []error {
+	return AnalyzeTypeIn(metainfo.DefaultVersion, namespace, root)
+}
+
+// AnalyzeTypeIn checks a script with the rules of the given language version,
+// which is usually found by tokenizer.DetectVersion
+func AnalyzeTypeIn(version metainfo.LanguageVersion, namespace base.Namespace, root ast.Node) []error {
+	_, errs := Analyze(version, namespace, root)
+	return errs
+}
+
+func (ta *typeAnalyzer) canConvert(from, to types.Type) bool {
+	return types.CanDoImplicitConversionIn(ta.version, from, to)
+}
+
+func resolveToc(toc *types.TypeOrCtor) types.Type {
+	if toc.Tag == types.TocType {
+		return toc.Type
+	}
+	return *toc
+}
+
+// declaration finds the call to indicator(), strategy() or library() which
+// declares the script
+func declaration(root ast.Node) *ast.CallExpr {
+	stmts := []ast.Node{root}
+	if suite, ok := root.(*ast.Suite); ok {
+		stmts = suite.Body
+	}
+
+	for _, stmt := range stmts {
+		es, ok := stmt.(*ast.ExprStmt)
+		if !ok {
+			continue
+		}
+		call, ok := es.Expr.(*ast.CallExpr)
+		if !ok {
+			continue
+		}
+		fn, ok := call.Func.(*ast.Identifier)
+		if !ok {
+			continue
+		}
+		switch fn.Name {
+		case "indicator", "strategy", "library":
+			return call
+		}
 	}
-	analyzer.markType(root)
 
-	return analyzer.errors
+	return nil
+}
+
+func detectScriptKind(root ast.Node) scriptKind {
+	call := declaration(root)
+	if call == nil {
+		return unknownScript
+	}
+	switch call.Func.(*ast.Identifier).Name {
+	case "indicator":
+		return indicatorScript
+	case "strategy":
+		return strategyScript
+	}
+	return libraryScript
+}
+
+func (ta *typeAnalyzer) warn(node ast.Node, format string, args ...any) {
+	ta.errors = append(ta.errors, Warning{
+		Begin: node.Begin(),
+		End:   node.End(),
+		Msg:   fmt.Sprintf(format, args...),
+	})
+}
+
+// report records an error found in a node
+func (ta *typeAnalyzer) report(node ast.Node, err error) {
+	if _, ok := err.(Error); !ok {
+		err = Error{
+			Begin: node.Begin(),
+			End:   node.End(),
+			Msg:   err.Error(),
+		}
+	}
+	ta.errors = append(ta.errors, err)
 }
 
 func (ta typeAnalyzer) lookupType(name string) (types.Type, error) {
 	// find in user-defined types
 	t, err := ta.userNS.FindType(name)
 	if err == nil {
-		return t, nil
+		return resolveToc(t), nil
 	}
 
 	// find in builtin types
@@ -44,26 +264,46 @@ func (ta typeAnalyzer) lookupType(name string) (types.Type, error) {
 		return nil, err
 	}
 
-	return t, nil
+	return resolveToc(t), nil
 }
 
-func (ta typeAnalyzer) lookupIdentifier(name string) (types.Type, error) {
-	// 1. find variables
+func (ta typeAnalyzer) lookupVariable(name string) (*variable, bool) {
 	last := len(ta.scopes) - 1
 	for i := last; i >= 0; i-- {
 		v, ok := ta.scopes[i][name]
 		if ok {
-			return v, nil
+			return &v, true
 		}
 	}
+	return nil, false
+}
+
+func (ta typeAnalyzer) lookupIdentifier(name string) (types.Type, error) {
+	// 1. find variables
+	if v, ok := ta.lookupVariable(name); ok {
+		return v.twq, nil
+	}
+	if t, err := ta.namespace.FindVariableType(name); err == nil {
+		return t, nil
+	}
 
 	// 2. find functions
-	fn, err := ta.namespace.FindFunction(name)
-	if err != nil {
+	fn, err := ta.userNS.FindFunction(name)
+	if err == nil {
+		return types.CallableTypeWrap(fn), nil
+	}
+	fn, err = ta.namespace.FindFunction(name)
+	if err == nil {
 		return types.CallableTypeWrap(fn), nil
 	}
 
-	// 3. find namespace
+	// 3. find user defined types, for calls like 'MyType.new()'
+	toc, err := ta.userNS.FindType(name)
+	if err == nil {
+		return *toc, nil
+	}
+
+	// 4. find namespace
 	m, err := ta.namespace.FindNamespace(name)
 	if err == nil {
 		return base.NSTypeWrap(*m), nil
@@ -72,21 +312,38 @@ func (ta typeAnalyzer) lookupIdentifier(name string) (types.Type, error) {
 	return nil, fmt.Errorf("unknown identifier '%s'", name)
 }
 
-func (ta *typeAnalyzer) enterScope() {
-	ta.scopes = append(ta.scopes, map[string]types.TypeWithQualifier{})
+func (ta *typeAnalyzer) enterScope(node ast.Node) {
+	ta.scopes = append(ta.scopes, map[string]variable{})
+	ta.scope = newScope(node, ta.scope)
+	ta.info.Scopes[node] = ta.scope
 }
 
 func (ta *typeAnalyzer) exitScope() {
 	ta.scopes = ta.scopes[:len(ta.scopes)-1]
+	ta.scope = ta.scope.Parent
 }
 
-func (ta *typeAnalyzer) registerVariable(name string, twq types.TypeWithQualifier) error {
+func (ta *typeAnalyzer) registerVariable(name string, v variable, decl ast.Node) error {
 	last := ta.scopes[len(ta.scopes)-1]
 	if _, ok := last[name]; ok {
 		return fmt.Errorf("变量'%s'重新定义", name)
 	}
 
-	last[name] = twq
+	kind := VariableSymbol
+	switch {
+	case v.kind == constVariable:
+		kind = ConstantSymbol
+	case v.kind == paramVariable:
+		kind = ParameterSymbol
+	case decl != nil:
+		if _, ok := decl.(*ast.ForStmt); ok {
+			kind = LoopCounterSymbol
+		}
+	}
+	v.symbol = ta.declare(name, kind, v.twq, decl)
+	v.symbol.Mode = v.mode
+
+	last[name] = v
 	return nil
 }
 
@@ -161,6 +418,8 @@ func (ta *typeAnalyzer) markType(node ast.Node) {
 		err = ta.memberDecl(n)
 	case *ast.TypeDeclStmt:
 		err = ta.typeDeclStmt(n)
+	case *ast.EnumDeclStmt:
+		err = ta.enumDeclStmt(n)
 	case *ast.ImportStmt:
 		err = ta.importStmt(n)
 	case *ast.Suite:
@@ -170,50 +429,47 @@ func (ta *typeAnalyzer) markType(node ast.Node) {
 	}
 
 	if err != nil {
-		ta.errors = append(ta.errors, err)
+		ta.report(node, err)
 	}
 }
 
 func (ta *typeAnalyzer) simpleType(node *ast.SimpleType) error {
-	name := node.Name
-	parent := node.Parent()
-
-	switch parent.(type) {
-	case *ast.SubType, *ast.GenericType:
-		if node.PathAttribute() == "Name" {
-			t, err := ta.namespace.FindNamespace(name)
-			if err != nil {
-				return err
-			}
-			node.MarkNodeType(base.NSTypeWrap(*t))
-			return nil
-		}
-	}
-
-	t, err := ta.namespace.FindType(name)
+	t, err := ta.lookupType(node.Name)
 	if err != nil {
 		return err
 	}
 
 	node.MarkNodeType(t)
+	if sym := ta.info.Root.Symbols[node.Name]; sym != nil && sym.Members != nil {
+		ta.use(node, sym)
+	}
 	return nil
 }
 
 func (ta *typeAnalyzer) subType(node *ast.SubType) error {
-	ta.markType(node.Name)
-	pt := node.Name.NodeType()
-	smWrapper, ok := pt.(base.NSType)
-	if !ok {
-		return fmt.Errorf("'%s' is not a namespace", node.Name)
+	var sm *base.Namespace
+	if st, ok := node.Name.(*ast.SimpleType); ok {
+		m, err := ta.namespace.FindNamespace(st.Name)
+		if err != nil {
+			return err
+		}
+		st.MarkNodeType(base.NSTypeWrap(*m))
+		sm = m
+	} else {
+		ta.markType(node.Name)
+		smWrapper, ok := node.Name.NodeType().(base.NSType)
+		if !ok {
+			return fmt.Errorf("'%s' is not a namespace", node.Name)
+		}
+		sm = &smWrapper.Namespace
 	}
 
-	sm := smWrapper.Namespace
 	t, err := sm.FindType(node.Member)
 	if err != nil {
 		return err
 	}
 
-	node.MarkNodeType(t)
+	node.MarkNodeType(resolveToc(t))
 	return nil
 }
 
@@ -222,9 +478,15 @@ func (ta *typeAnalyzer) genericType(node *ast.GenericType) error {
 	args := []types.Type{}
 	for _, arg := range node.Args {
 		ta.markType(arg)
+		if arg.NodeType() == nil {
+			return nil
+		}
 		args = append(args, arg.NodeType())
 	}
 	pt := node.Name.NodeType()
+	if pt == nil {
+		return nil
+	}
 	ctor, ok := pt.(types.TypeOrCtor)
 	if !ok {
 		return fmt.Errorf("'%s' is not a type constructor", node.Name)
@@ -246,10 +508,15 @@ func (ta *typeAnalyzer) binaryExpr(node *ast.BinaryExpr) error {
 		return fmt.Errorf("unknown operator '%s'", node.Op)
 	}
 
-	t, err := bop.Validate(node.Left.NodeType(), node.Right.NodeType())
+	if node.Left.NodeType() == nil || node.Right.NodeType() == nil {
+		return nil
+	}
+
+	t, err := bop.Validate(ta.version, node.Left.NodeType(), node.Right.NodeType())
 	if err != nil {
 		return err
 	}
+	ta.checkLazyOperand(node)
 
 	node.MarkNodeType(t)
 	return nil
@@ -263,7 +530,11 @@ func (ta *typeAnalyzer) unaryExpr(node *ast.UnaryExpr) error {
 		return fmt.Errorf("unknown operator '%s'", node.Op)
 	}
 
-	t, err := uop.Validate(node.Expr.NodeType())
+	if node.Expr.NodeType() == nil {
+		return nil
+	}
+
+	t, err := uop.Validate(ta.version, node.Expr.NodeType())
 	if err != nil {
 		return err
 	}
@@ -275,48 +546,150 @@ func (ta *typeAnalyzer) unaryExpr(node *ast.UnaryExpr) error {
 func (ta *typeAnalyzer) attrExpr(node *ast.AttrExpr) error {
 	ta.markType(node.Target)
 	p := node.Target.NodeType()
+	if p == nil {
+		return nil
+	}
 	switch p.Kind() {
 	case types.StructKind:
-		t := p.FieldByName(node.Name)
-		if t == nil {
-			return fmt.Errorf("'%s' has no attribute '%s'", node.Target, node.Name)
+		if t := p.FieldByName(node.Name); t != nil {
+			node.MarkNodeType(t.Type)
+			ta.use(node, ta.member(p, node.Name))
+			return nil
+		}
+		if node.Name == "copy" {
+			node.MarkNodeType(types.CallableTypeWrap(structCopier(p)))
+			return nil
+		}
+	case types.TypeOrCtorKind:
+		toc, ok := p.(types.TypeOrCtor)
+		if ok && toc.Tag == types.TocType && toc.Type.Kind() == types.StructKind {
+			if node.Name != "new" {
+				return fmt.Errorf("type '%s' has no attribute '%s'", toc.Type.String(), node.Name)
+			}
+			node.MarkNodeType(types.CallableTypeWrap(structConstructor(toc.Type)))
+			return nil
+		}
+		if ok && toc.Tag == types.TocType && toc.Type.Kind() == types.EnumKind {
+			field := toc.Type.FieldByName(node.Name)
+			if field == nil {
+				return fmt.Errorf("enum '%s' has no field '%s'", toc.Type.String(), node.Name)
+			}
+			node.MarkNodeType(types.TypeWithQualifier{
+				Qualifier: types.Const,
+				Type:      field.Type,
+			})
+			ta.use(node, ta.member(toc.Type, node.Name))
+			return nil
 		}
-		node.MarkNodeType(t.Type)
 	case types.NamespaceKind:
 		mw, ok := p.(base.NSType)
 		if !ok {
 			return fmt.Errorf("'%s' is not a namespace", mw)
 		}
 		m := mw.Namespace
-		t, err := m.FindVariableType(node.Name)
-		if err != nil {
-			return err
+		if t, err := m.FindVariableType(node.Name); err == nil {
+			node.MarkNodeType(t)
+			return nil
 		}
-		node.MarkNodeType(t)
-	default:
-		// lookup method
-		method, err := ta.namespace.FindMethod(node.Name, p)
+		if f, err := m.FindFunction(node.Name); err == nil {
+			node.MarkNodeType(types.CallableTypeWrap(f))
+			return nil
+		}
+		sm, err := m.FindNamespace(node.Name)
 		if err != nil {
-			return err
+			return fmt.Errorf("'%s' has no member '%s'", ast.DottedName(node.Target), node.Name)
+		}
+		node.MarkNodeType(base.NSTypeWrap(*sm))
+		return nil
+	}
+
+	// lookup method
+	method, err := ta.userNS.FindMethod(ta.version, node.Name, p)
+	if err != nil {
+		method, err = ta.namespace.FindMethod(ta.version, node.Name, p)
+	}
+	if err != nil {
+		if p.Kind() == types.StructKind {
+			return fmt.Errorf("'%s' has no field or method '%s'", p.String(), node.Name)
 		}
-		node.MarkNodeType(types.CallableTypeWrap(method))
+		return err
 	}
+	node.MarkNodeType(types.CallableTypeWrap(types.BoundMethod{
+		Method: method,
+		Self:   p,
+	}))
 	return nil
 }
 
+// structConstructor builds the 'new' function of a user defined type, every
+// field can be given positionally or by keyword, and omitted fields take
+// their default values (or na)
+func structConstructor(st types.Type) types.Callable {
+	fields := []types.TypeWithName{}
+	for _, f := range st.Fields() {
+		fields = append(fields, types.TypeWithName{
+			Name:     f.Name,
+			Type:     f.Type,
+			Optional: true,
+		})
+	}
+
+	return types.BuiltinFunction{
+		Name:  st.String() + ".new",
+		Types: []types.Type{types.FunctionOf(fields, st)},
+	}
+}
+
+func structCopier(st types.Type) types.Callable {
+	return types.BuiltinFunction{
+		Name:  st.String() + ".copy",
+		Types: []types.Type{types.FunctionOf([]types.TypeWithName{}, types.Peel(st))},
+	}
+}
+
 func (ta *typeAnalyzer) kwArg(node *ast.KwArg) error {
 	ta.markType(node.Value)
+	node.MarkNodeType(node.Value.NodeType())
 	return nil
 }
 
 func (ta *typeAnalyzer) instantiationExpr(node *ast.InstantiationExpr) error {
 	ta.markType(node.Template)
+	args := []types.Type{}
+	for _, arg := range node.TypeArgs {
+		ta.markType(arg)
+		if arg.NodeType() == nil {
+			return nil
+		}
+		args = append(args, arg.NodeType())
+	}
+	t := node.Template.NodeType()
+	if t == nil {
+		return nil
+	}
+
+	ct, ok := types.Peel(t).(types.CallableType)
+	if !ok {
+		return fmt.Errorf("'%s' takes no type arguments", node.Template)
+	}
+	gf, ok := ct.Callable.(types.GenericFunction)
+	if !ok {
+		return fmt.Errorf("'%s' takes no type arguments", node.Template)
+	}
+	fn, err := gf.Instantiate(args)
+	if err != nil {
+		return err
+	}
+	node.MarkNodeType(types.CallableTypeWrap(fn))
 	return nil
 }
 
 func (ta *typeAnalyzer) callExpr(node *ast.CallExpr) error {
 	ta.markType(node.Func)
 	funcType := node.Func.NodeType()
+	if funcType == nil {
+		return nil
+	}
 	if funcType.Kind() != types.CallableKind {
 		return fmt.Errorf("'%s' is not a callable", node.Func)
 	}
@@ -330,16 +703,22 @@ func (ta *typeAnalyzer) callExpr(node *ast.CallExpr) error {
 	kwArgTypes := map[string]types.Type{}
 	for _, a := range node.Args {
 		ta.markType(a)
+		if a.NodeType() == nil {
+			return nil
+		}
 		if kw, ok := a.(*ast.KwArg); ok {
 			kwArgTypes[kw.Name] = a.NodeType()
 		} else {
 			argTypes = append(argTypes, a.NodeType())
 		}
 	}
-	res, err := fn.Callable.Dispatch(argTypes, kwArgTypes)
+	res, err := fn.Callable.Dispatch(ta.version, argTypes, kwArgTypes)
 	if err != nil {
 		return err
 	}
+	if err := ta.checkVersionRules(node); err != nil {
+		return err
+	}
 
 	node.MarkNodeType(res)
 	return nil
@@ -347,14 +726,54 @@ func (ta *typeAnalyzer) callExpr(node *ast.CallExpr) error {
 
 func (ta *typeAnalyzer) hRefExpr(node *ast.HRefExpr) error {
 	ta.markType(node.Series)
+	ta.markType(node.Offset)
+
+	offsetType := node.Offset.NodeType()
+	if offsetType == nil {
+		return nil
+	}
+	if offsetType.Kind() != types.IntKind {
+		return fmt.Errorf("history reference offset must be an int, but got '%s'", offsetType.String())
+	}
+	if offset, ok := constInt(node.Offset); ok && offset < 0 {
+		return fmt.Errorf("history reference offset must not be negative, but got %d", offset)
+	}
+
+	seriesType := node.Series.NodeType()
+	if seriesType == nil {
+		return nil
+	}
+	switch seriesType.Kind() {
+	case types.TupleKind:
+		return fmt.Errorf("cannot reference the history of a tuple")
+	case types.VoidKind, types.NamespaceKind, types.TypeOrCtorKind, types.CallableKind, types.FunctionKind:
+		return fmt.Errorf("cannot reference the history of a '%s' value", seriesType.String())
+	case types.LabelKind, types.LineKind, types.LineFillKind, types.BoxKind, types.TableKind, types.PolyLineKind:
+		return fmt.Errorf("cannot reference the history of drawing id of type '%s'", seriesType.String())
+	}
+
+	if call, ok := node.Series.(*ast.CallExpr); ok && hasSideEffect(call) {
+		return fmt.Errorf("cannot reference the history of a call to a function with side effects")
+	}
+
+	ta.recordHistory(node)
+
 	node.MarkNodeType(types.TypeWithQualifier{
-		Type:      node.Series.NodeType(),
+		Type:      types.Peel(seriesType),
 		Qualifier: types.Series,
 	})
 	return nil
 }
 
 func (ta *typeAnalyzer) identifier(node *ast.Identifier) error {
+	if v, ok := ta.lookupVariable(node.Name); ok {
+		ta.use(node, v.symbol)
+	} else if sym := ta.scope.Lookup(node.Name); sym != nil {
+		// functions, user defined types and enums, linked even if their type
+		// could not be inferred
+		ta.use(node, sym)
+	}
+
 	typ, err := ta.lookupIdentifier(node.Name)
 	if err != nil {
 		return err
@@ -392,6 +811,9 @@ func (ta *typeAnalyzer) tupleExpr(node *ast.TupleExpr) error {
 	items := []types.Type{}
 	for _, item := range node.Items {
 		ta.markType(item)
+		if item.NodeType() == nil {
+			return nil
+		}
 		items = append(items, item.NodeType())
 	}
 
@@ -405,17 +827,20 @@ func (ta *typeAnalyzer) ternaryExpr(node *ast.TernaryExpr) error {
 	ta.markType(node.False)
 	trueType := node.True.NodeType()
 	falseType := node.False.NodeType()
+	if trueType == nil || falseType == nil {
+		return nil
+	}
 	if types.Equal(trueType, falseType) {
 		node.MarkNodeType(trueType)
 		return nil
 	}
 
-	if types.CanDoImplicitConversion(trueType, falseType) {
+	if ta.canConvert(trueType, falseType) {
 		node.MarkNodeType(falseType)
 		return nil
 	}
 
-	if types.CanDoImplicitConversion(falseType, trueType) {
+	if ta.canConvert(falseType, trueType) {
 		node.MarkNodeType(trueType)
 		return nil
 	}
@@ -437,13 +862,16 @@ func (ta *typeAnalyzer) varDeclStmt(node *ast.VarDeclStmt) error {
 	}
 	ta.markType(node.Initial)
 	initType := node.Initial.NodeType()
+	if initType == nil {
+		return nil
+	}
 	if formalType == nil {
 		if !initType.Kind().IsNormal() {
 			return fmt.Errorf("cannot infer type of '%s', init stmt type is '%s'", node.Name, initType.String())
 		}
 
 		formalType = initType
-	} else if !types.Equal(formalType, initType) && !types.CanDoImplicitConversion(initType, formalType) {
+	} else if !types.Equal(formalType, initType) && !ta.canConvert(initType, formalType) {
 		return fmt.Errorf("type mismatch: '%s' expect a '%s' value, but got '%s'", node.Name, formalType.String(), initType.String())
 	}
 
@@ -471,7 +899,29 @@ func (ta *typeAnalyzer) varDeclStmt(node *ast.VarDeclStmt) error {
 
 	node.MarkNodeType(twq)
 
-	if err := ta.registerVariable(node.Name, twq); err != nil {
+	kind := plainVariable
+	if qualifier == types.Const && node.Qualifier != nil {
+		kind = constVariable
+	}
+
+	mode := declModeOf(node.DeclMode)
+	switch mode {
+	case DeclVarip:
+		if !ta.scriptKind.allowsVarip() {
+			return fmt.Errorf("'varip' variable '%s' can only be declared in indicators or strategies", node.Name)
+		}
+		fallthrough
+	case DeclVar:
+		if initType.QualifierKind() == types.Series {
+			ta.warn(node, "'%s' is declared with '%s' but its initial value is a series, which will be frozen to its value on the first bar", node.Name, mode)
+		}
+	}
+
+	if err := ta.registerVariable(node.Name, variable{
+		kind: kind,
+		mode: mode,
+		twq:  twq,
+	}, node); err != nil {
 		return err
 	}
 
@@ -480,6 +930,9 @@ func (ta *typeAnalyzer) varDeclStmt(node *ast.VarDeclStmt) error {
 
 func (ta *typeAnalyzer) tupleDeclStmt(node *ast.TupleDeclStmt) error {
 	ta.markType(node.Initial)
+	if node.Initial.NodeType() == nil {
+		return nil
+	}
 	if node.Initial.NodeType().Kind() != types.TupleKind {
 		return fmt.Errorf("%s返回的不是一个元组，因此无法对元组赋值", node.Initial)
 	}
@@ -490,10 +943,12 @@ func (ta *typeAnalyzer) tupleDeclStmt(node *ast.TupleDeclStmt) error {
 	}
 
 	for i, v := range node.Variables {
-		if err := ta.registerVariable(v, types.TypeWithQualifier{
-			Type:      node.Initial.NodeType().Item(i),
-			Qualifier: types.NoQualifier,
-		}); err != nil {
+		if err := ta.registerVariable(v, variable{
+			twq: types.TypeWithQualifier{
+				Type:      node.Initial.NodeType().Item(i),
+				Qualifier: types.NoQualifier,
+			},
+		}, node); err != nil {
 			return err
 		}
 	}
@@ -504,19 +959,62 @@ func (ta *typeAnalyzer) tupleDeclStmt(node *ast.TupleDeclStmt) error {
 }
 
 func (ta *typeAnalyzer) reassignStmt(node *ast.ReassignStmt) error {
+	if t, ok := node.Target.(*ast.Identifier); ok {
+		v, ok := ta.lookupVariable(t.Name)
+		if !ok {
+			return fmt.Errorf("cannot reassign '%s': variable is not declared", t.Name)
+		}
+		switch v.kind {
+		case constVariable:
+			return fmt.Errorf("cannot reassign '%s': variable is declared as const", t.Name)
+		case paramVariable:
+			return fmt.Errorf("cannot reassign '%s': function parameters are read-only", t.Name)
+		}
+	}
+
 	ta.markType(node.Target)
-	if !node.Target.NodeType().Kind().IsValid() {
+	if attr, ok := node.Target.(*ast.AttrExpr); ok {
+		if owner := attr.Target.NodeType(); owner != nil && owner.Kind() != types.StructKind {
+			return fmt.Errorf("cannot reassign '%s': only fields of user defined types can be reassigned", attr.Name)
+		}
+	}
+	targetType := node.Target.NodeType()
+	if targetType == nil || !targetType.Kind().IsValid() {
 		return nil
 	}
+
 	ta.markType(node.Value)
-	node.MarkNodeType(node.Target.NodeType())
+	valueType := node.Value.NodeType()
+	if valueType == nil {
+		return nil
+	}
+
+	if node.Op != ":=" {
+		op := strings.TrimSuffix(node.Op, "=")
+		bop, ok := builtins.BinaryOperators[op]
+		if !ok {
+			return fmt.Errorf("unknown operator '%s'", node.Op)
+		}
+
+		t, err := bop.Validate(ta.version, targetType, valueType)
+		if err != nil {
+			return err
+		}
+		valueType = t
+	}
+
+	if !types.Equal(targetType, valueType) && !ta.canConvert(valueType, targetType) {
+		return fmt.Errorf("type mismatch: cannot assign a '%s' value to a '%s' target", valueType.String(), targetType.String())
+	}
+
+	node.MarkNodeType(targetType)
 	return nil
 }
 
 func (ta *typeAnalyzer) ifStmt(node *ast.IfStmt) error {
 	ta.markType(node.Test)
 
-	if node.Test.NodeType().Kind() != types.BoolKind && !types.CanDoImplicitConversion(node.Test.NodeType(), types.Bool) {
+	if node.Test.NodeType() != nil && node.Test.NodeType().Kind() != types.BoolKind && !ta.canConvert(node.Test.NodeType(), types.Bool) {
 		return fmt.Errorf("if语句的条件表达式需为bool类型，而不是%s", node.Test.NodeType().String())
 	}
 
@@ -532,6 +1030,9 @@ func (ta *typeAnalyzer) ifStmt(node *ast.IfStmt) error {
 		falseType = node.False.NodeType()
 	}
 
+	if trueType == nil || falseType == nil {
+		return nil
+	}
 	node.MarkNodeType(types.Union(trueType, falseType))
 	return nil
 }
@@ -542,14 +1043,15 @@ func (ta *typeAnalyzer) caseClause(node *ast.CaseClause) error {
 		return fmt.Errorf("case子语句必须在switch语句中使用")
 	}
 
-	if s.Target == nil {
-		ta.markType(node.Cond)
-		if node.Cond.NodeType().Kind() != types.BoolKind && !types.CanDoImplicitConversion(node.Cond.NodeType(), types.Bool) {
+	ta.markType(node.Cond)
+	if node.Cond.NodeType() == nil || s.Target != nil && s.Target.NodeType() == nil {
+		// already reported
+	} else if s.Target == nil {
+		if node.Cond.NodeType().Kind() != types.BoolKind && !ta.canConvert(node.Cond.NodeType(), types.Bool) {
 			return fmt.Errorf("如果不提供switch的对象，则case子句的条件必须为bool类型，而不是%s", node.Cond.NodeType().String())
 		}
 	} else {
-		ta.markType(node.Cond)
-		if !types.Equal(node.Cond.NodeType(), s.Target.NodeType()) && !types.CanDoImplicitConversion(node.Cond.NodeType(), s.Target.NodeType()) {
+		if !types.Equal(node.Cond.NodeType(), s.Target.NodeType()) && !ta.canConvert(node.Cond.NodeType(), s.Target.NodeType()) {
 			return fmt.Errorf("case子句的条件类型是%s，而需要的类型是%s", node.Cond.NodeType().String(), s.Target.NodeType().String())
 		}
 	}
@@ -569,17 +1071,44 @@ func (ta *typeAnalyzer) switchStmt(node *ast.SwitchStmt) error {
 		ts = append(ts, c.NodeType())
 	}
 
-	ta.markType(node.Default)
-	ts = append(ts, node.Default.NodeType())
+	if node.Default != nil {
+		ta.markType(node.Default)
+		ts = append(ts, node.Default.NodeType())
+	} else if node.Target != nil && node.Target.NodeType() != nil && node.Target.NodeType().Kind() == types.EnumKind {
+		ta.checkExhaustive(node)
+	}
 
 	node.MarkNodeType(types.UnionOf(ts))
 	return nil
 }
 
+// checkExhaustive warns about the fields of an enum which are not handled by
+// a switch without default case
+func (ta *typeAnalyzer) checkExhaustive(node *ast.SwitchStmt) {
+	enum := types.Peel(node.Target.NodeType())
+	handled := map[string]bool{}
+	for _, c := range node.Cases {
+		attr, ok := c.Cond.(*ast.AttrExpr)
+		if ok && types.Equal(attr.NodeType(), enum) {
+			handled[attr.Name] = true
+		}
+	}
+
+	missing := []string{}
+	for _, f := range enum.Fields() {
+		if !handled[f.Name] {
+			missing = append(missing, f.Name)
+		}
+	}
+	if len(missing) > 0 {
+		ta.warn(node, "switch on enum '%s' does not handle %s", enum.String(), strings.Join(missing, ", "))
+	}
+}
+
 func (ta *typeAnalyzer) whileStmt(node *ast.WhileStmt) error {
 	ta.markType(node.Test)
 
-	if node.Test.NodeType().Kind() != types.BoolKind && !types.CanDoImplicitConversion(node.Test.NodeType(), types.Bool) {
+	if node.Test.NodeType() != nil && node.Test.NodeType().Kind() != types.BoolKind && !ta.canConvert(node.Test.NodeType(), types.Bool) {
 		return fmt.Errorf("while语句的条件表达式需为bool类型，而不是%s", node.Test.NodeType().String())
 	}
 
@@ -606,6 +1135,16 @@ func (ta *typeAnalyzer) forStmt(node *ast.ForStmt) error {
 		return fmt.Errorf("for循环的终值只能是整数或浮点数")
 	}
 
+	ta.enterScope(node)
+	defer ta.exitScope()
+	if err := ta.registerVariable(node.Counter, variable{
+		twq: types.TypeWithQualifier{
+			Type: types.Peel(node.Init.NodeType()),
+		},
+	}, node); err != nil {
+		return err
+	}
+
 	ta.markType(node.Body)
 	node.MarkNodeType(node.Body.NodeType())
 	return nil
@@ -642,90 +1181,279 @@ func (ta *typeAnalyzer) paramDecl(node *ast.ParamDecl) error {
 	if node.Type != nil {
 		ta.markType(node.Type)
 		formalType = node.Type.NodeType()
+		if formalType == nil {
+			return nil
+		}
 	}
 
 	if node.Default != nil {
 		ta.markType(node.Default)
 		initType := node.Default.NodeType()
+		if initType == nil {
+			return nil
+		}
 
 		if formalType.Kind() == types.UncertainKind {
 			formalType = initType
 		} else {
-			if !types.Equal(formalType, initType) && !types.CanDoImplicitConversion(initType, formalType) {
+			if !types.Equal(formalType, initType) && !ta.canConvert(initType, formalType) {
 				return fmt.Errorf("参数%s的类型为%s，但其初始值的类型却是%s", node.Name, formalType, initType)
 			}
 		}
 	}
 
 	node.MarkNodeType(types.TypeWithQualifier{
-		Type: formalType,
+		Type:      formalType,
 		Qualifier: qualifier,
 	})
 	return nil
 }
 
+// defaultText renders the default value of a parameter for signatures,
+// which are mostly literals and builtin constants like 'color.red'. Other
+// values are left out.
+func defaultText(node ast.Node) string {
+	switch n := node.(type) {
+	case *ast.BoolLiteral:
+		return strconv.FormatBool(n.Value)
+	case *ast.IntLiteral:
+		return strconv.FormatInt(n.Value, 10)
+	case *ast.FloatLiteral:
+		text := strconv.FormatFloat(n.Value, 'g', -1, 64)
+		if !strings.ContainsAny(text, ".e") {
+			text += ".0"
+		}
+		return text
+	case *ast.StringLiteral:
+		return n.Raw
+	case *ast.Identifier:
+		return n.Name
+	case *ast.AttrExpr:
+		if target := defaultText(n.Target); target != "" {
+			return target + "." + n.Name
+		}
+	case *ast.UnaryExpr:
+		if expr := defaultText(n.Expr); expr != "" && n.Op == "-" {
+			return "-" + expr
+		}
+	}
+	return ""
+}
+
 func (ta *typeAnalyzer) funcDeclStmt(node *ast.FuncDeclStmt) error {
+	// declared before the parameters, which are in the scope of the function
+	sym := ta.declare(node.Name, FunctionSymbol, nil, node)
+	ta.enterScope(node)
+	defer ta.exitScope()
+
 	ins := []types.TypeWithName{}
 	for _, p := range node.Params {
 		ta.markType(p)
+		if p.NodeType() == nil {
+			// keep checking the body, with a parameter of unknown type
+			p.MarkNodeType(types.TypeWithQualifier{
+				Type: types.Uncertain,
+			})
+		}
 		ins = append(ins, types.TypeWithName{
-			Name: p.Name,
-			Type: p.NodeType(),
+			Name:     p.Name,
+			Type:     p.NodeType(),
 			Optional: p.Default != nil,
+			Default:  defaultText(p.Default),
 		})
+		twq, _ := p.NodeType().(types.TypeWithQualifier)
+		if err := ta.registerVariable(p.Name, variable{
+			kind: paramVariable,
+			twq:  twq,
+		}, p); err != nil {
+			return err
+		}
 	}
 
 	ta.markType(node.Body)
+	if node.Body.NodeType() == nil {
+		return nil
+	}
 	fnType := types.FunctionOf(ins, node.Body.NodeType())
 
 	node.MarkNodeType(fnType)
+	sym.Type = fnType
+
+	if node.Method && len(ins) == 0 {
+		return fmt.Errorf("method '%s' must have at least one parameter, for the object it is called on", node.Name)
+	}
+
+	// functions with the same name are overloads of each other
+	fn := types.BuiltinFunction{
+		Name:       node.Name,
+		Types:      []types.Type{fnType},
+		Method:     node.Method,
+		SideEffect: callsSideEffect(node.Body),
+	}
+	if prev, ok := ta.userNS.Callables[node.Name].(types.BuiltinFunction); ok {
+		for _, t := range prev.Types {
+			if sameParams(t, fnType) {
+				return fmt.Errorf("function '%s' is already defined with the same parameters", node.Name)
+			}
+		}
+		fn.Types = append(prev.Types, fn.Types...)
+		fn.Method = fn.Method || prev.Method
+		fn.SideEffect = fn.SideEffect || prev.SideEffect
+	}
+	ta.userNS.Callables[node.Name] = fn
 	return nil
 }
 
+// sameParams tells whether two functions take parameters of the same types,
+// so that a call can not tell them apart. Parameters without a type match
+// each other.
+func sameParams(f1, f2 types.Type) bool {
+	if f1.Count() != f2.Count() {
+		return false
+	}
+	for i := 0; i < f1.Count(); i++ {
+		t1, t2 := f1.In(i).Type, f2.In(i).Type
+		if t1.Kind() == types.UncertainKind && t2.Kind() == types.UncertainKind {
+			continue
+		}
+		if !types.Equal(t1, t2) {
+			return false
+		}
+	}
+	return true
+}
+
 func (ta *typeAnalyzer) memberDecl(node *ast.MemberDecl) error {
+	p, ok := node.Parent().(*ast.TypeDeclStmt)
+	if !ok {
+		return fmt.Errorf("成员变量定义语句只能在定义类型的上下文中使用")
+	}
+
+	switch declModeOf(node.DeclMode) {
+	case DeclVar:
+		return fmt.Errorf("field '%s' cannot be declared with 'var', only 'varip' is allowed on fields", node.Name)
+	case DeclVarip:
+		if !ta.scriptKind.allowsVarip() {
+			return fmt.Errorf("'varip' field '%s' can only be declared in indicators or strategies", node.Name)
+		}
+	}
+
+	if node.Type == nil {
+		return fmt.Errorf("自定义类型%s的成员变量%s缺少类型声明", p.Name, node.Name)
+	}
+
 	ta.markType(node.Type)
 	formalType := node.Type.NodeType()
 	if formalType == nil {
 		return nil
 	}
 
-	if p, ok := node.Parent().(*ast.TypeDeclStmt); p != nil && ok {
-		return fmt.Errorf("成员变量定义语句只能在定义类型的上下文中使用")
-	} else {
-		if node.Default != nil {
-			switch node.Default.(type) {
-			case *ast.IntLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.ColorLiteral, *ast.BoolLiteral, *ast.Identifier:
-				ta.markType(node.Default)
-				if !types.Equal(node.Default.NodeType(), formalType) && types.CanDoImplicitConversion(node.Default.NodeType(), formalType) {
-					return fmt.Errorf("自定义类型%s的成员变量%s类型为%s，但其初始值的类型却是%s", p.Name, node.Name, formalType.String(), node.Default.NodeType().String())
-				}
-			default:
-				return fmt.Errorf("只能使用内置变量或字面量声明成员变量的默认值")
+	if node.Default != nil {
+		switch node.Default.(type) {
+		case *ast.IntLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.ColorLiteral, *ast.BoolLiteral, *ast.Identifier:
+			ta.markType(node.Default)
+			defaultType := node.Default.NodeType()
+			if defaultType != nil && !types.Equal(defaultType, formalType) && !ta.canConvert(defaultType, formalType) {
+				return fmt.Errorf("自定义类型%s的成员变量%s类型为%s，但其初始值的类型却是%s", p.Name, node.Name, formalType.String(), defaultType.String())
 			}
+		default:
+			return fmt.Errorf("只能使用内置变量或字面量声明成员变量的默认值")
+		}
+	}
+	node.MarkNodeType(formalType)
+	return nil
+}
+
+// declareTypes registers every user defined type before any of them is
+// analyzed, so fields can refer to types declared later in the script, or
+// to the type being declared itself
+func (ta *typeAnalyzer) declareTypes(root ast.Node) {
+	stmts := []ast.Node{root}
+	if suite, ok := root.(*ast.Suite); ok {
+		stmts = suite.Body
+	}
+
+	for _, stmt := range stmts {
+		switch td := stmt.(type) {
+		case *ast.TypeDeclStmt:
+			if _, err := ta.userNS.FindType(td.Name); err == nil {
+				ta.report(td, fmt.Errorf("类型%s被重定义", td.Name))
+				continue
+			}
+			st := types.StructOf(td.Name, nil)
+			ta.structs[td] = st
+			ta.userNS.Types[td.Name] = types.NewTocType(st)
+			ta.declare(td.Name, TypeSymbol, st, td).Members = newScope(td, nil)
+		case *ast.EnumDeclStmt:
+			if _, err := ta.userNS.FindType(td.Name); err == nil {
+				ta.report(td, fmt.Errorf("类型%s被重定义", td.Name))
+				continue
+			}
+			fields := []string{}
+			for _, f := range td.Fields {
+				fields = append(fields, f.Name)
+			}
+			et := types.EnumOf(td.Name, fields)
+			ta.enums[td] = et
+			ta.userNS.Types[td.Name] = types.NewTocType(et)
+			ta.declare(td.Name, EnumSymbol, et, td).Members = newScope(td, nil)
 		}
-		node.MarkNodeType(formalType)
-		return nil
 	}
 }
 
 func (ta *typeAnalyzer) typeDeclStmt(node *ast.TypeDeclStmt) error {
-	if _, err := ta.userNS.FindType(node.Name); err == nil {
-		return fmt.Errorf("类型%s被重定义", node.Name)
+	st, ok := ta.structs[node]
+	if !ok {
+		if _, err := ta.userNS.FindType(node.Name); err == nil {
+			// already reported by declareTypes
+			return nil
+		}
+		return fmt.Errorf("类型%s只能在全局作用域中定义", node.Name)
 	}
 
+	members := ta.info.Root.Symbols[node.Name].Members
 	fields := []types.TypeWithName{}
 	for _, m := range node.Members {
 		ta.markType(m)
+		if _, ok := members.Symbols[m.Name]; !ok {
+			ta.declareMember(members, m.Name, FieldSymbol, m.NodeType(), m)
+		}
 		fields = append(fields, types.TypeWithName{
 			Name:     m.Name,
 			Type:     m.NodeType(),
 			Optional: m.Default != nil,
 		})
 	}
-	st := types.StructOf(fields)
+	types.SetFields(st, fields)
 	node.MarkNodeType(st)
+	return nil
+}
+
+func (ta *typeAnalyzer) enumDeclStmt(node *ast.EnumDeclStmt) error {
+	et, ok := ta.enums[node]
+	if !ok {
+		if _, err := ta.userNS.FindType(node.Name); err == nil {
+			// already reported by declareTypes
+			return nil
+		}
+		return fmt.Errorf("enum %s can only be declared in the global scope", node.Name)
+	}
 
-	ta.userNS.Types[node.Name] = types.NewTocType(st)
+	members := ta.info.Root.Symbols[node.Name].Members
+	seen := map[string]bool{}
+	for _, f := range node.Fields {
+		if seen[f.Name] {
+			ta.report(f, fmt.Errorf("enum field '%s.%s' is declared more than once", node.Name, f.Name))
+		} else {
+			ta.declareMember(members, f.Name, EnumFieldSymbol, et, f)
+		}
+		seen[f.Name] = true
+		if f.Title != nil {
+			ta.markType(f.Title)
+		}
+		f.MarkNodeType(et)
+	}
+	node.MarkNodeType(et)
 	return nil
 }
 
@@ -735,11 +1463,15 @@ func (ta *typeAnalyzer) importStmt(node *ast.ImportStmt) error {
 }
 
 func (ta *typeAnalyzer) suite(node *ast.Suite) error {
-	ta.enterScope()
+	ta.enterScope(node)
 	for _, stmt := range node.Body {
 		ta.markType(stmt)
 	}
 	ta.exitScope()
+	if len(node.Body) == 0 {
+		node.MarkNodeType(types.Void)
+		return nil
+	}
 	node.MarkNodeType(node.Body[len(node.Body)-1].NodeType())
 	return nil
 }
@@ -0,0 +1,250 @@
+package analyzer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kvarenzn/pinecone/ast"
+	"github.com/kvarenzn/pinecone/builtins"
+	"github.com/kvarenzn/pinecone/metainfo"
+	"github.com/kvarenzn/pinecone/parser"
+	"github.com/kvarenzn/pinecone/tokenizer"
+	"github.com/kvarenzn/pinecone/types"
+)
+
+// parseScript parses a script which is expected to have no syntax errors
+func parseScript(t *testing.T, src string) ast.Node {
+	t.Helper()
+	tokens, tokenErrs := tokenizer.Tokenize(src)
+	if len(tokenErrs) > 0 {
+		t.Fatal(tokenErrs)
+	}
+	stmts, errs := parser.Parse(tokens)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	return &ast.Suite{Body: stmts}
+}
+
+func TestUntypedOperands(t *testing.T) {
+	// each script uses the unknown name 'nosuch' where a type is needed,
+	// it is reported and checking the code around it must not panic
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"generic type argument", "array<nosuch> a = na\nb = a.size()\n"},
+		{"generic type name", "nosuch<int> a = na\n"},
+		{"binary operand", "a = nosuch + 1\n"},
+		{"unary operand", "a = -nosuch\n"},
+		{"tuple item", "f() =>\n    [nosuch, 1]\n[a, b] = f()\nc = a + b\n"},
+		{"ternary branch", "a = true ? nosuch : 1\n"},
+		{"tuple declaration", "[a, b] = nosuch\n"},
+		{"if condition", "if nosuch\n    a = 1\n"},
+		{"if branch", "a = if true\n    nosuch\nelse\n    1\n"},
+		{"while condition", "while nosuch\n    a = 1\n"},
+		{"parameter type", "f(nosuch x = 1) => x\n"},
+		{"parameter default", "f(x = nosuch) => x\na = f()\n"},
+		{"untyped parameter", "f(nosuch x) => x\na = f(1)\n"},
+	}
+
+	for _, test := range tests {
+		root := parseScript(t, "//@version=5\n"+test.src)
+		errs := AnalyzeTypeIn(metainfo.V5, builtins.GlobalNamespace, root)
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), "nosuch") {
+			t.Errorf("%s: got %v, want an error about 'nosuch'", test.name, errs)
+		}
+		for _, err := range errs {
+			// a nil type was formatted
+			if strings.Contains(err.Error(), "%!") {
+				t.Errorf("%s: %v", test.name, err)
+			}
+		}
+	}
+}
+
+func TestEmptyScript(t *testing.T) {
+	root := &ast.Suite{}
+	if errs := AnalyzeTypeIn(metainfo.V5, builtins.GlobalNamespace, root); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if root.NodeType() == nil || root.NodeType().Kind() != types.VoidKind {
+		t.Errorf("an empty script has type %v, want void", root.NodeType())
+	}
+}
+
+func TestVaripOnlyInIndicatorsAndStrategies(t *testing.T) {
+	tests := []struct {
+		decl   string
+		reject bool
+	}{
+		{`indicator("x")`, false},
+		{`strategy("x")`, false},
+		{`library("x")`, true},
+		{``, true},
+	}
+
+	for _, test := range tests {
+		root := parseScript(t, "//@version=5\n"+test.decl+"\nvarip int n = 0\n")
+		rejected := false
+		for _, err := range AnalyzeTypeIn(metainfo.V5, builtins.GlobalNamespace, root) {
+			if strings.Contains(err.Error(), "'varip'") {
+				rejected = true
+			}
+		}
+		if rejected != test.reject {
+			t.Errorf("%q: varip rejected %v, want %v", test.decl, rejected, test.reject)
+		}
+	}
+}
+
+func TestFunctionRedefinition(t *testing.T) {
+	root := parseScript(t, `//@version=5
+indicator("x")
+f(int x) => x
+f(float x) => x
+f(int y) => y + 1
+g(x) => x
+g(y) => y
+`)
+	rows := []int{}
+	for _, err := range AnalyzeTypeIn(metainfo.V5, builtins.GlobalNamespace, root) {
+		if strings.Contains(err.Error(), "already defined") {
+			rows = append(rows, err.(Error).Begin.Row)
+		}
+	}
+	if len(rows) != 2 || rows[0] != 5 || rows[1] != 7 {
+		t.Errorf("redefinitions reported on rows %v, want [5 7]", rows)
+	}
+}
+
+func TestUnknownCallee(t *testing.T) {
+	// an unknown function is reported once, and neither the call nor the
+	// declaration of 'x' panics
+	root := parseScript(t, "//@version=5\nindicator(\"x\")\nx = nosuchfunction(1)\ny = math.abs(-1)\n")
+	errs := AnalyzeTypeIn(metainfo.V5, builtins.GlobalNamespace, root)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "nosuchfunction") {
+		t.Errorf("got %v, want an error about 'nosuchfunction'", errs)
+	}
+}
+
+func TestVarFrozenOnFirstBar(t *testing.T) {
+	tests := []struct {
+		decl string
+		warn bool
+	}{
+		{"var float x = close", true},
+		{"varip float x = close", true},
+		{"float x = close", false},
+		{"var float x = 0.0", false},
+	}
+
+	for _, test := range tests {
+		root := parseScript(t, "//@version=5\nindicator(\"x\")\n"+test.decl+"\n")
+		warned := false
+		for _, err := range AnalyzeTypeIn(metainfo.V5, builtins.GlobalNamespace, root) {
+			if w, ok := err.(Warning); ok && strings.Contains(w.Msg, "frozen") {
+				warned = true
+			} else {
+				t.Errorf("%q: %v", test.decl, err)
+			}
+		}
+		if warned != test.warn {
+			t.Errorf("%q: warned %v, want %v", test.decl, warned, test.warn)
+		}
+	}
+}
+
+func TestVarOnFields(t *testing.T) {
+	tests := []struct {
+		field  string
+		reject bool
+	}{
+		{"var int n = 0", true},
+		{"varip int n = 0", false},
+		{"int n = 0", false},
+	}
+
+	for _, test := range tests {
+		root := parseScript(t, "//@version=5\nindicator(\"x\")\ntype Counter\n    "+test.field+"\n")
+		rejected := false
+		for _, err := range AnalyzeTypeIn(metainfo.V5, builtins.GlobalNamespace, root) {
+			if strings.Contains(err.Error(), "cannot be declared with 'var'") {
+				rejected = true
+			} else {
+				t.Errorf("%q: %v", test.field, err)
+			}
+		}
+		if rejected != test.reject {
+			t.Errorf("%q: rejected %v, want %v", test.field, rejected, test.reject)
+		}
+	}
+}
+
+func TestReassignment(t *testing.T) {
+	tests := []struct {
+		src string
+		// a part of the expected error, empty if the script is valid
+		err string
+	}{
+		{"x = 1\nx := 2\n", ""},
+		{"x = 1.0\nx := 2\n", ""},
+		{"x = 1\nx += 2\n", ""},
+		{"y := 1\n", "'y': variable is not declared"},
+		{"x = 1\nx := \"a\"\n", "cannot assign a 'string' value to a 'int' target"},
+		{"const int c = 1\nc := 2\n", "'c': variable is declared as const"},
+		{"f(int p) =>\n    p := 2\n    p\n", "'p': function parameters are read-only"},
+	}
+
+	for _, test := range tests {
+		root := parseScript(t, "//@version=5\nindicator(\"x\")\n"+test.src)
+		errs := AnalyzeTypeIn(metainfo.V5, builtins.GlobalNamespace, root)
+		if test.err == "" {
+			if len(errs) != 0 {
+				t.Errorf("%q: unexpected errors %v", test.src, errs)
+			}
+			continue
+		}
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), test.err) {
+			t.Errorf("%q: got %v, want an error about %q", test.src, errs, test.err)
+		}
+	}
+}
+
+func TestMemberDeclOutsideType(t *testing.T) {
+	// the parser only produces fields inside type declarations, so the
+	// tree is built by hand
+	var root ast.Node = &ast.Suite{Body: []ast.Node{
+		&ast.MemberDecl{Type: &ast.SimpleType{Name: "float"}, Name: "x"},
+	}}
+	errs := AnalyzeTypeIn(metainfo.V5, builtins.GlobalNamespace, root)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "成员变量定义语句只能在定义类型的上下文中使用") {
+		t.Errorf("got %v, want a field outside a type", errs)
+	}
+
+	root = parseScript(t, "//@version=5\nindicator(\"x\")\ntype Point\n    float x = 0.0\n")
+	if errs := AnalyzeTypeIn(metainfo.V5, builtins.GlobalNamespace, root); len(errs) != 0 {
+		t.Errorf("fields inside a type: unexpected errors %v", errs)
+	}
+}
+
+func TestTypeUsedBeforeDeclaration(t *testing.T) {
+	root := parseScript(t, `//@version=5
+indicator("x")
+type Segment
+    Point a
+    Point b
+    Segment next
+type Point
+    float x = 0.0
+    float y = 0.0
+f() =>
+    p = Point.new()
+    p.x
+s = Segment.new(Point.new(), Point.new())
+y = s.b.y
+`)
+	if errs := AnalyzeTypeIn(metainfo.V5, builtins.GlobalNamespace, root); len(errs) != 0 {
+		t.Errorf("unexpected errors %v", errs)
+	}
+}
@@ -0,0 +1,132 @@
+package analyzer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kvarenzn/pinecone/ast"
+	"github.com/kvarenzn/pinecone/metainfo"
+	"github.com/kvarenzn/pinecone/types"
+)
+
+// Rules which changed in Pine v6 and are not about conversions, those are in
+// types.CanDoImplicitConversionIn.
+
+// detectDynamicRequests tells whether the 'request.*' functions of a script
+// can be called in local scopes and with series arguments. This is the
+// default since v6, and before it needs 'dynamic_requests = true' in the
+// declaration of the script.
+func detectDynamicRequests(version metainfo.LanguageVersion, root ast.Node) bool {
+	dynamic := version >= metainfo.V6
+	if call := declaration(root); call != nil {
+		for _, arg := range call.Args {
+			if kw, ok := arg.(*ast.KwArg); ok && kw.Name == "dynamic_requests" {
+				if b, ok := kw.Value.(*ast.BoolLiteral); ok {
+					dynamic = b.Value
+				}
+			}
+		}
+	}
+	return dynamic
+}
+
+// checkStaticRequest checks a call to a 'request.*' function in a script
+// without dynamic requests
+func checkStaticRequest(name string, call *ast.CallExpr) error {
+	for p := call.Parent(); p != nil; p = p.Parent() {
+		switch p.(type) {
+		case *ast.IfStmt, *ast.SwitchStmt, *ast.ForStmt, *ast.ForInStmt, *ast.WhileStmt:
+			return fmt.Errorf("'%s' cannot be called in a local scope without dynamic requests, which are the default since v6 or enabled by 'dynamic_requests = true'", name)
+		}
+	}
+
+	// the expression requested is a series, the other arguments are simple
+	expression := -1
+	switch name {
+	case "request.security", "request.security_lower_tf", "request.seed":
+		expression = 2
+	}
+	for i, arg := range call.Args {
+		if kw, ok := arg.(*ast.KwArg); i == expression || ok && kw.Name == "expression" {
+			continue
+		}
+		t := arg.NodeType()
+		if types.Peel(t).Kind() == types.StringKind && t.QualifierKind() == types.Series {
+			return fmt.Errorf("the arguments of '%s' cannot be 'series string' without dynamic requests, which are the default since v6 or enabled by 'dynamic_requests = true'", name)
+		}
+	}
+	return nil
+}
+
+// indexedArrayFunctions are the functions on arrays which take an index,
+// negative indices count from the end of the array since v6
+var indexedArrayFunctions = map[string]bool{
+	"get":    true,
+	"set":    true,
+	"insert": true,
+	"remove": true,
+}
+
+// arrayIndex finds the index argument of a call like 'array.get(a, i)' or
+// 'a.get(i)', or returns nil for calls to other functions
+func arrayIndex(call *ast.CallExpr) ast.Node {
+	attr, ok := call.Func.(*ast.AttrExpr)
+	if !ok || !indexedArrayFunctions[attr.Name] {
+		return nil
+	}
+
+	position := 1
+	if ast.DottedName(attr) != "array."+attr.Name {
+		// a method, called on the array
+		t := attr.Target.NodeType()
+		if t == nil || types.Peel(t).Kind() != types.ArrayKind {
+			return nil
+		}
+		position = 0
+	}
+
+	i := 0
+	for _, arg := range call.Args {
+		if kw, ok := arg.(*ast.KwArg); ok {
+			if kw.Name == "index" {
+				return kw.Value
+			}
+			continue
+		}
+		if i == position {
+			return arg
+		}
+		i++
+	}
+	return nil
+}
+
+// checkVersionRules checks a call, which is analyzed already, against the
+// rules of the language version which do not show in its signature
+func (ta *typeAnalyzer) checkVersionRules(call *ast.CallExpr) error {
+	if name := ast.DottedName(call.Func); !ta.dynamicRequests && strings.HasPrefix(name, "request.") {
+		if err := checkStaticRequest(name, call); err != nil {
+			return err
+		}
+	}
+
+	if ta.version < metainfo.V6 {
+		if index := arrayIndex(call); index != nil {
+			if i, ok := constInt(index); ok && i < 0 {
+				return fmt.Errorf("array index %d is out of bounds, negative indices count from the end of the array only since v6", i)
+			}
+		}
+	}
+	return nil
+}
+
+// checkLazyOperand warns about side effects in the right operand of 'and'
+// and 'or', which is evaluated only if it decides the result since v6
+func (ta *typeAnalyzer) checkLazyOperand(node *ast.BinaryExpr) {
+	if ta.version < metainfo.V6 || node.Op != "and" && node.Op != "or" {
+		return
+	}
+	if callsSideEffect(node.Right) {
+		ta.warn(node.Right, "the right operand of '%s' is only evaluated when the left one does not decide the result since v6, its side effects may not happen", node.Op)
+	}
+}
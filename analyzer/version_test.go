@@ -0,0 +1,101 @@
+package analyzer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kvarenzn/pinecone/builtins"
+	"github.com/kvarenzn/pinecone/metainfo"
+)
+
+func TestVersionRules(t *testing.T) {
+	// each script is checked as a v5 and as a v6 script, an empty message
+	// means no error or warning is expected
+	tests := []struct {
+		name string
+		src  string
+		v5   string
+		v6   string
+	}{
+		{
+			"number as condition",
+			"n = 1\nif n\n    n := 2\n",
+			"",
+			"条件表达式需为bool类型",
+		},
+		{
+			"number operand of 'and'",
+			"n = 1\nb = n and true\n",
+			"",
+			"numbers are no longer casted to bool since v6",
+		},
+		{
+			"side effect in the right operand of 'or'",
+			"var a = array.new<int>()\nf() =>\n    a.push(1)\n    true\nb = close != open or f()\n",
+			"",
+			"the right operand of 'or' is only evaluated",
+		},
+		{
+			"negative array index",
+			"a = array.new<int>(3)\nx = a.get(-1)\n",
+			"array index -1 is out of bounds",
+			"",
+		},
+		{
+			"negative array index of a function",
+			"a = array.new<int>(3)\narray.set(a, -1, 0)\n",
+			"array index -1 is out of bounds",
+			"",
+		},
+		{
+			"request in a local scope",
+			"x = 0.0\nif close != open\n    x := request.security(syminfo.tickerid, \"D\", close)\n",
+			"cannot be called in a local scope",
+			"",
+		},
+		{
+			"request with a series symbol",
+			"f(series string s) => request.security(s, \"D\", close)\n",
+			"cannot be 'series string'",
+			"",
+		},
+		{
+			"request with a series expression",
+			"f(series string s) => request.security(syminfo.tickerid, \"D\", s)\n",
+			"",
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		for _, version := range []metainfo.LanguageVersion{metainfo.V5, metainfo.V6} {
+			want := test.v5
+			if version == metainfo.V6 {
+				want = test.v6
+			}
+			root := parseScript(t, "//@version="+strings.TrimPrefix(version.String(), "v")+"\nindicator(\"x\")\n"+test.src)
+			errs := AnalyzeTypeIn(version, builtins.GlobalNamespace, root)
+			if want == "" {
+				if len(errs) > 0 {
+					t.Errorf("%s in %s: %v", test.name, version, errs)
+				}
+			} else if len(errs) != 1 || !strings.Contains(errs[0].Error(), want) {
+				t.Errorf("%s in %s: got %v, want %q", test.name, version, errs, want)
+			}
+		}
+	}
+}
+
+func TestDynamicRequestsDeclared(t *testing.T) {
+	src := "x = 0.0\nif close != open\n    x := request.security(syminfo.tickerid, \"D\", close)\n"
+
+	root := parseScript(t, "//@version=5\nindicator(\"x\", dynamic_requests = true)\n"+src)
+	if errs := AnalyzeTypeIn(metainfo.V5, builtins.GlobalNamespace, root); len(errs) > 0 {
+		t.Errorf("v5 with dynamic requests: %v", errs)
+	}
+
+	root = parseScript(t, "//@version=6\nindicator(\"x\", dynamic_requests = false)\n"+src)
+	if errs := AnalyzeTypeIn(metainfo.V6, builtins.GlobalNamespace, root); len(errs) != 1 {
+		t.Errorf("v6 without dynamic requests: got %v, want an error", errs)
+	}
+}
@@ -0,0 +1,666 @@
+package ast
+
+import (
+	"fmt"
+	"slices"
+)
+
+// field returns the child of a node held in a field which is not a slice
+func field(parent Node, name string) Node {
+	switch n := parent.(type) {
+	case *SubType:
+		if name == "Name" {
+			return n.Name
+		}
+	case *GenericType:
+		if name == "Name" {
+			return n.Name
+		}
+	case *BinaryExpr:
+		switch name {
+		case "Left":
+			return n.Left
+		case "Right":
+			return n.Right
+		}
+	case *UnaryExpr:
+		if name == "Expr" {
+			return n.Expr
+		}
+	case *AttrExpr:
+		if name == "Target" {
+			return n.Target
+		}
+	case *KwArg:
+		if name == "Value" {
+			return n.Value
+		}
+	case *InstantiationExpr:
+		if name == "Template" {
+			return n.Template
+		}
+	case *CallExpr:
+		if name == "Func" {
+			return n.Func
+		}
+	case *HRefExpr:
+		switch name {
+		case "Series":
+			return n.Series
+		case "Offset":
+			return n.Offset
+		}
+	case *TernaryExpr:
+		switch name {
+		case "Test":
+			return n.Test
+		case "True":
+			return n.True
+		case "False":
+			return n.False
+		}
+	case *ExprStmt:
+		if name == "Expr" {
+			return n.Expr
+		}
+	case *VarDeclStmt:
+		switch name {
+		case "Type":
+			return n.Type
+		case "Initial":
+			return n.Initial
+		}
+	case *TupleDeclStmt:
+		if name == "Initial" {
+			return n.Initial
+		}
+	case *ReassignStmt:
+		switch name {
+		case "Target":
+			return n.Target
+		case "Value":
+			return n.Value
+		}
+	case *IfStmt:
+		switch name {
+		case "Test":
+			return n.Test
+		case "True":
+			return n.True
+		case "False":
+			return n.False
+		}
+	case *CaseClause:
+		switch name {
+		case "Cond":
+			return n.Cond
+		case "Body":
+			return n.Body
+		}
+	case *SwitchStmt:
+		switch name {
+		case "Target":
+			return n.Target
+		case "Default":
+			return n.Default
+		}
+	case *WhileStmt:
+		switch name {
+		case "Test":
+			return n.Test
+		case "Body":
+			return n.Body
+		}
+	case *ForStmt:
+		switch name {
+		case "Init":
+			return n.Init
+		case "Final":
+			return n.Final
+		case "Step":
+			return n.Step
+		case "Body":
+			return n.Body
+		}
+	case *ForInStmt:
+		switch name {
+		case "Container":
+			return n.Container
+		case "Body":
+			return n.Body
+		}
+	case *ParamDecl:
+		switch name {
+		case "Type":
+			return n.Type
+		case "Default":
+			return n.Default
+		}
+	case *FuncDeclStmt:
+		if name == "Body" {
+			return n.Body
+		}
+	case *MemberDecl:
+		switch name {
+		case "Type":
+			return n.Type
+		case "Default":
+			return n.Default
+		}
+	case *EnumField:
+		if name == "Title" {
+			return n.Title
+		}
+	case *Quote:
+		if name == "Content" {
+			return n.Content
+		}
+	}
+	panic(fmt.Sprintf("ast: %T has no field %s", parent, name))
+}
+
+// setField stores a child in a field of a node which is not a slice
+func setField(parent Node, name string, child Node) {
+	switch n := parent.(type) {
+	case *SubType:
+		if name == "Name" {
+			n.Name = child
+			return
+		}
+	case *GenericType:
+		if name == "Name" {
+			n.Name = child
+			return
+		}
+	case *BinaryExpr:
+		switch name {
+		case "Left":
+			n.Left = child
+			return
+		case "Right":
+			n.Right = child
+			return
+		}
+	case *UnaryExpr:
+		if name == "Expr" {
+			n.Expr = child
+			return
+		}
+	case *AttrExpr:
+		if name == "Target" {
+			n.Target = child
+			return
+		}
+	case *KwArg:
+		if name == "Value" {
+			n.Value = child
+			return
+		}
+	case *InstantiationExpr:
+		if name == "Template" {
+			n.Template = child
+			return
+		}
+	case *CallExpr:
+		if name == "Func" {
+			n.Func = child
+			return
+		}
+	case *HRefExpr:
+		switch name {
+		case "Series":
+			n.Series = child
+			return
+		case "Offset":
+			n.Offset = child
+			return
+		}
+	case *TernaryExpr:
+		switch name {
+		case "Test":
+			n.Test = child
+			return
+		case "True":
+			n.True = child
+			return
+		case "False":
+			n.False = child
+			return
+		}
+	case *ExprStmt:
+		if name == "Expr" {
+			n.Expr = child
+			return
+		}
+	case *VarDeclStmt:
+		switch name {
+		case "Type":
+			n.Type = child
+			return
+		case "Initial":
+			n.Initial = child
+			return
+		}
+	case *TupleDeclStmt:
+		if name == "Initial" {
+			n.Initial = child
+			return
+		}
+	case *ReassignStmt:
+		switch name {
+		case "Target":
+			n.Target = child
+			return
+		case "Value":
+			n.Value = child
+			return
+		}
+	case *IfStmt:
+		switch name {
+		case "Test":
+			n.Test = child
+			return
+		case "True":
+			n.True = child
+			return
+		case "False":
+			n.False = child
+			return
+		}
+	case *CaseClause:
+		switch name {
+		case "Cond":
+			n.Cond = child
+			return
+		case "Body":
+			n.Body = child
+			return
+		}
+	case *SwitchStmt:
+		switch name {
+		case "Target":
+			n.Target = child
+			return
+		case "Default":
+			n.Default = child
+			return
+		}
+	case *WhileStmt:
+		switch name {
+		case "Test":
+			n.Test = child
+			return
+		case "Body":
+			n.Body = child
+			return
+		}
+	case *ForStmt:
+		switch name {
+		case "Init":
+			n.Init = child
+			return
+		case "Final":
+			n.Final = child
+			return
+		case "Step":
+			n.Step = child
+			return
+		case "Body":
+			n.Body = child
+			return
+		}
+	case *ForInStmt:
+		switch name {
+		case "Container":
+			n.Container = child
+			return
+		case "Body":
+			n.Body = child
+			return
+		}
+	case *ParamDecl:
+		switch name {
+		case "Type":
+			n.Type = child
+			return
+		case "Default":
+			n.Default = child
+			return
+		}
+	case *FuncDeclStmt:
+		if name == "Body" {
+			n.Body = child
+			return
+		}
+	case *MemberDecl:
+		switch name {
+		case "Type":
+			n.Type = child
+			return
+		case "Default":
+			n.Default = child
+			return
+		}
+	case *EnumField:
+		if name == "Title" {
+			n.Title = child
+			return
+		}
+	case *Quote:
+		if name == "Content" {
+			n.Content = child
+			return
+		}
+	}
+	panic(fmt.Sprintf("ast: %T has no field %s", parent, name))
+}
+
+func toNodes[T Node](items []T) []Node {
+	nodes := make([]Node, len(items))
+	for i, item := range items {
+		nodes[i] = item
+	}
+	return nodes
+}
+
+func fromNodes[T Node](nodes []Node) []T {
+	items := make([]T, len(nodes))
+	for i, n := range nodes {
+		item, ok := n.(T)
+		if !ok {
+			panic(fmt.Sprintf("ast: cannot use %T as %T", n, item))
+		}
+		items[i] = item
+	}
+	return items
+}
+
+// list returns a copy of the children held in a slice field of a node
+func list(parent Node, name string) []Node {
+	switch n := parent.(type) {
+	case *GenericType:
+		if name == "Args" {
+			return toNodes(n.Args)
+		}
+	case *InstantiationExpr:
+		if name == "TypeArgs" {
+			return toNodes(n.TypeArgs)
+		}
+	case *CallExpr:
+		if name == "Args" {
+			return toNodes(n.Args)
+		}
+	case *TupleExpr:
+		if name == "Items" {
+			return toNodes(n.Items)
+		}
+	case *VarDeclStmt:
+		if name == "Annotations" {
+			return toNodes(n.Annotations)
+		}
+	case *SwitchStmt:
+		if name == "Cases" {
+			return toNodes(n.Cases)
+		}
+	case *FuncDeclStmt:
+		switch name {
+		case "Annotations":
+			return toNodes(n.Annotations)
+		case "Params":
+			return toNodes(n.Params)
+		}
+	case *TypeDeclStmt:
+		switch name {
+		case "Annotations":
+			return toNodes(n.Annotations)
+		case "Members":
+			return toNodes(n.Members)
+		}
+	case *EnumDeclStmt:
+		switch name {
+		case "Annotations":
+			return toNodes(n.Annotations)
+		case "Fields":
+			return toNodes(n.Fields)
+		}
+	case *Suite:
+		switch name {
+		case "Annotations":
+			return toNodes(n.Annotations)
+		case "Body":
+			return toNodes(n.Body)
+		}
+	}
+	panic(fmt.Sprintf("ast: %T has no list %s", parent, name))
+}
+
+// setList replaces the children held in a slice field of a node
+func setList(parent Node, name string, nodes []Node) {
+	switch n := parent.(type) {
+	case *GenericType:
+		if name == "Args" {
+			n.Args = nodes
+			return
+		}
+	case *InstantiationExpr:
+		if name == "TypeArgs" {
+			n.TypeArgs = nodes
+			return
+		}
+	case *CallExpr:
+		if name == "Args" {
+			n.Args = nodes
+			return
+		}
+	case *TupleExpr:
+		if name == "Items" {
+			n.Items = nodes
+			return
+		}
+	case *VarDeclStmt:
+		if name == "Annotations" {
+			n.Annotations = fromNodes[*Annotation](nodes)
+			return
+		}
+	case *SwitchStmt:
+		if name == "Cases" {
+			n.Cases = fromNodes[*CaseClause](nodes)
+			return
+		}
+	case *FuncDeclStmt:
+		switch name {
+		case "Annotations":
+			n.Annotations = fromNodes[*Annotation](nodes)
+			return
+		case "Params":
+			n.Params = fromNodes[*ParamDecl](nodes)
+			return
+		}
+	case *TypeDeclStmt:
+		switch name {
+		case "Annotations":
+			n.Annotations = fromNodes[*Annotation](nodes)
+			return
+		case "Members":
+			n.Members = fromNodes[*MemberDecl](nodes)
+			return
+		}
+	case *EnumDeclStmt:
+		switch name {
+		case "Annotations":
+			n.Annotations = fromNodes[*Annotation](nodes)
+			return
+		case "Fields":
+			n.Fields = fromNodes[*EnumField](nodes)
+			return
+		}
+	case *Suite:
+		switch name {
+		case "Annotations":
+			n.Annotations = fromNodes[*Annotation](nodes)
+			return
+		case "Body":
+			n.Body = nodes
+			return
+		}
+	}
+	panic(fmt.Sprintf("ast: %T has no list %s", parent, name))
+}
+
+// shallowCopy copies a node without its children. The slices of children
+// are shared with the original node, but clipped so that appending to them
+// never writes to the original, and the optional strings are copied.
+func shallowCopy(node Node) Node {
+	switch n := node.(type) {
+	case *SimpleType:
+		c := *n
+		return &c
+	case *SubType:
+		c := *n
+		return &c
+	case *GenericType:
+		c := *n
+		c.Args = slices.Clip(n.Args)
+		return &c
+	case *BinaryExpr:
+		c := *n
+		return &c
+	case *UnaryExpr:
+		c := *n
+		return &c
+	case *AttrExpr:
+		c := *n
+		return &c
+	case *KwArg:
+		c := *n
+		return &c
+	case *InstantiationExpr:
+		c := *n
+		c.TypeArgs = slices.Clip(n.TypeArgs)
+		return &c
+	case *CallExpr:
+		c := *n
+		c.Args = slices.Clip(n.Args)
+		return &c
+	case *HRefExpr:
+		c := *n
+		return &c
+	case *BoolLiteral:
+		c := *n
+		return &c
+	case *Identifier:
+		c := *n
+		return &c
+	case *StringLiteral:
+		c := *n
+		return &c
+	case *IntLiteral:
+		c := *n
+		return &c
+	case *FloatLiteral:
+		c := *n
+		return &c
+	case *ColorLiteral:
+		c := *n
+		return &c
+	case *TupleExpr:
+		c := *n
+		c.Items = slices.Clip(n.Items)
+		return &c
+	case *TernaryExpr:
+		c := *n
+		return &c
+	case *ExprStmt:
+		c := *n
+		return &c
+	case *VarDeclStmt:
+		c := *n
+		c.Annotations = slices.Clip(n.Annotations)
+		c.DeclMode = copyString(n.DeclMode)
+		c.Qualifier = copyString(n.Qualifier)
+		return &c
+	case *TupleDeclStmt:
+		c := *n
+		c.Variables = append([]string{}, n.Variables...)
+		return &c
+	case *ReassignStmt:
+		c := *n
+		return &c
+	case *IfStmt:
+		c := *n
+		return &c
+	case *CaseClause:
+		c := *n
+		return &c
+	case *SwitchStmt:
+		c := *n
+		c.Cases = slices.Clip(n.Cases)
+		return &c
+	case *WhileStmt:
+		c := *n
+		return &c
+	case *ForStmt:
+		c := *n
+		return &c
+	case *ForInStmt:
+		c := *n
+		c.Index = copyString(n.Index)
+		return &c
+	case *BreakStmt:
+		c := *n
+		return &c
+	case *ContinueStmt:
+		c := *n
+		return &c
+	case *ParamDecl:
+		c := *n
+		c.Qualifier = copyString(n.Qualifier)
+		return &c
+	case *FuncDeclStmt:
+		c := *n
+		c.Annotations = slices.Clip(n.Annotations)
+		c.Params = slices.Clip(n.Params)
+		return &c
+	case *MemberDecl:
+		c := *n
+		c.DeclMode = copyString(n.DeclMode)
+		return &c
+	case *TypeDeclStmt:
+		c := *n
+		c.Annotations = slices.Clip(n.Annotations)
+		c.Members = slices.Clip(n.Members)
+		return &c
+	case *EnumField:
+		c := *n
+		return &c
+	case *EnumDeclStmt:
+		c := *n
+		c.Annotations = slices.Clip(n.Annotations)
+		c.Fields = slices.Clip(n.Fields)
+		return &c
+	case *ImportStmt:
+		c := *n
+		c.Alias = copyString(n.Alias)
+		return &c
+	case *Annotation:
+		c := *n
+		return &c
+	case *Suite:
+		c := *n
+		c.Annotations = slices.Clip(n.Annotations)
+		c.Body = slices.Clip(n.Body)
+		return &c
+	case *Quote:
+		c := *n
+		return &c
+	}
+	panic(fmt.Sprintf("ast: cannot copy %T", node))
+}
+
+func copyString(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	return &c
+}
@@ -0,0 +1,235 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/kvarenzn/pinecone/metainfo"
+)
+
+var kinds = map[string]reflect.Type{}
+
+func init() {
+	for _, n := range []Node{
+		&SimpleType{}, &SubType{}, &GenericType{}, &BinaryExpr{}, &UnaryExpr{}, &AttrExpr{}, &KwArg{},
+		&InstantiationExpr{}, &CallExpr{}, &HRefExpr{}, &BoolLiteral{}, &Identifier{}, &StringLiteral{},
+		&IntLiteral{}, &FloatLiteral{}, &ColorLiteral{}, &TupleExpr{}, &TernaryExpr{}, &ExprStmt{},
+		&VarDeclStmt{}, &TupleDeclStmt{}, &ReassignStmt{}, &IfStmt{}, &CaseClause{}, &SwitchStmt{},
+		&WhileStmt{}, &ForStmt{}, &ForInStmt{}, &BreakStmt{}, &ContinueStmt{}, &ParamDecl{},
+		&FuncDeclStmt{}, &MemberDecl{}, &TypeDeclStmt{}, &EnumField{}, &EnumDeclStmt{}, &ImportStmt{},
+		&Annotation{}, &Suite{}, &Quote{},
+	} {
+		t := reflect.TypeOf(n).Elem()
+		kinds[t.Name()] = t
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.IsExported() && fieldKey(f) == "" {
+				panic(fmt.Sprintf("ast: field %s.%s has no json key", t.Name(), f.Name))
+			}
+		}
+	}
+}
+
+// fieldKey is the key of a field of a node in JSON and S-expressions, given
+// by its json tag, so that renaming the field in Go does not change them
+func fieldKey(f reflect.StructField) string {
+	return f.Tag.Get("json")
+}
+
+var nodeInterface = reflect.TypeOf((*Node)(nil)).Elem()
+
+// Kind is the name of the type of a node, like "BinaryExpr"
+func Kind(n Node) string {
+	return reflect.TypeOf(n).Elem().Name()
+}
+
+func isNil(n Node) bool {
+	return n == nil || reflect.ValueOf(n).IsNil()
+}
+
+// holdsNode tells if a field holds a node, either as a Node or as a pointer
+// to a concrete node
+func holdsNode(t reflect.Type) bool {
+	return t == nodeInterface || t.Kind() == reflect.Pointer && t.Implements(nodeInterface)
+}
+
+// ToJSON encodes a tree as JSON. Every node is an object with its kind, its
+// range, its type as a string if the analyzer has marked it, followed by its
+// fields in declaration order under the keys given by their json tags.
+func ToJSON(node Node) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeNode(&buf, node); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeJSON(buf *bytes.Buffer, v any) error {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	// Encode ends the value with a newline
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+func encodeNode(buf *bytes.Buffer, n Node) error {
+	if isNil(n) {
+		buf.WriteString("null")
+		return nil
+	}
+
+	v := reflect.ValueOf(n).Elem()
+	t := v.Type()
+	buf.WriteString(`{"kind":`)
+	writeJSON(buf, t.Name())
+	buf.WriteString(`,"begin":`)
+	writeJSON(buf, n.Begin())
+	buf.WriteString(`,"end":`)
+	writeJSON(buf, n.End())
+	if nt := n.NodeType(); nt != nil {
+		buf.WriteString(`,"nodeType":`)
+		writeJSON(buf, nt.String())
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		buf.WriteByte(',')
+		writeJSON(buf, fieldKey(f))
+		buf.WriteByte(':')
+		if err := encodeValue(buf, v.Field(i)); err != nil {
+			return fmt.Errorf("%s.%s: %w", t.Name(), fieldKey(f), err)
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+func encodeValue(buf *bytes.Buffer, v reflect.Value) error {
+	switch {
+	case holdsNode(v.Type()):
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		return encodeNode(buf, v.Interface().(Node))
+	case v.Kind() == reflect.Slice && holdsNode(v.Type().Elem()):
+		buf.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeValue(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	default:
+		return writeJSON(buf, v.Interface())
+	}
+}
+
+// FromJSON decodes a tree encoded by ToJSON and links its nodes to their
+// parents. Node types are not decoded, since only the analyzer can rebuild
+// them.
+func FromJSON(data []byte) (Node, error) {
+	n, err := decodeNode(data)
+	if err != nil {
+		return nil, err
+	}
+	if n != nil {
+		SetParents(n)
+	}
+	return n, nil
+}
+
+func decodeNode(data []byte) (Node, error) {
+	if string(bytes.TrimSpace(data)) == "null" {
+		return nil, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	var kind string
+	if err := json.Unmarshal(fields["kind"], &kind); err != nil {
+		return nil, fmt.Errorf("node without kind")
+	}
+	t, ok := kinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown node kind %q", kind)
+	}
+
+	v := reflect.New(t)
+	n := v.Interface().(Node)
+	var begin, end metainfo.Location
+	if raw, ok := fields["begin"]; ok {
+		if err := json.Unmarshal(raw, &begin); err != nil {
+			return nil, fmt.Errorf("%s.begin: %w", kind, err)
+		}
+	}
+	if raw, ok := fields["end"]; ok {
+		if err := json.Unmarshal(raw, &end); err != nil {
+			return nil, fmt.Errorf("%s.end: %w", kind, err)
+		}
+	}
+	n.SetRange(begin, end)
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		raw, ok := fields[fieldKey(f)]
+		if !ok {
+			continue
+		}
+		if err := decodeValue(raw, v.Elem().Field(i)); err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", kind, fieldKey(f), err)
+		}
+	}
+	return n, nil
+}
+
+func decodeValue(data []byte, v reflect.Value) error {
+	switch {
+	case holdsNode(v.Type()):
+		n, err := decodeNode(data)
+		if err != nil || n == nil {
+			return err
+		}
+		nv := reflect.ValueOf(n)
+		if !nv.Type().AssignableTo(v.Type()) {
+			return fmt.Errorf("expected %s, got %s", v.Type().Elem().Name(), Kind(n))
+		}
+		v.Set(nv)
+		return nil
+	case v.Kind() == reflect.Slice && holdsNode(v.Type().Elem()):
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return err
+		}
+		if items == nil {
+			return nil
+		}
+		s := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := decodeValue(item, s.Index(i)); err != nil {
+				return fmt.Errorf("%d: %w", i, err)
+			}
+		}
+		v.Set(s)
+		return nil
+	default:
+		return json.Unmarshal(data, v.Addr().Interface())
+	}
+}
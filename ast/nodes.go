@@ -95,177 +95,194 @@ func (n *node) SetPathIndex(i int) {
 
 type SimpleType struct {
 	node
-	Name string
+	Name string `json:"name"`
 }
 
 type SubType struct {
 	node
-	Name   Node
-	Member string
+	Name   Node   `json:"name"`
+	Member string `json:"member"`
 }
 
 type GenericType struct {
 	node
-	Name Node
-	Args []Node
+	Name Node   `json:"name"`
+	Args []Node `json:"args"`
 }
 
 type BinaryExpr struct {
 	node
-	Left  Node
-	Op    string
-	Right Node
+	Left  Node   `json:"left"`
+	Op    string `json:"op"`
+	Right Node   `json:"right"`
 }
 
 type UnaryExpr struct {
 	node
-	Op   string
-	Expr Node
+	Op   string `json:"op"`
+	Expr Node   `json:"expr"`
 }
 
 type AttrExpr struct {
 	node
-	Target Node
-	Name   string
+	Target Node   `json:"target"`
+	Name   string `json:"name"`
+}
+
+// DottedName renders an identifier or a chain of attributes on one, like
+// 'ta.sma' or 'chart.point.new', it is empty for any other node
+func DottedName(n Node) string {
+	switch n := n.(type) {
+	case *Identifier:
+		return n.Name
+	case *AttrExpr:
+		if owner := DottedName(n.Target); owner != "" {
+			return owner + "." + n.Name
+		}
+	}
+	return ""
 }
 
 type KwArg struct {
 	node
-	Name  string
-	Value Node
+	Name  string `json:"name"`
+	Value Node   `json:"value"`
 }
 
 type InstantiationExpr struct {
 	node
-	Template Node
-	TypeArgs []Node
+	Template Node   `json:"template"`
+	TypeArgs []Node `json:"typeArgs"`
 }
 
 type CallExpr struct {
 	node
-	Func Node
-	Args []Node
+	Func Node   `json:"func"`
+	Args []Node `json:"args"`
 }
 
 type HRefExpr struct {
 	node
-	Series Node
-	Offset Node
+	Series Node `json:"series"`
+	Offset Node `json:"offset"`
 }
 
 type BoolLiteral struct {
 	node
-	Value bool
+	Value bool `json:"value"`
 }
 
 type Identifier struct {
 	node
-	Name string
+	Name string `json:"name"`
 }
 
 type StringLiteral struct {
 	node
-	Value string
+	Value string `json:"value"`
+	// the literal as written in the source, with its quotes
+	Raw string `json:"raw"`
 }
 
 type IntLiteral struct {
 	node
-	Value int64
+	Value int64 `json:"value"`
 }
 
 type FloatLiteral struct {
 	node
-	Value float64
+	Value float64 `json:"value"`
 }
 
 type ColorLiteral struct {
 	node
-	R float64
-	G float64
-	B float64
-	T float64
+	R float64 `json:"r"`
+	G float64 `json:"g"`
+	B float64 `json:"b"`
+	T float64 `json:"t"`
 }
 
 type TupleExpr struct {
 	node
-	Items []Node
+	Items []Node `json:"items"`
 }
 
 type TernaryExpr struct {
 	node
-	Test  Node
-	True  Node
-	False Node
+	Test  Node `json:"test"`
+	True  Node `json:"true"`
+	False Node `json:"false"`
 }
 
 type ExprStmt struct {
 	node
-	Expr Node
+	Expr Node `json:"expr"`
 }
 
 type VarDeclStmt struct {
 	node
-	DeclMode  *string
-	Qualifier *string
-	Type      Node
-	Name      string
-	Initial   Node
+	Annotations []*Annotation `json:"annotations"`
+	DeclMode    *string       `json:"declMode"`
+	Qualifier   *string       `json:"qualifier"`
+	Type        Node          `json:"type"`
+	Name        string        `json:"name"`
+	Initial     Node          `json:"initial"`
 }
 
 type TupleDeclStmt struct {
 	node
-	Variables []string
-	Initial   Node
+	Variables []string `json:"variables"`
+	Initial   Node     `json:"initial"`
 }
 
 type ReassignStmt struct {
 	node
-	Target Node
-	Op     string
-	Value  Node
+	Target Node   `json:"target"`
+	Op     string `json:"op"`
+	Value  Node   `json:"value"`
 }
 
 type IfStmt struct {
 	node
-	Test  Node
-	True  Node
-	False Node
+	Test  Node `json:"test"`
+	True  Node `json:"true"`
+	False Node `json:"false"`
 }
 
 type CaseClause struct {
 	node
-	Cond Node
-	Body Node
+	Cond Node `json:"cond"`
+	Body Node `json:"body"`
 }
 
 type SwitchStmt struct {
 	node
-	Target  Node
-	Cases   []*CaseClause
-	Default Node
+	Target  Node          `json:"target"`
+	Cases   []*CaseClause `json:"cases"`
+	Default Node          `json:"default"`
 }
 
 type WhileStmt struct {
 	node
-	Test Node
-	Body Node
+	Test Node `json:"test"`
+	Body Node `json:"body"`
 }
 
 type ForStmt struct {
 	node
-	Counter string
-	Init    Node
-	Step    Node
-	Final   Node
-	Body    Node
+	Counter string `json:"counter"`
+	Init    Node   `json:"init"`
+	Step    Node   `json:"step"`
+	Final   Node   `json:"final"`
+	Body    Node   `json:"body"`
 }
 
 type ForInStmt struct {
 	node
-	Index     *string
-	Iterator  string
-	Container Node
-	Body      Node
+	Index     *string `json:"index"`
+	Iterator  string  `json:"iterator"`
+	Container Node    `json:"container"`
+	Body      Node    `json:"body"`
 }
 
 type BreakStmt struct {
@@ -277,48 +294,77 @@ type ContinueStmt struct {
 
 type ParamDecl struct {
 	node
-	Qualifier *string
-	Type      Node
-	Name      string
-	Default   Node
+	Qualifier *string `json:"qualifier"`
+	Type      Node    `json:"type"`
+	Name      string  `json:"name"`
+	Default   Node    `json:"default"`
 }
 
 type FuncDeclStmt struct {
 	node
-	Export bool
-	Method bool
-	Name   string
-	Params []*ParamDecl
-	Body   Node
+	Annotations []*Annotation `json:"annotations"`
+	Export      bool          `json:"export"`
+	Method      bool          `json:"method"`
+	Name        string        `json:"name"`
+	Params      []*ParamDecl  `json:"params"`
+	Body        Node          `json:"body"`
 }
 
 type MemberDecl struct {
 	node
-	Type    Node
-	Name    string
-	Default Node
+	DeclMode *string `json:"declMode"`
+	Type     Node    `json:"type"`
+	Name     string  `json:"name"`
+	Default  Node    `json:"default"`
 }
 
 type TypeDeclStmt struct {
 	node
-	Name    string
-	Members []*MemberDecl
+	Annotations []*Annotation `json:"annotations"`
+	Name        string        `json:"name"`
+	Members     []*MemberDecl `json:"members"`
+}
+
+type EnumField struct {
+	node
+	Name  string `json:"name"`
+	Title Node   `json:"title"`
+}
+
+type EnumDeclStmt struct {
+	node
+	Annotations []*Annotation `json:"annotations"`
+	Export      bool          `json:"export"`
+	Name        string        `json:"name"`
+	Fields      []*EnumField  `json:"fields"`
 }
 
 type ImportStmt struct {
 	node
-	User    string
-	Name    string
-	Version string
-	Alias   *string
+	User    string  `json:"user"`
+	Name    string  `json:"name"`
+	Version string  `json:"version"`
+	Alias   *string `json:"alias"`
+}
+
+// Annotation is a compiler annotation comment, like '//@param x the value'
+// or '//@version=5'
+type Annotation struct {
+	node
+	Name  string `json:"name"`
+	Value string `json:"value"`
 }
 
+// Suite is a block of statements. The root suite of a script also holds
+// the file-level annotations, like '//@version=5', and the annotations
+// after the last statement.
 type Suite struct {
 	node
-	Body []Node
+	Annotations []*Annotation `json:"annotations"`
+	Body        []Node        `json:"body"`
 }
 
 type Quote struct {
 	node
-	Content Node
+	Content Node `json:"content"`
 }
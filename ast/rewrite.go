@@ -0,0 +1,290 @@
+package ast
+
+import "github.com/kvarenzn/pinecone/metainfo"
+
+// Clone makes a deep copy of a node. The copy has no parent, and the nodes
+// inside it are linked to their copied parents.
+func Clone(node Node) Node {
+	if node == nil {
+		return nil
+	}
+
+	c := clone(node)
+	c.SetParent(nil)
+	c.SetPathAttribute("")
+	c.SetPathIndex(-1)
+	SetParents(c)
+	return c
+}
+
+func clone(node Node) Node {
+	c := shallowCopy(node)
+	lists := map[string][]Node{}
+	order := []string{}
+	for _, child := range Children(node) {
+		if child.Index < 0 {
+			setField(c, child.Attribute, clone(child.Node))
+			continue
+		}
+		if _, ok := lists[child.Attribute]; !ok {
+			order = append(order, child.Attribute)
+		}
+		lists[child.Attribute] = append(lists[child.Attribute], clone(child.Node))
+	}
+	for _, name := range order {
+		setList(c, name, lists[name])
+	}
+	return c
+}
+
+func link(parent, child Node, name string, index int) {
+	child.SetParent(parent)
+	child.SetPathAttribute(name)
+	child.SetPathIndex(index)
+}
+
+func relinkList(parent Node, name string) {
+	for i, n := range list(parent, name) {
+		link(parent, n, name, i)
+	}
+}
+
+// takeRange gives a node built by a rewrite the range of the node it
+// replaces, so that positions stay meaningful
+func takeRange(n, old Node) {
+	if old != nil && n.Begin() == (metainfo.Location{}) && n.End() == (metainfo.Location{}) {
+		n.SetRange(old.Range())
+	}
+}
+
+// fitRange makes a suite cover its statements after they are changed
+func fitRange(parent Node) {
+	if s, ok := parent.(*Suite); ok && len(s.Body) > 0 {
+		s.SetRange(s.Body[0].Begin(), s.Body[len(s.Body)-1].End())
+	}
+}
+
+// Replace puts new in the place of old, which must be a child of parent
+// with up to date parent links. It reports whether old was found.
+func Replace(parent, old, new Node) bool {
+	if old == nil || new == nil || old.Parent() != parent {
+		return false
+	}
+
+	name, index := old.PathAttribute(), old.PathIndex()
+	if index < 0 {
+		if field(parent, name) != old {
+			return false
+		}
+		setField(parent, name, new)
+	} else {
+		nodes := list(parent, name)
+		if index >= len(nodes) || nodes[index] != old {
+			return false
+		}
+		nodes[index] = new
+		setList(parent, name, nodes)
+	}
+
+	takeRange(new, old)
+	link(parent, new, name, index)
+	SetParents(new)
+	old.SetParent(nil)
+	fitRange(parent)
+	return true
+}
+
+// A Cursor describes a node encountered during Apply, and allows to change
+// it in place
+type Cursor struct {
+	parent Node
+	name   string
+	iter   *iterator
+	node   Node
+}
+
+type iterator struct {
+	index int
+	step  int
+}
+
+func (c *Cursor) Node() Node {
+	return c.node
+}
+
+func (c *Cursor) Parent() Node {
+	return c.parent
+}
+
+// Name is the name of the field of the parent which holds the node
+func (c *Cursor) Name() string {
+	return c.name
+}
+
+// Index is the index of the node in a slice field of its parent, or -1 if
+// the field is not a slice
+func (c *Cursor) Index() int {
+	if c.iter == nil {
+		return -1
+	}
+	return c.iter.index
+}
+
+// Replace replaces the current node. The new node is not walked by Apply,
+// neither are the children of the replaced node when it is replaced by pre;
+// post is still called with the new node.
+func (c *Cursor) Replace(n Node) {
+	if c.iter == nil {
+		setField(c.parent, c.name, n)
+	} else {
+		nodes := list(c.parent, c.name)
+		nodes[c.iter.index] = n
+		setList(c.parent, c.name, nodes)
+	}
+
+	takeRange(n, c.node)
+	link(c.parent, n, c.name, c.Index())
+	SetParents(n)
+	c.node.SetParent(nil)
+	c.node = n
+	fitRange(c.parent)
+}
+
+// Delete removes the current node from the slice holding it
+func (c *Cursor) Delete() {
+	if c.iter == nil {
+		panic("ast: Delete of a node not in a slice")
+	}
+
+	nodes := list(c.parent, c.name)
+	i := c.iter.index
+	setList(c.parent, c.name, append(nodes[:i], nodes[i+1:]...))
+	c.node.SetParent(nil)
+	c.iter.step--
+	relinkList(c.parent, c.name)
+	fitRange(c.parent)
+}
+
+// InsertAfter inserts a node after the current one, it is not walked by
+// Apply
+func (c *Cursor) InsertAfter(n Node) {
+	if c.iter == nil {
+		panic("ast: InsertAfter of a node not in a slice")
+	}
+
+	c.insert(c.iter.index+1, n)
+	c.iter.step++
+}
+
+// InsertBefore inserts a node before the current one, it is not walked by
+// Apply
+func (c *Cursor) InsertBefore(n Node) {
+	if c.iter == nil {
+		panic("ast: InsertBefore of a node not in a slice")
+	}
+
+	c.insert(c.iter.index, n)
+	c.iter.index++
+}
+
+func (c *Cursor) insert(i int, n Node) {
+	nodes := list(c.parent, c.name)
+	nodes = append(nodes[:i], append([]Node{n}, nodes[i:]...)...)
+	setList(c.parent, c.name, nodes)
+	SetParents(n)
+	relinkList(c.parent, c.name)
+	fitRange(c.parent)
+}
+
+// ApplyFunc is called by Apply for each node, returning false stops the
+// traversal of the children of the node (for pre) or of the whole tree (for
+// post)
+type ApplyFunc func(*Cursor) bool
+
+type application struct {
+	pre    ApplyFunc
+	post   ApplyFunc
+	cursor Cursor
+	iter   iterator
+}
+
+type abort struct{}
+
+// Apply traverses a tree like golang.org/x/tools/go/ast/astutil.Apply,
+// calling pre and post (if not nil) for each node. The cursor given to them
+// can replace, delete or insert nodes, and parent links and the ranges of
+// suites are kept up to date. Apply returns the root, which may have been
+// replaced.
+func Apply(root Node, pre, post ApplyFunc) (result Node) {
+	holder := &Quote{Content: root}
+	link(holder, root, "Content", -1)
+
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(abort); !ok {
+				panic(r)
+			}
+		}
+		result = holder.Content
+		if result != nil {
+			result.SetParent(nil)
+		}
+	}()
+
+	a := &application{
+		pre:  pre,
+		post: post,
+	}
+	a.apply(holder, "Content", nil, root)
+	return
+}
+
+func (a *application) apply(parent Node, name string, iter *iterator, n Node) {
+	saved := a.cursor
+	a.cursor = Cursor{
+		parent: parent,
+		name:   name,
+		iter:   iter,
+		node:   n,
+	}
+
+	if a.pre == nil || a.pre(&a.cursor) {
+		// a node put in place by pre is not walked, see Cursor.Replace
+		if n != nil && a.cursor.node == n {
+			a.children(n)
+		}
+		if a.post != nil && !a.post(&a.cursor) {
+			panic(abort{})
+		}
+	}
+
+	a.cursor = saved
+}
+
+func (a *application) children(n Node) {
+	seen := map[string]bool{}
+	for _, c := range Children(n) {
+		if seen[c.Attribute] {
+			continue
+		}
+		seen[c.Attribute] = true
+
+		if c.Index < 0 {
+			a.apply(n, c.Attribute, nil, c.Node)
+			continue
+		}
+
+		saved := a.iter
+		a.iter.index = 0
+		for {
+			nodes := list(n, c.Attribute)
+			if a.iter.index >= len(nodes) {
+				break
+			}
+			a.iter.step = 1
+			a.apply(n, c.Attribute, &a.iter, nodes[a.iter.index])
+			a.iter.index += a.iter.step
+		}
+		a.iter = saved
+	}
+}
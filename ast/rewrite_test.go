@@ -0,0 +1,45 @@
+package ast
+
+import "testing"
+
+func TestCloneCopiesStrings(t *testing.T) {
+	mode := "var"
+	decl := &VarDeclStmt{
+		DeclMode: &mode,
+		Name:     "x",
+		Initial:  &ExprStmt{Expr: &IntLiteral{Value: 1}},
+	}
+
+	c := Clone(decl).(*VarDeclStmt)
+	*c.DeclMode = "varip"
+	c.Initial.(*ExprStmt).Expr.(*IntLiteral).Value = 2
+	if want := `(VarDeclStmt annotations:() declMode:"var" qualifier:nil type:nil name:"x" initial:(ExprStmt expr:(IntLiteral value:1)))`; SExpr(decl) != want {
+		t.Errorf("the original changed with its copy:\ngot  %s\nwant %s", SExpr(decl), want)
+	}
+}
+
+func TestApplyReplaceInPre(t *testing.T) {
+	root := &Suite{Body: []Node{
+		&ExprStmt{Expr: &Identifier{Name: "a"}},
+		&ExprStmt{Expr: &Identifier{Name: "b"}},
+	}}
+	SetParents(root)
+
+	// the replacement holds the replaced node, walking it would not end
+	visited := []string{}
+	Apply(root, func(c *Cursor) bool {
+		if id, ok := c.Node().(*Identifier); ok {
+			visited = append(visited, id.Name)
+			c.Replace(&UnaryExpr{Op: "-", Expr: id})
+		}
+		return true
+	}, nil)
+
+	if len(visited) != 2 {
+		t.Errorf("visited %v, want [a b]", visited)
+	}
+	want := `(Suite annotations:() body:((ExprStmt expr:(UnaryExpr op:"-" expr:(Identifier name:"a"))) (ExprStmt expr:(UnaryExpr op:"-" expr:(Identifier name:"b")))))`
+	if got := SExpr(root); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
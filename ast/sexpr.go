@@ -0,0 +1,74 @@
+package ast
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// SExpr prints a tree as a one line S-expression, for golden tests:
+//
+//	(BinaryExpr left:(Identifier name:"a") op:"+" right:(IntLiteral value:1))
+//
+// Ranges and types are left out. Every other field is printed under the key
+// it has in JSON, zero values included, with nil for missing nodes and
+// strings.
+func SExpr(node Node) string {
+	var sb strings.Builder
+	writeSExpr(&sb, node)
+	return sb.String()
+}
+
+func writeSExpr(sb *strings.Builder, n Node) {
+	if isNil(n) {
+		sb.WriteString("nil")
+		return
+	}
+
+	v := reflect.ValueOf(n).Elem()
+	t := v.Type()
+	sb.WriteByte('(')
+	sb.WriteString(t.Name())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fv := v.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		sb.WriteByte(' ')
+		sb.WriteString(fieldKey(f))
+		sb.WriteByte(':')
+		writeSExprValue(sb, fv)
+	}
+	sb.WriteByte(')')
+}
+
+func writeSExprValue(sb *strings.Builder, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			sb.WriteString("nil")
+		} else if holdsNode(v.Type()) {
+			writeSExpr(sb, v.Interface().(Node))
+		} else {
+			writeSExprValue(sb, v.Elem())
+		}
+	case reflect.Slice:
+		sb.WriteByte('(')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				sb.WriteByte(' ')
+			}
+			writeSExprValue(sb, v.Index(i))
+		}
+		sb.WriteByte(')')
+	case reflect.String:
+		sb.WriteString(strconv.Quote(v.String()))
+	case reflect.Bool:
+		sb.WriteString(strconv.FormatBool(v.Bool()))
+	case reflect.Int64:
+		sb.WriteString(strconv.FormatInt(v.Int(), 10))
+	case reflect.Float64:
+		sb.WriteString(strconv.FormatFloat(v.Float(), 'g', -1, 64))
+	}
+}
@@ -0,0 +1,192 @@
+package ast
+
+// Child is a child of a node, with the name of the field of the parent which
+// holds it, and its index if the field is a slice (-1 otherwise)
+type Child struct {
+	Node      Node
+	Attribute string
+	Index     int
+}
+
+type children []Child
+
+func (cs *children) add(name string, n Node) {
+	if n == nil {
+		return
+	}
+	*cs = append(*cs, Child{
+		Node:      n,
+		Attribute: name,
+		Index:     -1,
+	})
+}
+
+func addAll[T Node](cs *children, name string, nodes []T) {
+	for i, n := range nodes {
+		*cs = append(*cs, Child{
+			Node:      n,
+			Attribute: name,
+			Index:     i,
+		})
+	}
+}
+
+// Children lists the children of a node in source order
+func Children(node Node) []Child {
+	cs := children{}
+	switch n := node.(type) {
+	case *SimpleType, *BoolLiteral, *Identifier, *StringLiteral, *IntLiteral, *FloatLiteral, *ColorLiteral, *BreakStmt, *ContinueStmt, *ImportStmt, *Annotation:
+		// leaves
+	case *SubType:
+		cs.add("Name", n.Name)
+	case *GenericType:
+		cs.add("Name", n.Name)
+		addAll(&cs, "Args", n.Args)
+	case *BinaryExpr:
+		cs.add("Left", n.Left)
+		cs.add("Right", n.Right)
+	case *UnaryExpr:
+		cs.add("Expr", n.Expr)
+	case *AttrExpr:
+		cs.add("Target", n.Target)
+	case *KwArg:
+		cs.add("Value", n.Value)
+	case *InstantiationExpr:
+		cs.add("Template", n.Template)
+		addAll(&cs, "TypeArgs", n.TypeArgs)
+	case *CallExpr:
+		cs.add("Func", n.Func)
+		addAll(&cs, "Args", n.Args)
+	case *HRefExpr:
+		cs.add("Series", n.Series)
+		cs.add("Offset", n.Offset)
+	case *TupleExpr:
+		addAll(&cs, "Items", n.Items)
+	case *TernaryExpr:
+		cs.add("Test", n.Test)
+		cs.add("True", n.True)
+		cs.add("False", n.False)
+	case *ExprStmt:
+		cs.add("Expr", n.Expr)
+	case *VarDeclStmt:
+		addAll(&cs, "Annotations", n.Annotations)
+		cs.add("Type", n.Type)
+		cs.add("Initial", n.Initial)
+	case *TupleDeclStmt:
+		cs.add("Initial", n.Initial)
+	case *ReassignStmt:
+		cs.add("Target", n.Target)
+		cs.add("Value", n.Value)
+	case *IfStmt:
+		cs.add("Test", n.Test)
+		cs.add("True", n.True)
+		cs.add("False", n.False)
+	case *CaseClause:
+		cs.add("Cond", n.Cond)
+		cs.add("Body", n.Body)
+	case *SwitchStmt:
+		cs.add("Target", n.Target)
+		addAll(&cs, "Cases", n.Cases)
+		cs.add("Default", n.Default)
+	case *WhileStmt:
+		cs.add("Test", n.Test)
+		cs.add("Body", n.Body)
+	case *ForStmt:
+		cs.add("Init", n.Init)
+		cs.add("Final", n.Final)
+		cs.add("Step", n.Step)
+		cs.add("Body", n.Body)
+	case *ForInStmt:
+		cs.add("Container", n.Container)
+		cs.add("Body", n.Body)
+	case *ParamDecl:
+		cs.add("Type", n.Type)
+		cs.add("Default", n.Default)
+	case *FuncDeclStmt:
+		addAll(&cs, "Annotations", n.Annotations)
+		addAll(&cs, "Params", n.Params)
+		cs.add("Body", n.Body)
+	case *MemberDecl:
+		cs.add("Type", n.Type)
+		cs.add("Default", n.Default)
+	case *TypeDeclStmt:
+		addAll(&cs, "Annotations", n.Annotations)
+		addAll(&cs, "Members", n.Members)
+	case *EnumField:
+		cs.add("Title", n.Title)
+	case *EnumDeclStmt:
+		addAll(&cs, "Annotations", n.Annotations)
+		addAll(&cs, "Fields", n.Fields)
+	case *Suite:
+		addAll(&cs, "Annotations", n.Annotations)
+		addAll(&cs, "Body", n.Body)
+	case *Quote:
+		cs.add("Content", n.Content)
+	}
+	return cs
+}
+
+// A Visitor's Visit method is invoked for each node encountered by Walk. If
+// the result visitor w is not nil, Walk visits each of the children of node
+// with w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses an AST in depth-first order like go/ast.Walk: the visitor
+// sees a node before its children, and is called with nil after them.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	for _, c := range Children(node) {
+		Walk(v, c.Node)
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses an AST in depth-first order. It calls f(node) for each
+// node, and skips the children of a node if f returns false. f is called
+// with nil after the children of a node are visited.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// WalkFunc calls pre before the children of each node are visited and post
+// after them, either of them may be nil. The children of a node are skipped
+// if pre returns false, and so is the call of post on it.
+func WalkFunc(node Node, pre func(Node) bool, post func(Node)) {
+	if pre != nil && !pre(node) {
+		return
+	}
+
+	for _, c := range Children(node) {
+		WalkFunc(c.Node, pre, post)
+	}
+
+	if post != nil {
+		post(node)
+	}
+}
+
+// SetParents links every node under root to its parent, and records where
+// the parent holds it in its path attribute and index
+func SetParents(root Node) {
+	for _, c := range Children(root) {
+		c.Node.SetParent(root)
+		c.Node.SetPathAttribute(c.Attribute)
+		c.Node.SetPathIndex(c.Index)
+		SetParents(c.Node)
+	}
+}
@@ -0,0 +1,136 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// walkTree is 'if c\n    f(a, 1)' without an else branch
+func walkTree() (*Suite, *IfStmt, *CallExpr) {
+	call := &CallExpr{
+		Func: &Identifier{Name: "f"},
+		Args: []Node{&Identifier{Name: "a"}, &IntLiteral{Value: 1}},
+	}
+	stmt := &IfStmt{
+		Test: &Identifier{Name: "c"},
+		True: &ExprStmt{Expr: call},
+	}
+	return &Suite{Body: []Node{stmt}}, stmt, call
+}
+
+// label names a node in the traces of the tests
+func label(n Node) string {
+	switch n := n.(type) {
+	case nil:
+		return "end"
+	case *Identifier:
+		return n.Name
+	case *IntLiteral:
+		return fmt.Sprint(n.Value)
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", n), "*ast.")
+}
+
+func TestChildren(t *testing.T) {
+	_, stmt, call := walkTree()
+
+	got := []string{}
+	for _, c := range Children(call) {
+		got = append(got, fmt.Sprintf("%s:%s[%d]", label(c.Node), c.Attribute, c.Index))
+	}
+	if want := "f:Func[-1] a:Args[0] 1:Args[1]"; strings.Join(got, " ") != want {
+		t.Errorf("children of the call: got %v, want %s", got, want)
+	}
+
+	// the missing else branch is not a child
+	if cs := Children(stmt); len(cs) != 2 || cs[0].Attribute != "Test" || cs[1].Attribute != "True" {
+		t.Errorf("children of the if statement: got %+v", cs)
+	}
+
+	if cs := Children(&Identifier{Name: "x"}); len(cs) != 0 {
+		t.Errorf("children of a leaf: got %+v", cs)
+	}
+}
+
+// tracer records the nodes a walk visits
+type tracer struct {
+	trace *[]string
+	// the label of the node whose children are skipped
+	skip string
+}
+
+func (tr tracer) Visit(node Node) Visitor {
+	*tr.trace = append(*tr.trace, label(node))
+	if node != nil && label(node) == tr.skip {
+		return nil
+	}
+	return tr
+}
+
+func TestWalk(t *testing.T) {
+	root, _, _ := walkTree()
+
+	trace := []string{}
+	Walk(tracer{trace: &trace}, root)
+	want := "Suite IfStmt c end ExprStmt CallExpr f end a end 1 end end end end end"
+	if got := strings.Join(trace, " "); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	trace = []string{}
+	Walk(tracer{trace: &trace, skip: "ExprStmt"}, root)
+	want = "Suite IfStmt c end ExprStmt end end"
+	if got := strings.Join(trace, " "); got != want {
+		t.Errorf("skipping the statement:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	root, _, _ := walkTree()
+
+	trace := []string{}
+	Inspect(root, func(n Node) bool {
+		trace = append(trace, label(n))
+		return label(n) != "CallExpr"
+	})
+	want := "Suite IfStmt c end ExprStmt CallExpr end end end"
+	if got := strings.Join(trace, " "); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestWalkFunc(t *testing.T) {
+	root, _, _ := walkTree()
+
+	trace := []string{}
+	WalkFunc(root, func(n Node) bool {
+		trace = append(trace, "+"+label(n))
+		return label(n) != "c"
+	}, func(n Node) {
+		trace = append(trace, "-"+label(n))
+	})
+	want := "+Suite +IfStmt +c +ExprStmt +CallExpr +f -f +a -a +1 -1 -CallExpr -ExprStmt -IfStmt -Suite"
+	if got := strings.Join(trace, " "); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestSetParents(t *testing.T) {
+	root, stmt, call := walkTree()
+	SetParents(root)
+
+	if stmt.Parent() != root || stmt.PathAttribute() != "Body" || stmt.PathIndex() != 0 {
+		t.Errorf("the if statement is at %s[%d] of %v", stmt.PathAttribute(), stmt.PathIndex(), stmt.Parent())
+	}
+	if stmt.True.Parent() != stmt || stmt.True.PathAttribute() != "True" || stmt.True.PathIndex() != -1 {
+		t.Errorf("the branch is at %s[%d]", stmt.True.PathAttribute(), stmt.True.PathIndex())
+	}
+	arg := call.Args[1]
+	if arg.Parent() != call || arg.PathAttribute() != "Args" || arg.PathIndex() != 1 {
+		t.Errorf("the second argument is at %s[%d]", arg.PathAttribute(), arg.PathIndex())
+	}
+	if root.Parent() != nil {
+		t.Errorf("the root has the parent %v", root.Parent())
+	}
+}
@@ -3,6 +3,7 @@ package base
 import (
 	"fmt"
 
+	"github.com/kvarenzn/pinecone/metainfo"
 	"github.com/kvarenzn/pinecone/types"
 )
 
@@ -44,15 +45,17 @@ func (m Namespace) FindFunction(name string) (types.Callable, error) {
 	return result, nil
 }
 
-func (m Namespace) FindMethod(name string, selfType types.Type) (types.Callable, error) {
+// FindMethod finds a method which can be called on a value of selfType with
+// the conversion rules of a language version
+func (m Namespace) FindMethod(version metainfo.LanguageVersion, name string, selfType types.Type) (types.Callable, error) {
 	result, ok := m.Callables[name]
-	if ok && result.IsMethod() && types.Equal(selfType, result.FirstArgType()) {
+	if ok && types.MethodAccepts(version, result, selfType) {
 		return result, nil
 	}
 
 	// find method in sub namespaces
 	for _, sm := range m.SubNamespace {
-		result, err := sm.FindMethod(name, selfType)
+		result, err := sm.FindMethod(version, name, selfType)
 		if err == nil {
 			return result, nil
 		}
@@ -0,0 +1,290 @@
+package builtins
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/kvarenzn/pinecone/base"
+	"github.com/kvarenzn/pinecone/types"
+)
+
+// catalog.json lists the builtins which can be described by their
+// signatures (see types.GenericSignature) and types alone
+//
+//go:embed catalog.json
+var catalogData []byte
+
+var catalogNamespace = mustLoadCatalog(catalogData)
+
+// CatalogNamespace is a namespace of the catalog, the global namespace is
+// the root
+type CatalogNamespace struct {
+	Functions  []CatalogFunction           `json:"functions,omitempty"`
+	Variables  []CatalogVariable           `json:"variables,omitempty"`
+	Constants  []CatalogVariable           `json:"constants,omitempty"`
+	Namespaces map[string]CatalogNamespace `json:"namespaces,omitempty"`
+}
+
+type CatalogFunction struct {
+	Name       string   `json:"name"`
+	Method     bool     `json:"method,omitempty"`
+	SideEffect bool     `json:"side_effect,omitempty"`
+	Overloads  []string `json:"overloads"`
+}
+
+// CatalogVariable is a builtin variable, like 'close', or a constant with
+// its value, like 'math.pi'
+type CatalogVariable struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value any    `json:"value,omitempty"`
+}
+
+func ParseCatalog(data []byte) (CatalogNamespace, error) {
+	var root CatalogNamespace
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&root); err != nil {
+		return root, fmt.Errorf("malformed catalog: %w", err)
+	}
+	return root, nil
+}
+
+// LoadCatalog parses and validates a catalog, and builds the namespace it
+// describes
+func LoadCatalog(data []byte) (base.Namespace, error) {
+	root, err := ParseCatalog(data)
+	if err != nil {
+		return base.Namespace{}, err
+	}
+	if err := root.Validate(); err != nil {
+		return base.Namespace{}, err
+	}
+	return root.build(), nil
+}
+
+func mustLoadCatalog(data []byte) base.Namespace {
+	ns, err := LoadCatalog(data)
+	if err != nil {
+		panic(err)
+	}
+	return ns
+}
+
+// Validate checks that the signatures and types of the catalog can be
+// parsed, that the functions are named after their namespaces, that no name
+// is declared twice in a namespace, and that the constants have values of
+// their types. It reports all the problems found.
+func (cn CatalogNamespace) Validate() error {
+	return errors.Join(cn.validate("")...)
+}
+
+func qualify(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func (cn CatalogNamespace) validate(prefix string) []error {
+	errs := []error{}
+	declared := map[string]bool{}
+	declare := func(name string) {
+		if name == "" {
+			errs = append(errs, fmt.Errorf("a builtin in '%s' has no name", prefix))
+		} else if declared[name] {
+			errs = append(errs, fmt.Errorf("'%s' is declared more than once", qualify(prefix, name)))
+		}
+		declared[name] = true
+	}
+
+	for _, f := range cn.Functions {
+		declare(f.Name)
+		name := qualify(prefix, f.Name)
+		if len(f.Overloads) == 0 {
+			errs = append(errs, fmt.Errorf("function '%s' has no overloads", name))
+		}
+		for _, o := range f.Overloads {
+			gs, err := types.ParseSignature(o)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("function '%s': %w", name, err))
+				continue
+			}
+			if gs.Name != name {
+				errs = append(errs, fmt.Errorf("overload '%s' of function '%s' has a different name", o, name))
+			}
+			if f.Method && len(gs.Params) == 0 {
+				errs = append(errs, fmt.Errorf("overload '%s' of method '%s' has no parameters", o, name))
+			}
+		}
+	}
+
+	for _, v := range cn.Variables {
+		declare(v.Name)
+		name := qualify(prefix, v.Name)
+		if _, err := types.ParseType(v.Type); err != nil {
+			errs = append(errs, fmt.Errorf("variable '%s': %w", name, err))
+		}
+		if v.Value != nil {
+			errs = append(errs, fmt.Errorf("variable '%s' has a value, list it as a constant", name))
+		}
+	}
+
+	for _, c := range cn.Constants {
+		declare(c.Name)
+		name := qualify(prefix, c.Name)
+		gt, err := types.ParseType(c.Type)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("constant '%s': %w", name, err))
+			continue
+		}
+		if gt.Qualifier != types.Const {
+			errs = append(errs, fmt.Errorf("constant '%s' must be of a const type, not '%s'", name, c.Type))
+		}
+		if _, err := constantValue(gt.Type, c.Value); err != nil {
+			errs = append(errs, fmt.Errorf("constant '%s': %w", name, err))
+		}
+	}
+
+	for _, name := range sortedKeys(cn.Namespaces) {
+		declare(name)
+		errs = append(errs, cn.Namespaces[name].validate(qualify(prefix, name))...)
+	}
+	return errs
+}
+
+// constantValue converts the value of a constant decoded from json to the
+// go type of its pine type
+func constantValue(t types.Type, value any) (any, error) {
+	if value == nil {
+		return nil, fmt.Errorf("missing value")
+	}
+
+	switch t.Kind() {
+	case types.IntKind:
+		if v, ok := value.(float64); ok && v == math.Trunc(v) {
+			return int64(v), nil
+		}
+	case types.FloatKind:
+		if v, ok := value.(float64); ok {
+			return v, nil
+		}
+	case types.BoolKind:
+		if v, ok := value.(bool); ok {
+			return v, nil
+		}
+	case types.StringKind, types.ColorKind:
+		if v, ok := value.(string); ok {
+			return v, nil
+		}
+	default:
+		return nil, fmt.Errorf("constants of type '%s' are not supported", t)
+	}
+	return nil, fmt.Errorf("value %v is not of type %s", value, t)
+}
+
+func (cn CatalogNamespace) build() base.Namespace {
+	ns := base.Namespace{
+		Callables:    map[string]types.Callable{},
+		Variables:    map[string]base.ValueWithType{},
+		SubNamespace: map[string]base.Namespace{},
+	}
+
+	for _, f := range cn.Functions {
+		gf := types.NewGenericFunction(f.Overloads...)
+		gf.Method = f.Method
+		gf.SideEffect = f.SideEffect
+		ns.Callables[f.Name] = gf
+	}
+	for _, v := range cn.Variables {
+		gt, _ := types.ParseType(v.Type)
+		ns.Variables[v.Name] = base.ValueWithType{
+			Type: types.NewBindings().Resolve(gt),
+		}
+	}
+	for _, c := range cn.Constants {
+		gt, _ := types.ParseType(c.Type)
+		value, _ := constantValue(gt.Type, c.Value)
+		ns.Variables[c.Name] = base.ValueWithType{
+			Type:  types.NewBindings().Resolve(gt),
+			Value: value,
+		}
+	}
+	for name, sub := range cn.Namespaces {
+		ns.SubNamespace[name] = sub.build()
+	}
+	return ns
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// mustMerge merges the builtins defined in go into the ones from the
+// catalog, a builtin can not be defined in both
+func mustMerge(catalog, ns base.Namespace) base.Namespace {
+	ns, err := merge("", catalog, ns)
+	if err != nil {
+		panic(err)
+	}
+	return ns
+}
+
+func merge(prefix string, a, b base.Namespace) (base.Namespace, error) {
+	result := base.Namespace{
+		Callables:    map[string]types.Callable{},
+		Macros:       map[string]base.Macro{},
+		Variables:    map[string]base.ValueWithType{},
+		Types:        map[string]types.TypeOrCtor{},
+		SubNamespace: map[string]base.Namespace{},
+	}
+
+	errs := []error{}
+	for _, ns := range []base.Namespace{a, b} {
+		for name, c := range ns.Callables {
+			if _, ok := result.Callables[name]; ok {
+				errs = append(errs, fmt.Errorf("function '%s' is defined twice", qualify(prefix, name)))
+			}
+			result.Callables[name] = c
+		}
+		for name, m := range ns.Macros {
+			if _, ok := result.Macros[name]; ok {
+				errs = append(errs, fmt.Errorf("macro '%s' is defined twice", qualify(prefix, name)))
+			}
+			result.Macros[name] = m
+		}
+		for name, v := range ns.Variables {
+			if _, ok := result.Variables[name]; ok {
+				errs = append(errs, fmt.Errorf("variable '%s' is defined twice", qualify(prefix, name)))
+			}
+			result.Variables[name] = v
+		}
+		for name, t := range ns.Types {
+			if _, ok := result.Types[name]; ok {
+				errs = append(errs, fmt.Errorf("type '%s' is defined twice", qualify(prefix, name)))
+			}
+			result.Types[name] = t
+		}
+		for name, sub := range ns.SubNamespace {
+			if prev, ok := result.SubNamespace[name]; ok {
+				merged, err := merge(qualify(prefix, name), prev, sub)
+				if err != nil {
+					errs = append(errs, err)
+				}
+				sub = merged
+			}
+			result.SubNamespace[name] = sub
+		}
+	}
+	return result, errors.Join(errs...)
+}
@@ -0,0 +1,97 @@
+package builtins
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kvarenzn/pinecone/metainfo"
+	"github.com/kvarenzn/pinecone/types"
+)
+
+func TestCatalogIsValid(t *testing.T) {
+	root, err := ParseCatalog(catalogData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := root.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCatalog(catalogData); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCatalogEntries(t *testing.T) {
+	ns, err := LoadCatalog(catalogData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closeType, err := ns.FindVariableType("close")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closeType.String() != "series float" {
+		t.Errorf("close: got %s, want series float", closeType)
+	}
+
+	mathNS, err := ns.FindNamespace("math")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pi := mathNS.Variables["pi"]
+	if pi.Value != 3.141592653589793 || pi.Type.String() != "const float" {
+		t.Errorf("math.pi: got %v of type %s", pi.Value, pi.Type)
+	}
+
+	arrayNS, err := ns.FindNamespace("array")
+	if err != nil {
+		t.Fatal(err)
+	}
+	get, err := arrayNS.FindFunction("get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !get.IsMethod() {
+		t.Error("array.get: not a method")
+	}
+	out, err := get.Dispatch(metainfo.V5, []types.Type{types.ArrayOf(types.Int), types.Int}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "series int" {
+		t.Errorf("array.get(array<int>, int): got %s, want series int", out)
+	}
+}
+
+func TestValidateReportsProblems(t *testing.T) {
+	root, err := ParseCatalog([]byte(`{
+		"functions": [
+			{"name": "f", "overloads": ["g() -> int"]},
+			{"name": "f", "overloads": ["f("]}
+		],
+		"constants": [
+			{"name": "c", "type": "const int", "value": 1.5},
+			{"name": "d", "type": "series int", "value": 1}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = root.Validate()
+	if err == nil {
+		t.Fatal("no problem reported")
+	}
+	for _, want := range []string{
+		"overload 'g() -> int' of function 'f' has a different name",
+		"'f' is declared more than once",
+		"signature 'f('",
+		"constant 'c': value 1.5 is not of type int",
+		"constant 'd' must be of a const type",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%q is not reported in:\n%s", want, err)
+		}
+	}
+}
@@ -7,7 +7,14 @@ import (
 	"github.com/kvarenzn/pinecone/types"
 )
 
-var GlobalNamespace = base.Namespace{
+// GlobalNamespace is the builtins in the catalog together with the ones
+// which need go code to be checked
+var GlobalNamespace = mustMerge(catalogNamespace, base.Namespace{
+	Variables: map[string]base.ValueWithType{
+		"na": {
+			Type: types.Uncertain,
+		},
+	},
 	Types: map[string]types.TypeOrCtor{
 		"bool":     types.NewTocType(types.Bool),
 		"int":      types.NewTocType(types.Int),
@@ -20,21 +27,25 @@ var GlobalNamespace = base.Namespace{
 		"linefill": types.NewTocType(types.LineFill),
 		"polyline": types.NewTocType(types.PolyLine),
 		"table":    types.NewTocType(types.Table),
-		"array": types.NewTocCtor(func(args []types.Type) (types.Type, error) {
+		"array": types.NewTocCtor("array<T>", func(args []types.Type) (types.Type, error) {
 			if len(args) != 1 {
 				return nil, fmt.Errorf("'array' type need one type argument, for item type")
 			}
 
 			return types.ArrayOf(args[0]), nil
 		}),
-		"map": types.NewTocCtor(func(args []types.Type) (types.Type, error) {
+		"map": types.NewTocCtor("map<K, V>", func(args []types.Type) (types.Type, error) {
 			if len(args) != 2 {
 				return nil, fmt.Errorf("'array' type need two type argument, for key type and value type")
 			}
 
+			if !canBeMapKey(args[0]) {
+				return nil, fmt.Errorf("'%s' cannot be used as the key type of a map", args[0])
+			}
+
 			return types.MapOf(args[0], args[1]), nil
 		}),
-		"matrix": types.NewTocCtor(func(args []types.Type) (types.Type, error) {
+		"matrix": types.NewTocCtor("matrix<T>", func(args []types.Type) (types.Type, error) {
 			if len(args) != 1 {
 				return nil, fmt.Errorf("'matrix' type need one type argument, for item type")
 			}
@@ -44,5 +55,15 @@ var GlobalNamespace = base.Namespace{
 	},
 	SubNamespace: map[string]base.Namespace{
 		"chart": Chart,
+		"input": Input,
 	},
+})
+
+// only values of primitive types and enums can be used as keys of maps
+func canBeMapKey(t types.Type) bool {
+	switch t.Kind() {
+	case types.BoolKind, types.IntKind, types.FloatKind, types.StringKind, types.ColorKind, types.EnumKind:
+		return true
+	}
+	return false
 }
@@ -0,0 +1,74 @@
+package builtins
+
+import (
+	"fmt"
+
+	"github.com/kvarenzn/pinecone/base"
+	"github.com/kvarenzn/pinecone/metainfo"
+	"github.com/kvarenzn/pinecone/types"
+)
+
+var inputEnumParams = []string{"defval", "title", "options", "tooltip", "inline", "group", "confirm", "display"}
+
+// inputEnum checks the arguments of 'input.enum', the type of the input is
+// the enum of its default value
+func inputEnum(version metainfo.LanguageVersion, args []types.Type, kwargs map[string]types.Type) (types.Type, error) {
+	if len(args) > len(inputEnumParams) {
+		return nil, fmt.Errorf("'input.enum' takes at most %d arguments", len(inputEnumParams))
+	}
+
+	given := map[string]types.Type{}
+	for i, a := range args {
+		given[inputEnumParams[i]] = a
+	}
+	for k, v := range kwargs {
+		found := false
+		for _, name := range inputEnumParams {
+			if name == k {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("'input.enum' has no parameter named '%s'", k)
+		}
+		if _, ok := given[k]; ok {
+			return nil, fmt.Errorf("'input.enum' got multiple values for argument '%s'", k)
+		}
+		given[k] = v
+	}
+
+	defval, ok := given["defval"]
+	if !ok {
+		return nil, fmt.Errorf("'input.enum' requires the 'defval' argument")
+	}
+	if defval.Kind() != types.EnumKind {
+		return nil, fmt.Errorf("the default value of 'input.enum' must be an enum field, not '%s'", defval.String())
+	}
+	enum := types.Peel(defval)
+
+	if options, ok := given["options"]; ok {
+		if options.Kind() != types.TupleKind {
+			return nil, fmt.Errorf("the options of 'input.enum' must be a tuple of '%s' fields", enum.String())
+		}
+		for _, item := range options.Items() {
+			if !types.Equal(item, enum) {
+				return nil, fmt.Errorf("the options of 'input.enum' must be fields of '%s', not '%s'", enum.String(), item.String())
+			}
+		}
+	}
+
+	return types.TypeWithQualifier{
+		Qualifier: types.Input,
+		Type:      enum,
+	}, nil
+}
+
+var Input = base.Namespace{
+	Callables: map[string]types.Callable{
+		"enum": types.BuiltinFunction{
+			Name:    "input.enum",
+			OutType: inputEnum,
+		},
+	},
+}
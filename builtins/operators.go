@@ -3,20 +3,48 @@ package builtins
 import (
 	"fmt"
 
+	"github.com/kvarenzn/pinecone/metainfo"
 	"github.com/kvarenzn/pinecone/types"
 )
 
 type BinaryOperator struct {
-	Validate func(types.Type, types.Type) (types.Type, error)
+	Validate func(metainfo.LanguageVersion, types.Type, types.Type) (types.Type, error)
 }
 
 type UnaryOperator struct {
-	Validate func(types.Type) (types.Type, error)
+	Validate func(metainfo.LanguageVersion, types.Type) (types.Type, error)
+}
+
+// toBool checks an operand of 'and', 'or' and 'not'
+func toBool(version metainfo.LanguageVersion, op string, t types.Type) error {
+	if t.Kind() == types.BoolKind || types.CanDoImplicitConversionIn(version, t, types.Bool) {
+		return nil
+	}
+	if version >= metainfo.V6 && (t.Kind() == types.IntKind || t.Kind() == types.FloatKind) {
+		return fmt.Errorf("unsupported '%s' operation on '%s', numbers are no longer casted to bool since v6, compare it explicitly", op, t.String())
+	}
+	return fmt.Errorf("unsupported '%s' operation on '%s'", op, t.String())
+}
+
+// equatable checks the operands of '==' and '!=', numbers compare with each
+// other and any other value only with values of its own type, so fields of
+// two enums never equal
+func equatable(version metainfo.LanguageVersion, op string, left, right types.Type) (types.Type, error) {
+	isNumber := func(t types.Type) bool {
+		return t.Kind() == types.IntKind || t.Kind() == types.FloatKind
+	}
+	if isNumber(left) && isNumber(right) ||
+		types.Equal(types.Peel(left), types.Peel(right)) ||
+		types.CanDoImplicitConversionIn(version, left, right) ||
+		types.CanDoImplicitConversionIn(version, right, left) {
+		return types.Bool, nil
+	}
+	return nil, fmt.Errorf("unsupported '%s' operation between '%s' and '%s'", op, left.String(), right.String())
 }
 
 var BinaryOperators = map[string]BinaryOperator{
 	"+": {
-		Validate: func(left, right types.Type) (types.Type, error) {
+		Validate: func(version metainfo.LanguageVersion, left, right types.Type) (types.Type, error) {
 			switch left.Kind() {
 			case types.IntKind:
 				switch right.Kind() {
@@ -39,7 +67,7 @@ var BinaryOperators = map[string]BinaryOperator{
 		},
 	},
 	"-": {
-		Validate: func(left, right types.Type) (types.Type, error) {
+		Validate: func(version metainfo.LanguageVersion, left, right types.Type) (types.Type, error) {
 			switch left.Kind() {
 			case types.IntKind:
 				switch right.Kind() {
@@ -58,7 +86,7 @@ var BinaryOperators = map[string]BinaryOperator{
 		},
 	},
 	"*": {
-		Validate: func(left, right types.Type) (types.Type, error) {
+		Validate: func(version metainfo.LanguageVersion, left, right types.Type) (types.Type, error) {
 			switch left.Kind() {
 			case types.IntKind:
 				switch right.Kind() {
@@ -77,7 +105,7 @@ var BinaryOperators = map[string]BinaryOperator{
 		},
 	},
 	"/": {
-		Validate: func(left, right types.Type) (types.Type, error) {
+		Validate: func(version metainfo.LanguageVersion, left, right types.Type) (types.Type, error) {
 			switch left.Kind() {
 			case types.IntKind:
 				switch right.Kind() {
@@ -96,7 +124,7 @@ var BinaryOperators = map[string]BinaryOperator{
 		},
 	},
 	"%": {
-		Validate: func(left, right types.Type) (types.Type, error) {
+		Validate: func(version metainfo.LanguageVersion, left, right types.Type) (types.Type, error) {
 			switch left.Kind() {
 			case types.IntKind:
 				switch right.Kind() {
@@ -114,11 +142,46 @@ var BinaryOperators = map[string]BinaryOperator{
 			return nil, fmt.Errorf("unsupported '%%' operation between '%s' and '%s'", left.String(), right.String())
 		},
 	},
+	"==": {
+		Validate: func(version metainfo.LanguageVersion, left, right types.Type) (types.Type, error) {
+			return equatable(version, "==", left, right)
+		},
+	},
+	"!=": {
+		Validate: func(version metainfo.LanguageVersion, left, right types.Type) (types.Type, error) {
+			return equatable(version, "!=", left, right)
+		},
+	},
+	// both operands are always evaluated before v6, and 'and'/'or' short
+	// circuit since then, which does not change their types, the analyzer
+	// warns about side effects which may be skipped
+	"and": {
+		Validate: func(version metainfo.LanguageVersion, left, right types.Type) (types.Type, error) {
+			if err := toBool(version, "and", left); err != nil {
+				return nil, err
+			}
+			if err := toBool(version, "and", right); err != nil {
+				return nil, err
+			}
+			return types.Bool, nil
+		},
+	},
+	"or": {
+		Validate: func(version metainfo.LanguageVersion, left, right types.Type) (types.Type, error) {
+			if err := toBool(version, "or", left); err != nil {
+				return nil, err
+			}
+			if err := toBool(version, "or", right); err != nil {
+				return nil, err
+			}
+			return types.Bool, nil
+		},
+	},
 }
 
 var UnaryOperators = map[string]UnaryOperator{
 	"+": {
-		Validate: func(t types.Type) (types.Type, error) {
+		Validate: func(version metainfo.LanguageVersion, t types.Type) (types.Type, error) {
 			switch t.Kind() {
 			case types.IntKind:
 				return types.Int, nil
@@ -129,7 +192,7 @@ var UnaryOperators = map[string]UnaryOperator{
 		},
 	},
 	"-": {
-		Validate: func(t types.Type) (types.Type, error) {
+		Validate: func(version metainfo.LanguageVersion, t types.Type) (types.Type, error) {
 			switch t.Kind() {
 			case types.IntKind:
 				return types.Int, nil
@@ -140,12 +203,11 @@ var UnaryOperators = map[string]UnaryOperator{
 		},
 	},
 	"not": {
-		Validate: func(t types.Type) (types.Type, error) {
-			switch t.Kind() {
-			case types.BoolKind:
-				return types.Bool, nil
+		Validate: func(version metainfo.LanguageVersion, t types.Type) (types.Type, error) {
+			if err := toBool(version, "not", t); err != nil {
+				return nil, err
 			}
-			return nil, fmt.Errorf("unsupported 'not' operation on '%s'", t.String())
+			return types.Bool, nil
 		},
 	},
 }
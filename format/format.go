@@ -0,0 +1,723 @@
+package format
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kvarenzn/pinecone/ast"
+	"github.com/kvarenzn/pinecone/metainfo"
+	"github.com/kvarenzn/pinecone/parser"
+	"github.com/kvarenzn/pinecone/tokenizer"
+)
+
+const (
+	indentWidth = 4
+	maxWidth    = 100
+	// wrapped lines must not be indented by a multiple of 4, or they would
+	// start a new statement
+	wrapIndent = 2
+)
+
+// operator precedences, from the loosest to the tightest
+const (
+	precTernary = iota + 1
+	precOr
+	precAnd
+	precNot
+	precComparison
+	precAdditive
+	precMultiplicative
+	precUnary
+	precPostfix
+)
+
+func binaryPrec(op string) int {
+	switch op {
+	case "or":
+		return precOr
+	case "and":
+		return precAnd
+	case "<", ">", "<=", ">=", "==", "!=":
+		return precComparison
+	case "+", "-":
+		return precAdditive
+	case "*", "/", "%":
+		return precMultiplicative
+	}
+	return precPostfix
+}
+
+func exprPrec(n ast.Node) int {
+	switch e := n.(type) {
+	case *ast.TernaryExpr:
+		return precTernary
+	case *ast.BinaryExpr:
+		return binaryPrec(e.Op)
+	case *ast.UnaryExpr:
+		if e.Op == "not" {
+			return precNot
+		}
+		return precUnary
+	}
+	return precPostfix
+}
+
+type comment struct {
+	row  int
+	text string
+}
+
+type printer struct {
+	out    strings.Builder
+	indent int
+	col    int
+
+	// source text of literals, keyed by their location
+	lexemes map[metainfo.Location]string
+	// comments on their own lines, sorted by row
+	comments []comment
+	// comments at the end of a line, keyed by row
+	trailing map[int][]string
+
+	// the last source row printed on the current output line
+	lineRow int
+	// the last source row printed
+	lastRow int
+	// no statement has been printed in the current block yet
+	blockStart bool
+	// the first node which could not be printed
+	err error
+
+	// the nodes printed as they are written in the source, but for self,
+	// the node being printed (see Tree)
+	verbatim verbatimSource
+	self     ast.Node
+}
+
+func newPrinter(tokens []tokenizer.Token) *printer {
+	p := &printer{
+		lexemes:    map[metainfo.Location]string{},
+		comments:   []comment{},
+		trailing:   map[int][]string{},
+		blockStart: true,
+	}
+
+	for _, token := range tokens {
+		if token.Type.In(tokenizer.NUMBER, tokenizer.COLOR) {
+			p.lexemes[token.Begin] = token.Lexeme
+		}
+
+		row := token.Begin.Row
+		for i := len(token.Leading) - 1; i >= 0; i-- {
+			switch tr := token.Leading[i]; tr.Kind {
+			case tokenizer.NewlineTrivia:
+				row--
+			case tokenizer.CommentTrivia:
+				p.comments = append(p.comments, comment{
+					row:  row,
+					text: tr.Text,
+				})
+			}
+		}
+
+		for _, tr := range token.Trailing {
+			if tr.Kind == tokenizer.CommentTrivia {
+				p.trailing[token.End.Row] = append(p.trailing[token.End.Row], tr.Text)
+			}
+		}
+	}
+
+	sort.SliceStable(p.comments, func(i, j int) bool {
+		return p.comments[i].row < p.comments[j].row
+	})
+
+	return p
+}
+
+// unsupported records a node the printer does not know, the output is
+// incomplete and must not replace the source
+func (p *printer) unsupported(n ast.Node) string {
+	if p.err == nil {
+		p.err = fmt.Errorf("%d:%d: cannot format %T", n.Begin().Row, n.Begin().Column, n)
+	}
+	return ""
+}
+
+// verbatimOf gives the source text of a node, which is put at the current
+// column, if the node is printed as it is written
+func (p *printer) verbatimOf(n ast.Node) (string, bool) {
+	if !p.isVerbatim(n) {
+		return "", false
+	}
+	return p.verbatim.text(n, p.col), true
+}
+
+func (p *printer) isVerbatim(n ast.Node) bool {
+	return p.verbatim != nil && n != p.self && p.verbatim.known(n)
+}
+
+func (p *printer) write(s string) {
+	p.out.WriteString(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		p.col = utf8.RuneCountInString(s[i+1:])
+	} else {
+		p.col += utf8.RuneCountInString(s)
+	}
+}
+
+func (p *printer) mark(n ast.Node) {
+	for _, row := range []int{n.Begin().Row, n.End().Row} {
+		if row > p.lineRow {
+			p.lineRow = row
+		}
+		if row > p.lastRow {
+			p.lastRow = row
+		}
+	}
+}
+
+// newline ends the current line, together with the comments found at the
+// end of the source lines printed on it
+func (p *printer) newline() {
+	rows := []int{}
+	for row := range p.trailing {
+		if row <= p.lineRow {
+			rows = append(rows, row)
+		}
+	}
+	sort.Ints(rows)
+	for _, row := range rows {
+		for _, text := range p.trailing[row] {
+			p.write(" " + text)
+		}
+		delete(p.trailing, row)
+	}
+
+	p.write("\n")
+	p.lineRow = 0
+}
+
+func (p *printer) writeIndent() {
+	p.write(strings.Repeat(" ", p.indent*indentWidth))
+}
+
+func (p *printer) separate(row int) {
+	if row > 0 && !p.blockStart && p.lastRow > 0 && row > p.lastRow+1 {
+		p.write("\n")
+	}
+	p.blockStart = false
+	if row > p.lastRow {
+		p.lastRow = row
+	}
+}
+
+func (p *printer) flushComments(row int) {
+	for len(p.comments) > 0 && p.comments[0].row < row {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		p.separate(c.row)
+		p.writeIndent()
+		p.write(c.text)
+		p.newline()
+	}
+}
+
+// beginLine starts the line of a statement which begins at the given row of
+// the source, or at an unknown row if it is 0
+func (p *printer) beginLine(row int) {
+	if row > 0 {
+		p.flushComments(row)
+	}
+	p.separate(row)
+	p.writeIndent()
+}
+
+func (p *printer) stmts(stmts []ast.Node) {
+	for _, stmt := range stmts {
+		p.stmt(stmt)
+	}
+}
+
+func (p *printer) stmt(n ast.Node) {
+	if s, ok := n.(*ast.Suite); ok && !p.isVerbatim(s) {
+		p.stmts(s.Body)
+		return
+	}
+
+	p.beginLine(n.Begin().Row)
+	p.stmtBody(n)
+}
+
+func (p *printer) block(n ast.Node) {
+	p.indent++
+	p.blockStart = true
+	p.stmt(n)
+	p.indent--
+}
+
+func inlineBody(n ast.Node) bool {
+	switch n.(type) {
+	case *ast.ExprStmt, *ast.ReassignStmt, *ast.BreakStmt, *ast.ContinueStmt:
+		return true
+	}
+	return false
+}
+
+// body prints the body of a function or of a switch case, after its "=>"
+func (p *printer) body(n ast.Node) {
+	if inlineBody(n) {
+		p.write(" ")
+		p.stmtBody(n)
+		return
+	}
+	p.newline()
+	p.block(n)
+}
+
+func (p *printer) cont() int {
+	return p.indent*indentWidth + wrapIndent
+}
+
+func (p *printer) stmtBody(n ast.Node) {
+	if text, ok := p.verbatimOf(n); ok {
+		p.write(text)
+		p.mark(n)
+		p.newline()
+		return
+	}
+
+	switch s := n.(type) {
+	case *ast.ExprStmt:
+		p.write(p.expr(s.Expr, p.cont()))
+		p.newline()
+	case *ast.VarDeclStmt:
+		if s.DeclMode != nil {
+			p.write(*s.DeclMode + " ")
+		}
+		if s.Qualifier != nil {
+			p.write(*s.Qualifier + " ")
+		}
+		if s.Type != nil {
+			p.write(p.typeExpr(s.Type) + " ")
+		}
+		p.write(s.Name + " = ")
+		p.stmtBody(s.Initial)
+	case *ast.TupleDeclStmt:
+		p.write("[" + strings.Join(s.Variables, ", ") + "] = ")
+		p.stmtBody(s.Initial)
+	case *ast.ReassignStmt:
+		p.write(p.expr(s.Target, p.cont()) + " " + s.Op + " ")
+		p.stmtBody(s.Value)
+	case *ast.IfStmt:
+		p.write("if " + p.expr(s.Test, p.cont()))
+		p.newline()
+		p.block(s.True)
+		if s.False != nil {
+			p.beginLine(0)
+			p.write("else")
+			if elseIf, ok := s.False.(*ast.IfStmt); ok {
+				p.write(" ")
+				p.stmtBody(elseIf)
+			} else {
+				p.newline()
+				p.block(s.False)
+			}
+		}
+	case *ast.SwitchStmt:
+		p.write("switch")
+		if s.Target != nil {
+			p.write(" " + p.expr(s.Target, p.cont()))
+		}
+		p.newline()
+		p.indent++
+		p.blockStart = true
+		for _, c := range s.Cases {
+			p.beginLine(c.Begin().Row)
+			p.caseClause(c)
+		}
+		if s.Default != nil {
+			p.beginLine(s.Default.Begin().Row)
+			p.write("=>")
+			p.body(s.Default)
+		}
+		p.indent--
+	case *ast.WhileStmt:
+		p.write("while " + p.expr(s.Test, p.cont()))
+		p.newline()
+		p.block(s.Body)
+	case *ast.ForStmt:
+		p.write(fmt.Sprintf("for %s = %s to %s", s.Counter, p.expr(s.Init, p.cont()), p.expr(s.Final, p.cont())))
+		if s.Step != nil {
+			p.write(" by " + p.expr(s.Step, p.cont()))
+		}
+		p.newline()
+		p.block(s.Body)
+	case *ast.ForInStmt:
+		if s.Index != nil {
+			p.write(fmt.Sprintf("for [%s, %s] in ", *s.Index, s.Iterator))
+		} else {
+			p.write(fmt.Sprintf("for %s in ", s.Iterator))
+		}
+		p.write(p.expr(s.Container, p.cont()))
+		p.newline()
+		p.block(s.Body)
+	case *ast.BreakStmt:
+		p.write("break")
+		p.mark(s)
+		p.newline()
+	case *ast.ContinueStmt:
+		p.write("continue")
+		p.mark(s)
+		p.newline()
+	case *ast.FuncDeclStmt:
+		if s.Export {
+			p.write("export ")
+		}
+		if s.Method {
+			p.write("method ")
+		}
+		params := []string{}
+		for _, param := range s.Params {
+			params = append(params, p.paramDecl(param))
+		}
+		p.write(s.Name + "(" + strings.Join(params, ", ") + ") =>")
+		p.body(s.Body)
+	case *ast.TypeDeclStmt:
+		p.write("type " + s.Name)
+		p.newline()
+		p.indent++
+		p.blockStart = true
+		for _, m := range s.Members {
+			p.beginLine(m.Begin().Row)
+			p.member(m)
+		}
+		p.indent--
+	case *ast.EnumDeclStmt:
+		if s.Export {
+			p.write("export ")
+		}
+		p.write("enum " + s.Name)
+		p.newline()
+		p.indent++
+		p.blockStart = true
+		for _, f := range s.Fields {
+			p.beginLine(f.Begin().Row)
+			p.enumField(f)
+		}
+		p.indent--
+	case *ast.ImportStmt:
+		p.write(fmt.Sprintf("import %s/%s/%s", s.User, s.Name, s.Version))
+		if s.Alias != nil {
+			p.write(" as " + *s.Alias)
+		}
+		p.mark(s)
+		p.newline()
+	case *ast.Suite:
+		// a group of statements separated by commas
+		p.newline()
+		p.block(s)
+	case *ast.Quote:
+		p.stmtBody(s.Content)
+	default:
+		p.write(p.expr(n, p.cont()))
+		p.newline()
+	}
+}
+
+func (p *printer) caseClause(c *ast.CaseClause) {
+	if text, ok := p.verbatimOf(c); ok {
+		p.write(text)
+		p.mark(c)
+		p.newline()
+		return
+	}
+	p.write(p.expr(c.Cond, p.cont()) + " =>")
+	p.body(c.Body)
+}
+
+func (p *printer) member(m *ast.MemberDecl) {
+	if text, ok := p.verbatimOf(m); ok {
+		p.write(text)
+	} else {
+		if m.DeclMode != nil {
+			p.write(*m.DeclMode + " ")
+		}
+		if m.Type != nil {
+			p.write(p.typeExpr(m.Type) + " ")
+		}
+		p.write(m.Name)
+		if m.Default != nil {
+			p.write(" = " + p.expr(m.Default, p.cont()))
+		}
+	}
+	p.mark(m)
+	p.newline()
+}
+
+func (p *printer) enumField(f *ast.EnumField) {
+	if text, ok := p.verbatimOf(f); ok {
+		p.write(text)
+	} else {
+		p.write(f.Name)
+		if f.Title != nil {
+			p.write(" = " + p.expr(f.Title, p.cont()))
+		}
+	}
+	p.mark(f)
+	p.newline()
+}
+
+func (p *printer) paramDecl(param *ast.ParamDecl) string {
+	if text, ok := p.verbatimOf(param); ok {
+		return text
+	}
+	text := ""
+	if param.Qualifier != nil {
+		text += *param.Qualifier + " "
+	}
+	if param.Type != nil {
+		text += p.typeExpr(param.Type) + " "
+	}
+	text += param.Name
+	if param.Default != nil {
+		text += " = " + p.expr(param.Default, p.cont())
+	}
+	p.mark(param)
+	return text
+}
+
+func (p *printer) typeExpr(n ast.Node) string {
+	p.mark(n)
+	if text, ok := p.verbatimOf(n); ok {
+		return text
+	}
+	switch t := n.(type) {
+	case *ast.SimpleType:
+		return t.Name
+	case *ast.SubType:
+		return p.typeExpr(t.Name) + "." + t.Member
+	case *ast.GenericType:
+		args := []string{}
+		for _, arg := range t.Args {
+			args = append(args, p.typeExpr(arg))
+		}
+		return p.typeExpr(t.Name) + "<" + strings.Join(args, ", ") + ">"
+	}
+	return p.unsupported(n)
+}
+
+func (p *printer) operand(n ast.Node, minPrec int, cont int) string {
+	text := p.expr(n, cont)
+	if exprPrec(n) < minPrec {
+		return "(" + text + ")"
+	}
+	return text
+}
+
+// expr renders an expression, argument lists that do not fit in the line
+// are wrapped and indented by cont
+func (p *printer) expr(n ast.Node, cont int) string {
+	if text, ok := p.verbatimOf(n); ok {
+		p.mark(n)
+		return text
+	}
+
+	switch e := n.(type) {
+	case *ast.Identifier:
+		p.mark(e)
+		return e.Name
+	case *ast.BoolLiteral:
+		p.mark(e)
+		return strconv.FormatBool(e.Value)
+	case *ast.IntLiteral:
+		p.mark(e)
+		if lexeme, ok := p.lexemes[e.Begin()]; ok {
+			return lexeme
+		}
+		return strconv.FormatInt(e.Value, 10)
+	case *ast.FloatLiteral:
+		p.mark(e)
+		if lexeme, ok := p.lexemes[e.Begin()]; ok {
+			return lexeme
+		}
+		return strconv.FormatFloat(e.Value, 'f', -1, 64)
+	case *ast.StringLiteral:
+		p.mark(e)
+		if e.Raw != "" && !strings.ContainsAny(e.Raw, "\r\n") {
+			return normalizeQuotes(e.Raw)
+		}
+		// wrapped strings are joined, as their lines may be indented differently
+		return quote(e.Value)
+	case *ast.ColorLiteral:
+		p.mark(e)
+		if lexeme, ok := p.lexemes[e.Begin()]; ok {
+			return lexeme
+		}
+		alpha := int((100 - e.T) / 100 * 0xff)
+		return fmt.Sprintf("#%02x%02x%02x%02x", int(e.R), int(e.G), int(e.B), alpha)
+	case *ast.TupleExpr:
+		items := []string{}
+		for _, item := range e.Items {
+			items = append(items, p.expr(item, cont))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case *ast.BinaryExpr:
+		prec := binaryPrec(e.Op)
+		return p.operand(e.Left, prec, cont) + " " + e.Op + " " + p.operand(e.Right, prec+1, cont)
+	case *ast.UnaryExpr:
+		if e.Op == "not" {
+			return "not " + p.operand(e.Expr, precNot, cont)
+		}
+		operand := p.operand(e.Expr, precUnary, cont)
+		if strings.HasPrefix(operand, "+") || strings.HasPrefix(operand, "-") {
+			operand = "(" + operand + ")"
+		}
+		return e.Op + operand
+	case *ast.TernaryExpr:
+		return p.operand(e.Test, precOr, cont) + " ? " + p.expr(e.True, cont) + " : " + p.expr(e.False, cont)
+	case *ast.AttrExpr:
+		return p.operand(e.Target, precPostfix, cont) + "." + e.Name
+	case *ast.HRefExpr:
+		return p.operand(e.Series, precPostfix, cont) + "[" + p.expr(e.Offset, cont) + "]"
+	case *ast.InstantiationExpr:
+		args := []string{}
+		for _, arg := range e.TypeArgs {
+			args = append(args, p.typeExpr(arg))
+		}
+		return p.operand(e.Template, precPostfix, cont) + "<" + strings.Join(args, ", ") + ">"
+	case *ast.KwArg:
+		return e.Name + " = " + p.expr(e.Value, cont)
+	case *ast.CallExpr:
+		return p.call(e, cont)
+	case *ast.SimpleType, *ast.SubType, *ast.GenericType:
+		return p.typeExpr(e)
+	case *ast.ExprStmt:
+		return p.expr(e.Expr, cont)
+	}
+	return p.unsupported(n)
+}
+
+// call renders a call, wrapping its arguments one per line if they do not
+// fit in the line or if there are comments between them. The comments stay
+// after the argument they follow in the source.
+func (p *printer) call(e *ast.CallExpr, cont int) string {
+	fn := p.operand(e.Func, precPostfix, cont)
+	args := []string{}
+	for _, arg := range e.Args {
+		args = append(args, p.expr(arg, cont+indentWidth))
+	}
+
+	flat := fn + "(" + strings.Join(args, ", ") + ")"
+	if len(args) == 0 || p.col+utf8.RuneCountInString(flat) <= maxWidth && !strings.Contains(flat, "\n") && !p.hasTrailing(e.Begin().Row, e.End().Row) {
+		return flat
+	}
+
+	prefix := "\n" + strings.Repeat(" ", cont)
+	var sb strings.Builder
+	sb.WriteString(fn + "(")
+	sb.WriteString(p.takeTrailing(e.Begin().Row, e.Args[0].Begin().Row))
+	for i, arg := range args {
+		sb.WriteString(prefix + arg)
+		if i+1 < len(args) {
+			sb.WriteString(",")
+			sb.WriteString(p.takeTrailing(e.Args[i].Begin().Row, e.Args[i+1].Begin().Row))
+		} else if comments := p.takeTrailing(e.Args[i].Begin().Row, e.End().Row); comments != "" {
+			// the parenthesis can not follow a comment
+			sb.WriteString(comments + prefix)
+		}
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+// hasTrailing tells whether there are comments at the end of the source
+// rows from begin to before end
+func (p *printer) hasTrailing(begin, end int) bool {
+	for row := range p.trailing {
+		if row >= begin && row < end {
+			return true
+		}
+	}
+	return false
+}
+
+// takeTrailing removes the comments at the end of the source rows from
+// begin to before end, and renders them to be put at the end of a line
+func (p *printer) takeTrailing(begin, end int) string {
+	texts := []string{}
+	for row := begin; row < end; row++ {
+		texts = append(texts, p.trailing[row]...)
+		delete(p.trailing, row)
+	}
+	if len(texts) == 0 {
+		return ""
+	}
+	return " " + strings.Join(texts, " ")
+}
+
+var quoteReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", `\r`)
+
+func quote(s string) string {
+	return `"` + quoteReplacer.Replace(s) + `"`
+}
+
+// normalizeQuotes rewrites a single-quoted string literal with double quotes
+func normalizeQuotes(lexeme string) string {
+	if !strings.HasPrefix(lexeme, "'") {
+		return lexeme
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('"')
+	body := strings.TrimSuffix(lexeme[1:], "'")
+	for i := 0; i < len(body); i++ {
+		switch c := body[i]; c {
+		case '\\':
+			if i+1 < len(body) && body[i+1] == '\'' {
+				sb.WriteByte('\'')
+			} else {
+				sb.WriteByte(c)
+				if i+1 < len(body) {
+					sb.WriteByte(body[i+1])
+				}
+			}
+			i++
+		case '"':
+			sb.WriteString(`\"`)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// Source formats pine script source code in the canonical style
+func Source(src []byte) ([]byte, error) {
+	tokens, tokenErrs := tokenizer.TokenizeLossless(string(src))
+	if len(tokenErrs) > 0 {
+		return nil, tokenErrs[0]
+	}
+	stmts, errs := parser.Parse(tokens)
+	for _, e := range errs {
+		if !e.Warning {
+			return nil, fmt.Errorf("%d:%d: %s", e.Row, e.Col, e.Msg)
+		}
+	}
+
+	p := newPrinter(tokens)
+	p.stmts(stmts)
+	if p.err != nil {
+		return nil, p.err
+	}
+	p.flushComments(int(^uint(0) >> 1))
+	if p.lineRow > 0 || len(p.trailing) > 0 {
+		p.lineRow = int(^uint(0) >> 1)
+		p.newline()
+	}
+
+	return []byte(p.out.String()), nil
+}
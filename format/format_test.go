@@ -0,0 +1,163 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kvarenzn/pinecone/ast"
+)
+
+func TestCommentsInWrappedArguments(t *testing.T) {
+	src := `//@version=5
+indicator("x")
+plot(ta.sma(close, 10), // the average
+     color = color.red, // red
+     title = "SMA") // end
+`
+	want := `//@version=5
+indicator("x")
+plot(
+  ta.sma(close, 10), // the average
+  color = color.red, // red
+  title = "SMA") // end
+`
+	got, err := Source([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestUnsupportedNode(t *testing.T) {
+	p := newPrinter(nil)
+	p.stmts([]ast.Node{&ast.ExprStmt{Expr: &ast.Quote{Content: &ast.Identifier{Name: "x"}}}})
+	if p.err == nil {
+		t.Errorf("a node the printer does not know is printed as %q", p.out.String())
+	}
+}
+
+// format formats a script which is expected to have no errors
+func format(t *testing.T, src string) string {
+	t.Helper()
+	got, err := Source([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(got)
+}
+
+func TestOperators(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"x=1+2*3", "x = 1 + 2 * 3"},
+		{"x = (1 + 2) * 3", "x = (1 + 2) * 3"},
+		{"x = ((a))", "x = a"},
+		{"x = a - (b - c)", "x = a - (b - c)"},
+		{"x = (a - b) - c", "x = a - b - c"},
+		{"x = (a * b) + c", "x = a * b + c"},
+		{"x = a / (b * c)", "x = a / (b * c)"},
+		{"x = not (a and b)", "x = not (a and b)"},
+		{"x = -(-a)", "x = -(-a)"},
+		{"x = a or (b and c)", "x = a or b and c"},
+		{"x = (a or b) and c", "x = (a or b) and c"},
+		{"x = (a ? b : c) ? d : e", "x = (a ? b : c) ? d : e"},
+		{"x = close[1]+(high-low)/2", "x = close[1] + (high - low) / 2"},
+		{"x = (a + b)[1]", "x = (a + b)[1]"},
+	}
+
+	for _, test := range tests {
+		got := format(t, "//@version=5\n"+test.src+"\n")
+		if want := "//@version=5\n" + test.want + "\n"; got != want {
+			t.Errorf("%s: got %q, want %q", test.src, got, want)
+		}
+	}
+}
+
+func TestWrapLongCalls(t *testing.T) {
+	long := `"a very long title which makes the call too long"`
+	src := `//@version=5
+indicator("x")
+plot(ta.sma(close, 10), title = ` + long + `, color = color.new(color.red, 50), linewidth = 2)
+if true
+    plot(ta.sma(close, 10),
+     title = ` + long + `, color = color.red, linewidth = 2)
+plot(close, title = "short")
+`
+	want := `//@version=5
+indicator("x")
+plot(
+  ta.sma(close, 10),
+  title = ` + long + `,
+  color = color.new(color.red, 50),
+  linewidth = 2)
+if true
+    plot(
+      ta.sma(close, 10),
+      title = ` + long + `,
+      color = color.red,
+      linewidth = 2)
+plot(close, title = "short")
+`
+	if got := format(t, src); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	for _, line := range strings.Split(want, "\n") {
+		if indent := len(line) - len(strings.TrimLeft(line, " ")); strings.HasPrefix(strings.TrimSpace(line), "title") && indent%4 == 0 {
+			t.Errorf("the wrapped line %q would start a statement", line)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	src := `//@version=5
+// header
+
+
+
+// about x
+x = 1   // one
+
+// about y
+y = 2
+if x
+    // inside
+    y := 3
+// at the end
+`
+	want := `//@version=5
+// header
+
+// about x
+x = 1 // one
+
+// about y
+y = 2
+if x
+    // inside
+    y := 3
+// at the end
+`
+	if got := format(t, src); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	sources := []string{
+		"//@version=5\nx=1+2*3\ny = (a or b) and not c ? high[1] : low\n",
+		"//@version=5\nindicator(\"x\")\nplot(ta.sma(close, 10), title = \"a very long title which makes the call too long\", color = color.new(color.red, 50), linewidth = 2)\n",
+		"//@version=5\nf(x) =>\n    // body\n    y = x * 2  // double\n\n    y\nplot(f(1),  // wrapped\n     color = #ff0080)\n",
+		"//@version=5\ntype Point\n    float x = 0.0\n    float y\nswitch\n    x > 0 => 1\n    => 2\n",
+	}
+
+	for _, src := range sources {
+		once := format(t, src)
+		if twice := format(t, once); twice != once {
+			t.Errorf("%q: formatting again changes\n%s\ninto\n%s", src, once, twice)
+		}
+	}
+}
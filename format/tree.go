@@ -0,0 +1,535 @@
+package format
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kvarenzn/pinecone/ast"
+	"github.com/kvarenzn/pinecone/parser"
+	"github.com/kvarenzn/pinecone/tokenizer"
+)
+
+// verbatimSource gives the source text of the nodes which are printed as
+// they are written
+type verbatimSource interface {
+	known(n ast.Node) bool
+	// text renders a node to be put at a column
+	text(n ast.Node, col int) string
+}
+
+// Tree prints a syntax tree of parser.ParseLossless back to source code.
+// The nodes which were not changed are printed as they are written, with
+// the trivia between their tokens, so a tree which was not changed gives
+// back its source byte by byte.
+//
+// A changed node keeps its tokens and their trivia as long as it has the
+// same kinds of children: the children are printed in the places of the
+// children it was parsed with, and the tokens of the changed names and
+// operators are replaced. The statements of a changed block keep the
+// comments before them and at the end of their lines, new statements are
+// put on lines of their own, indented like the block. The other nodes are
+// printed in the canonical style of Source, with the nodes under them which
+// have tokens printed as they are written.
+//
+// Annotations are comments, they are printed as they are written whatever
+// happens to their nodes.
+func Tree(t *parser.SyntaxTree) ([]byte, error) {
+	tp := &treePrinter{
+		tree:         t,
+		skipLeading:  map[int]bool{},
+		skipTrailing: map[int]bool{},
+		emitted:      map[int]bool{},
+		unchanged:    map[ast.Node]bool{},
+	}
+
+	span, ok := t.Span(t.Root)
+	if !ok {
+		// a script without statements
+		text := tokenizer.Untokenize(t.Tokens)
+		if len(t.Root.Body) > 0 {
+			if text != "" && !strings.HasSuffix(text, "\n") {
+				text += "\n"
+			}
+			text += tp.canonical(t.Root, 0) + "\n"
+		}
+		return []byte(text), tp.err
+	}
+
+	ast.Inspect(t.Root, func(n ast.Node) bool {
+		if s, ok := n.(*ast.Suite); ok && tp.blockChanged(s) {
+			// printed with the first and the last statements, wherever
+			// they go
+			body := tp.parsedBody(s)
+			first, _ := t.Span(body[0])
+			last, _ := t.Span(body[len(body)-1])
+			if !tp.inline(body) {
+				tp.skipLeading[first.First] = true
+			}
+			tp.skipTrailing[last.Last] = true
+		}
+		return true
+	})
+
+	var sb strings.Builder
+	for _, token := range t.Tokens[:span.First] {
+		sb.WriteString(token.FullText())
+	}
+	sb.WriteString(tp.leading(span.First))
+	sb.WriteString(tp.render(t.Root, advance(0, sb.String())))
+	sb.WriteString(tp.trailing(span.Last))
+	for _, token := range t.Tokens[span.Last+1:] {
+		sb.WriteString(token.FullText())
+	}
+	if tp.err != nil {
+		return nil, tp.err
+	}
+	return []byte(sb.String()), nil
+}
+
+type treePrinter struct {
+	tree *parser.SyntaxTree
+	// the trivia printed by the changed blocks, by the index of their
+	// tokens. Only the line break before the first statement of a block is
+	// left to the nodes around it.
+	skipLeading  map[int]bool
+	skipTrailing map[int]bool
+	// the trailing trivia printed inside the nodes printed in the canonical
+	// style, before the nodes around them
+	emitted map[int]bool
+	// whether the nodes and the nodes under them are as they were parsed
+	unchanged map[ast.Node]bool
+	// the first node which could not be printed
+	err error
+}
+
+func (tp *treePrinter) known(n ast.Node) bool {
+	_, ok := tp.tree.Span(n)
+	return ok
+}
+
+// text renders a node inside a node printed in the canonical style, the
+// statements keep the comments before them and at the end of their line
+func (tp *treePrinter) text(n ast.Node, col int) string {
+	span, _ := tp.tree.Span(n)
+	first := tp.tree.Tokens[span.First]
+	if !isStmt(n) {
+		return tp.render(n, col)
+	}
+
+	from := first.Begin.Column - 1
+	text := ownComments(first.Leading) + tp.render(n, from) + tp.trailing(span.Last)
+	tp.emitted[span.Last] = true
+	return reindent(text, col-from)
+}
+
+func (tp *treePrinter) leading(i int) string {
+	leading := tp.tree.Tokens[i].Leading
+	if tp.skipLeading[i] {
+		leading = leading[:lineBreak(leading)]
+	}
+	return triviaText(leading)
+}
+
+func (tp *treePrinter) trailing(i int) string {
+	if tp.skipTrailing[i] || tp.emitted[i] {
+		return ""
+	}
+	return tp.tree.Tokens[i].TrailingText()
+}
+
+// unchangedTree tells whether a node and the nodes under it are as they
+// were parsed
+func (tp *treePrinter) unchangedTree(n ast.Node) bool {
+	if u, ok := tp.unchanged[n]; ok {
+		return u
+	}
+	u := !tp.tree.Changed(n)
+	for _, c := range syntaxChildren(ast.Children(n)) {
+		if !u {
+			break
+		}
+		u = tp.unchangedTree(c.Node)
+	}
+	tp.unchanged[n] = u
+	return u
+}
+
+// syntaxChildren leaves out the annotations, which are not printed from
+// their nodes
+func syntaxChildren(children []ast.Child) []ast.Child {
+	result := []ast.Child{}
+	for _, c := range children {
+		if _, ok := c.Node.(*ast.Annotation); !ok {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// render prints a node to be put at a column, without the trivia before
+// its first token and after its last one
+func (tp *treePrinter) render(n ast.Node, col int) string {
+	span, ok := tp.tree.Span(n)
+	if !ok {
+		return tp.canonical(n, col)
+	}
+	if tp.unchangedTree(n) {
+		return tp.tree.Text(span)
+	}
+	if s, ok := n.(*ast.Suite); ok && tp.blockChanged(s) {
+		return tp.block(s, col)
+	}
+	if text, ok := tp.splice(n, span, col); ok {
+		return text
+	}
+	return tp.canonical(n, col)
+}
+
+// splice prints a node with the tokens it was parsed from, its children are
+// put in the places of the children it was parsed with, and the tokens of
+// its changed values are replaced. It fails if the node has other kinds of
+// children now, or if a changed value is not written as a token.
+func (tp *treePrinter) splice(n ast.Node, span parser.Span, col int) (string, bool) {
+	tokens := tp.tree.Tokens
+	children := syntaxChildren(ast.Children(n))
+	parsed := syntaxChildren(tp.tree.ParsedChildren(n))
+	if len(children) != len(parsed) {
+		return "", false
+	}
+
+	spans := []parser.Span{}
+	own := []int{}
+	next := span.First
+	for i, c := range parsed {
+		s, ok := tp.tree.Span(c.Node)
+		if !ok || c.Attribute != children[i].Attribute || c.Index != children[i].Index || s.First < next || s.Last > span.Last {
+			return "", false
+		}
+		for ; next < s.First; next++ {
+			own = append(own, next)
+		}
+		spans = append(spans, s)
+		next = s.Last + 1
+	}
+	for ; next <= span.Last; next++ {
+		own = append(own, next)
+	}
+
+	changes, _ := tp.tree.Changes(n)
+	lexemes := map[int]string{}
+	for _, c := range changes {
+		old, ok := c.Old.(string)
+		new, ok2 := c.New.(string)
+		if !ok || !ok2 || new == "" {
+			return "", false
+		}
+		found := false
+		for _, i := range own {
+			if _, replaced := lexemes[i]; !replaced && tokens[i].Lexeme == old {
+				lexemes[i] = new
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", false
+		}
+	}
+
+	var sb strings.Builder
+	write := func(s string) {
+		sb.WriteString(s)
+		col = advance(col, s)
+	}
+	for i, k := span.First, 0; i <= span.Last; {
+		if k < len(spans) && i == spans[k].First {
+			s := spans[k]
+			if s.First > span.First {
+				write(tp.leading(s.First))
+			}
+			write(tp.render(children[k].Node, col))
+			if s.Last < span.Last {
+				write(tp.trailing(s.Last))
+			}
+			i = s.Last + 1
+			k++
+			continue
+		}
+
+		if i > span.First {
+			write(tp.leading(i))
+		}
+		if lexeme, ok := lexemes[i]; ok {
+			write(lexeme)
+		} else {
+			write(tokens[i].Lexeme)
+		}
+		if i < span.Last {
+			write(tp.trailing(i))
+		}
+		i++
+	}
+	return sb.String(), true
+}
+
+// parsedBody gives the statements a block was parsed with
+func (tp *treePrinter) parsedBody(s *ast.Suite) []ast.Node {
+	body := []ast.Node{}
+	for _, c := range tp.tree.ParsedChildren(s) {
+		if c.Attribute == "Body" {
+			body = append(body, c.Node)
+		}
+	}
+	return body
+}
+
+// blockChanged tells whether the statements of a parsed block were changed
+func (tp *treePrinter) blockChanged(s *ast.Suite) bool {
+	body := tp.parsedBody(s)
+	if len(body) == 0 || !tp.tree.Changed(s) {
+		return false
+	}
+	for _, stmt := range body {
+		if !tp.known(stmt) {
+			return false
+		}
+	}
+	return true
+}
+
+// block prints the statements of a changed block. The statements it was
+// parsed with keep the trivia before them and at the end of their lines,
+// but for the line break before the first one, which is printed before the
+// block.
+func (tp *treePrinter) block(s *ast.Suite, col int) string {
+	tokens := tp.tree.Tokens
+	parsed := tp.parsedBody(s)
+	index := map[ast.Node]int{}
+	for j, stmt := range parsed {
+		index[stmt] = j
+	}
+
+	first, _ := tp.tree.Span(parsed[0])
+	indent := strings.Repeat(" ", tokens[first.First].Begin.Column-1)
+	inline := tp.inline(parsed)
+
+	var sb strings.Builder
+	write := func(s string) {
+		sb.WriteString(s)
+		col = advance(col, s)
+	}
+	for i, stmt := range s.Body {
+		j, ok := index[stmt]
+		span, _ := tp.tree.Span(stmt)
+		switch {
+		case inline && i > 0 && ok && j > 0 && s.Body[i-1] == parsed[j-1]:
+			prev, _ := tp.tree.Span(parsed[j-1])
+			for k := prev.Last + 1; k < span.First; k++ {
+				write(tokens[k].FullText())
+			}
+			write(tokens[span.First].LeadingText())
+		case inline:
+			if i > 0 {
+				write(", ")
+			}
+		case ok:
+			// the line break before the statement, and its own lines
+			leading := tokens[span.First].Leading
+			if i > 0 {
+				write("\n")
+			}
+			write(triviaText(leading[lineBreak(leading):]))
+		default:
+			if i > 0 {
+				write("\n")
+			}
+			write(indent)
+		}
+
+		write(tp.render(stmt, col))
+		if ok && !tp.emitted[span.Last] {
+			write(tokens[span.Last].TrailingText())
+		}
+	}
+	return sb.String()
+}
+
+// inline tells whether the statements of a block are separated by commas
+func (tp *treePrinter) inline(body []ast.Node) bool {
+	if len(body) < 2 {
+		return false
+	}
+	first, _ := tp.tree.Span(body[0])
+	second, _ := tp.tree.Span(body[1])
+	for i := first.Last + 1; i < second.First; i++ {
+		if tp.tree.Tokens[i].Type == tokenizer.COMMA {
+			return true
+		}
+	}
+	return false
+}
+
+// canonical prints a node in the canonical style of Source, the nodes under
+// it which have tokens are printed as they are written
+func (tp *treePrinter) canonical(n ast.Node, col int) string {
+	if lit, ok := n.(*ast.StringLiteral); ok && tp.known(lit) {
+		changes, _ := tp.tree.Changes(lit)
+		raw := false
+		for _, c := range changes {
+			raw = raw || c.Field == "Raw"
+		}
+		if !raw {
+			// the raw text is of the old value
+			return quote(lit.Value)
+		}
+	}
+
+	p := newPrinter(tp.ownTokens(n))
+	p.verbatim = tp
+	p.self = n
+	p.indent = col / indentWidth
+
+	text := ""
+	switch n := n.(type) {
+	case *ast.ParamDecl:
+		p.col = col
+		text = p.paramDecl(n)
+	case *ast.CaseClause:
+		p.caseClause(n)
+	case *ast.MemberDecl:
+		p.member(n)
+	case *ast.EnumField:
+		p.enumField(n)
+	default:
+		if isStmt(n) {
+			p.stmt(n)
+			p.flushComments(int(^uint(0) >> 1))
+		} else {
+			p.col = col
+			text = p.expr(n, p.cont())
+		}
+	}
+	if p.err != nil && tp.err == nil {
+		tp.err = p.err
+	}
+	if p.out.Len() > 0 {
+		text = strings.TrimPrefix(p.out.String(), strings.Repeat(" ", p.indent*indentWidth))
+		text = strings.TrimSuffix(text, "\n")
+	}
+	return text
+}
+
+// ownTokens gives the tokens of a node which are not printed with its
+// children, for the comments between them, together with the comments at
+// the end of the children which are expressions. The trivia before the node
+// and after it are left to the nodes around it.
+func (tp *treePrinter) ownTokens(n ast.Node) []tokenizer.Token {
+	span, ok := tp.tree.Span(n)
+	if !ok {
+		return nil
+	}
+	children := map[int]int{}
+	stmts := map[int]bool{}
+	for _, c := range syntaxChildren(ast.Children(n)) {
+		if s, ok := tp.tree.Span(c.Node); ok {
+			children[s.First] = s.Last
+			stmts[s.Last] = isStmt(c.Node)
+		}
+	}
+
+	tokens := []tokenizer.Token{}
+	for i := span.First; i <= span.Last; i++ {
+		token := tp.tree.Tokens[i]
+		if last, ok := children[i]; ok {
+			// statements print their own comments
+			if !stmts[last] && last != span.Last && !tp.skipTrailing[last] && !tp.emitted[last] {
+				token = tp.tree.Tokens[last]
+				tokens = append(tokens, tokenizer.Token{End: token.End, Trailing: token.Trailing})
+			}
+			i = last
+			continue
+		}
+		if i == span.First {
+			token.Leading = nil
+		} else if tp.skipLeading[i] {
+			token.Leading = token.Leading[:lineBreak(token.Leading)]
+		}
+		if i == span.Last || tp.skipTrailing[i] || tp.emitted[i] {
+			token.Trailing = nil
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+func isStmt(n ast.Node) bool {
+	switch n.(type) {
+	case *ast.ExprStmt, *ast.VarDeclStmt, *ast.TupleDeclStmt, *ast.ReassignStmt, *ast.IfStmt, *ast.SwitchStmt,
+		*ast.WhileStmt, *ast.ForStmt, *ast.ForInStmt, *ast.BreakStmt, *ast.ContinueStmt, *ast.FuncDeclStmt,
+		*ast.TypeDeclStmt, *ast.EnumDeclStmt, *ast.ImportStmt, *ast.Suite, *ast.Quote:
+		return true
+	}
+	return false
+}
+
+// lineBreak gives the length of the leading trivia of a token up to the end
+// of its first line break, or 0 if there is none
+func lineBreak(leading []tokenizer.Trivia) int {
+	for i, tr := range leading {
+		if tr.Kind == tokenizer.NewlineTrivia {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func triviaText(trivia []tokenizer.Trivia) string {
+	var sb strings.Builder
+	for _, tr := range trivia {
+		sb.WriteString(tr.Text)
+	}
+	return sb.String()
+}
+
+// ownComments gives the lines of comments in the leading trivia of the
+// first token of a statement, which are put before it at its column
+func ownComments(leading []tokenizer.Trivia) string {
+	text := ""
+	comments := false
+	for _, tr := range leading[lineBreak(leading):] {
+		text += tr.Text
+		comments = comments || tr.Kind == tokenizer.CommentTrivia
+	}
+	if !comments {
+		return ""
+	}
+	return strings.TrimLeft(text, " \t\f\r\n")
+}
+
+// reindent moves the lines of a text after the first one by delta columns
+func reindent(text string, delta int) string {
+	if delta == 0 {
+		return text
+	}
+	lines := strings.Split(text, "\n")
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "" {
+			continue
+		}
+		if delta > 0 {
+			lines[i] = strings.Repeat(" ", delta) + lines[i]
+		} else {
+			trimmed := strings.TrimLeft(lines[i], " ")
+			lines[i] = lines[i][min(len(lines[i])-len(trimmed), -delta):]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// advance gives the column after a text put at a column
+func advance(col int, text string) int {
+	if i := strings.LastIndexByte(text, '\n'); i >= 0 {
+		return utf8.RuneCountInString(text[i+1:])
+	}
+	return col + utf8.RuneCountInString(text)
+}
@@ -0,0 +1,256 @@
+package format
+
+import (
+	"testing"
+
+	"github.com/kvarenzn/pinecone/ast"
+	"github.com/kvarenzn/pinecone/parser"
+)
+
+func TestTreeRoundTrip(t *testing.T) {
+	sources := []string{
+		"",
+		"// only a comment",
+		"//@version=5\nindicator(\"x\")\nplot(close) // trailing\n",
+		"//@version=5\r\nx = 1\r\n\r\n// between\r\ny = x\r\n",
+		"//@version=5\nf(x) =>\n    // in a block\n    y = x * 2\n\n    y\n\nplot(f(1),\n     color = #ff0080,  // wrapped\n     title = 'é')\n",
+		"//@version=5\nx = 1\t+\t2   \n   \n",
+		"//@version=5\nif close > open // up\n    a = 1, b = 2\nelse\n    a := 2\n//@variable at the end\n",
+		// text skipped because of errors
+		"//@version=5\nx = 1 ¤ 2\n",
+	}
+
+	for _, src := range sources {
+		tree, _ := parser.ParseLossless(src)
+		got, err := Tree(tree)
+		if err != nil {
+			t.Errorf("%q: %v", src, err)
+			continue
+		}
+		if string(got) != src {
+			t.Errorf("%q: printed as %q", src, got)
+		}
+	}
+}
+
+// visit calls f for each node of the tree which is of the type T
+func visit[T ast.Node](root ast.Node, f func(*ast.Cursor, T)) {
+	ast.Apply(root, func(c *ast.Cursor) bool {
+		if n, ok := c.Node().(T); ok {
+			f(c, n)
+		}
+		return true
+	}, nil)
+}
+
+func TestTreeEdits(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		edit func(root *ast.Suite)
+		want string
+	}{
+		{
+			name: "rename",
+			src: `//@version=5
+indicator("x")
+// the length of the average
+length = input.int(14,  "Length") // in bars
+plot(ta.sma(close, length)) // the average
+`,
+			edit: func(root *ast.Suite) {
+				visit(root, func(c *ast.Cursor, n *ast.Identifier) {
+					if n.Name == "length" {
+						n.Name = "len"
+					}
+				})
+				visit(root, func(c *ast.Cursor, n *ast.VarDeclStmt) {
+					n.Name = "len"
+				})
+			},
+			want: `//@version=5
+indicator("x")
+// the length of the average
+len = input.int(14,  "Length") // in bars
+plot(ta.sma(close, len)) // the average
+`,
+		},
+		{
+			name: "replace an expression",
+			src: `//@version=5
+x = a+b  // the sum
+plot(ta.sma(close , 10))
+`,
+			edit: func(root *ast.Suite) {
+				visit(root, func(c *ast.Cursor, n *ast.BinaryExpr) {
+					c.Replace(&ast.UnaryExpr{Op: "-", Expr: n})
+				})
+				visit(root, func(c *ast.Cursor, n *ast.Identifier) {
+					if n.Name == "close" {
+						c.Replace(&ast.Identifier{Name: "hl2"})
+					}
+				})
+			},
+			want: `//@version=5
+x = -(a+b)  // the sum
+plot(ta.sma(hl2 , 10))
+`,
+		},
+		{
+			name: "insert and delete statements",
+			src: `//@version=5
+f(x) =>
+    // doubled
+    y = x * 2 // twice
+    z = y + 1 // unused
+    y
+
+plot(f(1)) // the last line
+`,
+			edit: func(root *ast.Suite) {
+				visit(root, func(c *ast.Cursor, n *ast.VarDeclStmt) {
+					switch n.Name {
+					case "y":
+						c.InsertAfter(&ast.ExprStmt{Expr: &ast.CallExpr{
+							Func: &ast.Identifier{Name: "log.info"},
+							Args: []ast.Node{&ast.StringLiteral{Value: "y"}},
+						}})
+					case "z":
+						c.Delete()
+					}
+				})
+				root.Body = append(root.Body, &ast.ExprStmt{Expr: &ast.CallExpr{
+					Func: &ast.Identifier{Name: "plot"},
+					Args: []ast.Node{&ast.Identifier{Name: "open"}},
+				}})
+			},
+			want: `//@version=5
+f(x) =>
+    // doubled
+    y = x * 2 // twice
+    log.info("y")
+    y
+
+plot(f(1)) // the last line
+plot(open)
+`,
+		},
+		{
+			name: "move statements",
+			src: `//@version=5
+// first
+a = 1 // one
+
+// second
+b = 2 // two
+`,
+			edit: func(root *ast.Suite) {
+				root.Body[0], root.Body[1] = root.Body[1], root.Body[0]
+				root.Body = append([]ast.Node{&ast.ExprStmt{Expr: &ast.Identifier{Name: "c"}}}, root.Body...)
+			},
+			want: `//@version=5
+c
+
+// second
+b = 2 // two
+// first
+a = 1 // one
+`,
+		},
+		{
+			name: "restructure a statement",
+			src: `//@version=5
+x = ta.sma(close,   10) // the average
+if x > 1 // above
+    // say it
+    label.new(bar_index, x) // here
+`,
+			edit: func(root *ast.Suite) {
+				visit(root, func(c *ast.Cursor, n *ast.VarDeclStmt) {
+					mode := "var"
+					n.DeclMode = &mode
+				})
+				visit(root, func(c *ast.Cursor, n *ast.IfStmt) {
+					n.False = &ast.BreakStmt{}
+				})
+			},
+			want: `//@version=5
+var x = ta.sma(close,   10) // the average
+if x > 1 // above
+    // say it
+    label.new(bar_index, x) // here
+else
+    break
+`,
+		},
+		{
+			name: "wrap a statement",
+			src: `//@version=5
+f() =>
+    // draw
+    line.new(bar_index, low,
+         bar_index, high) // the range
+    1
+`,
+			edit: func(root *ast.Suite) {
+				visit(root, func(c *ast.Cursor, n *ast.ExprStmt) {
+					if _, ok := n.Expr.(*ast.CallExpr); ok {
+						c.Replace(&ast.IfStmt{Test: &ast.Identifier{Name: "barstate.islast"}, True: n})
+					}
+				})
+			},
+			want: `//@version=5
+f() =>
+    if barstate.islast
+        // draw
+        line.new(bar_index, low,
+             bar_index, high) // the range
+    1
+`,
+		},
+		{
+			name: "new argument",
+			src: `//@version=5
+plot(close,   "Close") // the close
+`,
+			edit: func(root *ast.Suite) {
+				visit(root, func(c *ast.Cursor, n *ast.CallExpr) {
+					n.Args = append(n.Args, &ast.KwArg{Name: "color", Value: &ast.Identifier{Name: "color.red"}})
+				})
+			},
+			want: `//@version=5
+plot(close, "Close", color = color.red) // the close
+`,
+		},
+		{
+			name: "new string",
+			src: `//@version=5
+indicator('old', overlay = true)
+`,
+			edit: func(root *ast.Suite) {
+				visit(root, func(c *ast.Cursor, n *ast.StringLiteral) {
+					n.Value = "new"
+				})
+			},
+			want: `//@version=5
+indicator("new", overlay = true)
+`,
+		},
+	}
+
+	for _, test := range tests {
+		tree, errs := parser.ParseLossless(test.src)
+		if len(errs) > 0 {
+			t.Fatalf("%s: %v", test.name, errs)
+		}
+		test.edit(tree.Root)
+		got, err := Tree(tree)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", test.name, got, test.want)
+		}
+	}
+}
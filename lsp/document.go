@@ -0,0 +1,168 @@
+package lsp
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/kvarenzn/pinecone/analyzer"
+	"github.com/kvarenzn/pinecone/ast"
+	"github.com/kvarenzn/pinecone/base"
+	"github.com/kvarenzn/pinecone/metainfo"
+	"github.com/kvarenzn/pinecone/parser"
+	"github.com/kvarenzn/pinecone/tokenizer"
+)
+
+// document is an open script and the result of its analysis
+type document struct {
+	uri     string
+	version int
+	text    string
+	file    *metainfo.File
+	// nil when the text is replaced as a whole, until it is analyzed
+	tree *parser.Tree
+	root *ast.Suite
+	info *analyzer.Info
+	// the diagnostics of tokenizer, parser and analyzer
+	diagnostics []metainfo.Diagnostic
+}
+
+func newDocument(uri string, version int, text string, namespace base.Namespace) *document {
+	d := &document{
+		uri:     uri,
+		version: version,
+		text:    text,
+	}
+	d.analyze(namespace)
+	return d
+}
+
+// change applies a change sent by the client to the text. The tree is
+// updated incrementally if the change has a range.
+func (d *document) change(c TextDocumentContentChangeEvent) {
+	if c.Range == nil {
+		d.text = c.Text
+		d.tree = nil
+		return
+	}
+
+	// ranges refer to the text after the previous change
+	file := metainfo.NewFileSet().AddFile(d.uri, []byte(d.text))
+	start := file.Offset(file.PosForUTF16(c.Range.Start.Line+1, c.Range.Start.Character+1))
+	end := file.Offset(file.PosForUTF16(c.Range.End.Line+1, c.Range.End.Character+1))
+	end = max(start, end)
+	d.text = d.text[:start] + c.Text + d.text[end:]
+	if d.tree != nil {
+		d.tree.Edit(start, end, c.Text)
+	}
+}
+
+// analyze parses the text, if it is not parsed yet, and analyzes it
+func (d *document) analyze(namespace base.Namespace) {
+	d.file = metainfo.NewFileSet().AddFile(d.uri, []byte(d.text))
+	d.diagnostics = nil
+	d.root = nil
+	d.info = nil
+
+	if d.tree == nil {
+		d.tree = parser.NewFileTree(d.file)
+	}
+
+	for _, e := range d.tree.Errors() {
+		d.diagnostics = append(d.diagnostics, e.Diagnostic(d.file))
+	}
+	d.root = d.tree.Root()
+
+	// the statements reused from the last version still have their types
+	ast.Inspect(d.root, func(n ast.Node) bool {
+		if n != nil {
+			n.MarkNodeType(nil)
+		}
+		return true
+	})
+
+	version, _ := tokenizer.DetectVersion(d.tree.Tokens())
+	var info *analyzer.Info
+	var errs []error
+	if err := catch(func() { info, errs = analyzer.Analyze(version, namespace, d.root) }); err != nil {
+		log.Printf("%s: analyzer panicked: %v", d.uri, err)
+		return
+	}
+	d.info = info
+	d.diagnostics = append(d.diagnostics, analyzer.Diagnostics(d.file, errs)...)
+}
+
+// catch turns a panic of the analyzer into an error
+func catch(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	f()
+	return nil
+}
+
+func (d *document) offset(p Position) int {
+	return d.file.Offset(d.file.PosForUTF16(p.Line+1, p.Character+1))
+}
+
+func (d *document) position(p metainfo.Pos) Position {
+	if !p.IsValid() {
+		return Position{}
+	}
+	pos := d.file.Position(p)
+	return Position{
+		Line:      pos.Line - 1,
+		Character: pos.UTF16Column - 1,
+	}
+}
+
+func (d *document) rangeOf(n ast.Node) Range {
+	return Range{
+		Start: d.position(d.file.PosOf(n.Begin())),
+		End:   d.position(d.file.EndOf(n.End())),
+	}
+}
+
+func (d *document) lspDiagnostics() []Diagnostic {
+	result := []Diagnostic{}
+	for _, diag := range d.diagnostics {
+		severity := SeverityError
+		if diag.Severity == metainfo.SeverityWarning {
+			severity = SeverityWarning
+		}
+		result = append(result, Diagnostic{
+			Range: Range{
+				Start: d.position(diag.Pos),
+				End:   d.position(diag.End),
+			},
+			Severity: severity,
+			Source:   "pinecone",
+			Message:  diag.Msg,
+		})
+	}
+	return result
+}
+
+// nodeAt finds the innermost node containing the rune at an offset
+func (d *document) nodeAt(offset int) ast.Node {
+	if d.root == nil {
+		return nil
+	}
+
+	var found ast.Node
+	ast.Inspect(d.root, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		if _, ok := n.(*ast.Suite); ok {
+			return true
+		}
+		if offset < n.Begin().Offset || offset > n.End().Offset {
+			return false
+		}
+		found = n
+		return true
+	})
+	return found
+}
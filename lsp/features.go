@@ -0,0 +1,478 @@
+package lsp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kvarenzn/pinecone/analyzer"
+	"github.com/kvarenzn/pinecone/ast"
+	"github.com/kvarenzn/pinecone/base"
+	"github.com/kvarenzn/pinecone/tokenizer"
+	"github.com/kvarenzn/pinecone/types"
+)
+
+func (d *document) hover(p Position) *Hover {
+	node := d.nodeAt(d.offset(p))
+	if node == nil {
+		return nil
+	}
+
+	var text string
+	if sym := d.symbolOf(node); sym != nil {
+		text = describe(sym)
+	} else if t := node.NodeType(); t != nil {
+		text = t.String()
+	} else {
+		return nil
+	}
+
+	r := d.rangeOf(node)
+	return &Hover{
+		Contents: MarkupContent{
+			Kind:  "markdown",
+			Value: "```pine\n" + text + "\n```",
+		},
+		Range: &r,
+	}
+}
+
+func (d *document) symbolOf(node ast.Node) *analyzer.Symbol {
+	if d.info == nil {
+		return nil
+	}
+	return d.info.SymbolOf(node)
+}
+
+func describe(sym *analyzer.Symbol) string {
+	switch {
+	case sym.Kind == analyzer.FunctionSymbol:
+		return fmt.Sprintf("(%s) %s", sym.Kind, types.Signature(sym.Name, sym.Type))
+	case sym.Kind == analyzer.TypeSymbol || sym.Kind == analyzer.EnumSymbol || sym.Type == nil:
+		return fmt.Sprintf("(%s) %s", sym.Kind, sym.Name)
+	}
+
+	t := sym.Type
+	if sym.Qualifier != types.NoQualifier {
+		t = types.TypeWithQualifier{Qualifier: sym.Qualifier, Type: t}
+	}
+	return fmt.Sprintf("(%s) %s: %s", sym.Kind, sym.Name, t)
+}
+
+func (d *document) definition(p Position) *Location {
+	node := d.nodeAt(d.offset(p))
+	if node == nil {
+		return nil
+	}
+	sym := d.symbolOf(node)
+	if sym == nil || sym.Decl == nil {
+		return nil
+	}
+	return &Location{
+		URI:   d.uri,
+		Range: d.rangeOf(sym.Decl),
+	}
+}
+
+// chainBefore is the dotted name ending at an offset, like ["ta", "sm"] for
+// 'ta.sm|'
+func (d *document) chainBefore(offset int) []string {
+	begin := offset
+	for begin > 0 {
+		c := d.text[begin-1]
+		if c != '.' && c != '_' && !('a' <= c && c <= 'z') && !('A' <= c && c <= 'Z') && !('0' <= c && c <= '9') {
+			break
+		}
+		begin--
+	}
+	return strings.Split(d.text[begin:offset], ".")
+}
+
+func (d *document) completion(p Position, namespace base.Namespace) *CompletionList {
+	offset := d.offset(p)
+	chain := d.chainBefore(offset)
+
+	var items []CompletionItem
+	if len(chain) > 1 {
+		items = d.memberCompletion(chain[:len(chain)-1], namespace)
+	} else {
+		items = d.globalCompletion(offset, namespace)
+	}
+	if items == nil {
+		items = []CompletionItem{}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Label < items[j].Label
+	})
+	return &CompletionList{Items: items}
+}
+
+func (d *document) globalCompletion(offset int, namespace base.Namespace) []CompletionItem {
+	items := []CompletionItem{}
+	for kw := range tokenizer.KEYWORDS {
+		items = append(items, CompletionItem{Label: kw, Kind: CompletionKeyword})
+	}
+	items = append(items, namespaceCompletion(namespace)...)
+
+	if d.info == nil {
+		return items
+	}
+	// the symbols in the enclosing scopes which are declared before the
+	// cursor
+	seen := map[string]bool{}
+	for scope := d.scopeAt(offset); scope != nil; scope = scope.Parent {
+		for name, sym := range scope.Symbols {
+			if seen[name] || sym.Decl == nil || sym.Decl.Begin().Offset > offset {
+				continue
+			}
+			seen[name] = true
+			items = append(items, symbolCompletion(sym))
+		}
+	}
+	return items
+}
+
+func (d *document) scopeAt(offset int) *analyzer.Scope {
+	node := d.nodeAt(offset)
+	if node == nil {
+		if scope, ok := d.info.Scopes[d.root]; ok {
+			return scope
+		}
+		return d.info.Root
+	}
+	return d.info.ScopeAt(node)
+}
+
+func namespaceCompletion(namespace base.Namespace) []CompletionItem {
+	items := []CompletionItem{}
+	for name := range namespace.Callables {
+		items = append(items, CompletionItem{Label: name, Kind: CompletionFunction})
+	}
+	for name, v := range namespace.Variables {
+		item := CompletionItem{Label: name, Kind: CompletionVariable}
+		if v.Type != nil {
+			item.Detail = v.Type.String()
+		}
+		items = append(items, item)
+	}
+	for name := range namespace.Types {
+		items = append(items, CompletionItem{Label: name, Kind: CompletionClass})
+	}
+	for name := range namespace.SubNamespace {
+		if _, ok := namespace.Callables[name]; ok {
+			// like 'color', which is both a function and a namespace
+			continue
+		}
+		items = append(items, CompletionItem{Label: name, Kind: CompletionModule})
+	}
+	return items
+}
+
+var symbolCompletionKinds = map[analyzer.SymbolKind]int{
+	analyzer.VariableSymbol:    CompletionVariable,
+	analyzer.ConstantSymbol:    CompletionConstant,
+	analyzer.ParameterSymbol:   CompletionVariable,
+	analyzer.LoopCounterSymbol: CompletionVariable,
+	analyzer.FunctionSymbol:    CompletionFunction,
+	analyzer.TypeSymbol:        CompletionStruct,
+	analyzer.FieldSymbol:       CompletionField,
+	analyzer.EnumSymbol:        CompletionEnum,
+	analyzer.EnumFieldSymbol:   CompletionEnumMember,
+}
+
+func symbolCompletion(sym *analyzer.Symbol) CompletionItem {
+	item := CompletionItem{
+		Label: sym.Name,
+		Kind:  symbolCompletionKinds[sym.Kind],
+	}
+	if sym.Type != nil {
+		item.Detail = sym.Type.String()
+	}
+	return item
+}
+
+func (d *document) memberCompletion(chain []string, namespace base.Namespace) []CompletionItem {
+	// namespaces of the builtins, like 'ta' or 'strategy.direction'
+	ns, ok := namespace, true
+	for _, name := range chain {
+		if ns, ok = ns.SubNamespace[name]; !ok {
+			break
+		}
+	}
+	if ok {
+		return namespaceCompletion(ns)
+	}
+
+	if d.info == nil {
+		return nil
+	}
+	sym := d.info.Root.Lookup(chain[0])
+	if sym == nil {
+		sym = d.lookupVisible(chain[0])
+	}
+	if sym == nil {
+		return nil
+	}
+
+	switch sym.Kind {
+	case analyzer.TypeSymbol:
+		if len(chain) == 1 {
+			return []CompletionItem{{Label: "new", Kind: CompletionMethod, Detail: sym.Name}}
+		}
+		return nil
+	case analyzer.EnumSymbol:
+		if len(chain) == 1 {
+			return membersCompletion(sym.Members)
+		}
+		return nil
+	}
+
+	// fields of objects, like 'p.x.'
+	t := sym.Type
+	for _, name := range chain[1:] {
+		field := d.member(t, name)
+		if field == nil {
+			return nil
+		}
+		t = field.Type
+	}
+	udt := d.typeSymbol(t)
+	if udt == nil {
+		return nil
+	}
+	items := membersCompletion(udt.Members)
+	if udt.Kind == analyzer.TypeSymbol {
+		items = append(items, CompletionItem{Label: "copy", Kind: CompletionMethod, Detail: udt.Name})
+	}
+	for _, fn := range d.info.Symbols {
+		decl, ok := fn.Decl.(*ast.FuncDeclStmt)
+		if !ok || !decl.Method || len(decl.Params) == 0 {
+			continue
+		}
+		if self := decl.Params[0].NodeType(); self != nil && types.Peel(self).String() == udt.Name {
+			items = append(items, CompletionItem{Label: fn.Name, Kind: CompletionMethod})
+		}
+	}
+	return items
+}
+
+// lookupVisible finds a symbol by name in any scope, as the line being
+// typed is often too incomplete to be placed in one
+func (d *document) lookupVisible(name string) *analyzer.Symbol {
+	for _, sym := range d.info.Symbols {
+		if sym.Name == name && sym.Kind != analyzer.FieldSymbol && sym.Kind != analyzer.EnumFieldSymbol {
+			return sym
+		}
+	}
+	return nil
+}
+
+func (d *document) typeSymbol(t types.Type) *analyzer.Symbol {
+	if t == nil {
+		return nil
+	}
+	sym := d.info.Root.Symbols[types.Peel(t).String()]
+	if sym == nil || sym.Members == nil {
+		return nil
+	}
+	return sym
+}
+
+func (d *document) member(t types.Type, name string) *analyzer.Symbol {
+	sym := d.typeSymbol(t)
+	if sym == nil {
+		return nil
+	}
+	return sym.Members.Symbols[name]
+}
+
+func membersCompletion(members *analyzer.Scope) []CompletionItem {
+	items := []CompletionItem{}
+	for _, sym := range members.Symbols {
+		items = append(items, symbolCompletion(sym))
+	}
+	return items
+}
+
+// callBefore finds the unclosed call around an offset, returning the dotted
+// name of the function and the index of the argument at the offset
+func (d *document) callBefore(offset int) ([]string, int, bool) {
+	depth, commas := 0, 0
+	for i := offset - 1; i >= 0; i-- {
+		switch d.text[i] {
+		case ')', ']':
+			depth++
+		case '[':
+			depth--
+		case '(':
+			if depth == 0 {
+				chain := d.chainBefore(i)
+				if chain[len(chain)-1] == "" {
+					return nil, 0, false
+				}
+				return chain, commas, true
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				commas++
+			}
+		case '\n':
+			// arguments on continuation lines are indented
+			if i+1 < len(d.text) && d.text[i+1] != ' ' && d.text[i+1] != '\t' {
+				return nil, 0, false
+			}
+		}
+		if depth < 0 {
+			return nil, 0, false
+		}
+	}
+	return nil, 0, false
+}
+
+func (d *document) signatureHelp(p Position, namespace base.Namespace) *SignatureHelp {
+	chain, index, ok := d.callBefore(d.offset(p))
+	if !ok {
+		return nil
+	}
+
+	signatures := []types.Type{}
+	if len(chain) == 1 && d.info != nil {
+		for _, sym := range d.info.Symbols {
+			if sym.Kind == analyzer.FunctionSymbol && sym.Name == chain[0] && sym.Type != nil {
+				signatures = append(signatures, sym.Type)
+			}
+		}
+	}
+	if len(signatures) == 0 {
+		ns, ok := namespace, true
+		for _, name := range chain[:len(chain)-1] {
+			if ns, ok = ns.SubNamespace[name]; !ok {
+				return nil
+			}
+		}
+		switch fn := ns.Callables[chain[len(chain)-1]].(type) {
+		case types.BuiltinFunction:
+			signatures = fn.Types
+		case types.GenericFunction:
+			return genericSignatureHelp(fn, index)
+		default:
+			return nil
+		}
+	}
+
+	help := &SignatureHelp{
+		Signatures:      []SignatureInformation{},
+		ActiveSignature: -1,
+		ActiveParameter: index,
+	}
+	name := strings.Join(chain, ".")
+	for _, t := range signatures {
+		if types.Peel(t).Kind() != types.FunctionKind {
+			continue
+		}
+		info := SignatureInformation{
+			Label:      types.Signature(name, t),
+			Parameters: []ParameterInformation{},
+		}
+		params := t.AllIn()
+		for _, in := range params {
+			info.Parameters = append(info.Parameters, ParameterInformation{Label: in.String()})
+		}
+		if help.ActiveSignature < 0 && index < len(params) {
+			help.ActiveSignature = len(help.Signatures)
+		}
+		help.Signatures = append(help.Signatures, info)
+	}
+	if len(help.Signatures) == 0 {
+		return nil
+	}
+	help.ActiveSignature = max(help.ActiveSignature, 0)
+	return help
+}
+
+func genericSignatureHelp(fn types.GenericFunction, index int) *SignatureHelp {
+	help := &SignatureHelp{
+		Signatures:      []SignatureInformation{},
+		ActiveSignature: -1,
+		ActiveParameter: index,
+	}
+	for _, gs := range fn.Overloads {
+		info := SignatureInformation{
+			Label:      gs.String(),
+			Parameters: []ParameterInformation{},
+		}
+		for _, p := range gs.Params {
+			info.Parameters = append(info.Parameters, ParameterInformation{Label: p.String()})
+		}
+		if help.ActiveSignature < 0 && index < len(gs.Params) {
+			help.ActiveSignature = len(help.Signatures)
+		}
+		help.Signatures = append(help.Signatures, info)
+	}
+	if len(help.Signatures) == 0 {
+		return nil
+	}
+	help.ActiveSignature = max(help.ActiveSignature, 0)
+	return help
+}
+
+var documentSymbolKinds = map[analyzer.SymbolKind]int{
+	analyzer.VariableSymbol:  SymbolVariable,
+	analyzer.ConstantSymbol:  SymbolConstant,
+	analyzer.FunctionSymbol:  SymbolFunction,
+	analyzer.TypeSymbol:      SymbolStruct,
+	analyzer.FieldSymbol:     SymbolField,
+	analyzer.EnumSymbol:      SymbolEnum,
+	analyzer.EnumFieldSymbol: SymbolEnumMember,
+}
+
+func (d *document) documentSymbols() []DocumentSymbol {
+	result := []DocumentSymbol{}
+	if d.info == nil {
+		return result
+	}
+
+	// user defined types are declared before the other symbols, they are
+	// listed in the order of the source like the others
+	top := d.info.Scopes[d.root]
+	symbols := []*analyzer.Symbol{}
+	for _, sym := range d.info.Symbols {
+		if sym.Scope == d.info.Root || (top != nil && sym.Scope == top) {
+			symbols = append(symbols, sym)
+		}
+	}
+	sort.SliceStable(symbols, func(i, j int) bool {
+		return symbols[i].Decl.Begin().Offset < symbols[j].Decl.Begin().Offset
+	})
+	for _, sym := range symbols {
+		result = append(result, d.documentSymbol(sym))
+	}
+	return result
+}
+
+func (d *document) documentSymbol(sym *analyzer.Symbol) DocumentSymbol {
+	r := d.rangeOf(sym.Decl)
+	ds := DocumentSymbol{
+		Name:           sym.Name,
+		Kind:           documentSymbolKinds[sym.Kind],
+		Range:          r,
+		SelectionRange: r,
+	}
+	if sym.Type != nil {
+		ds.Detail = sym.Type.String()
+	}
+	if sym.Members != nil {
+		members := []*analyzer.Symbol{}
+		for _, m := range sym.Members.Symbols {
+			members = append(members, m)
+		}
+		sort.Slice(members, func(i, j int) bool {
+			return members[i].Decl.Begin().Offset < members[j].Decl.Begin().Offset
+		})
+		for _, m := range members {
+			ds.Children = append(ds.Children, d.documentSymbol(m))
+		}
+	}
+	return ds
+}
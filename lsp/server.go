@@ -0,0 +1,207 @@
+package lsp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/kvarenzn/pinecone/base"
+	"github.com/kvarenzn/pinecone/builtins"
+)
+
+// Server is a language server for pine script, which answers the requests
+// of one client one by one
+type Server struct {
+	conn      *conn
+	namespace base.Namespace
+	documents map[string]*document
+	shutdown  bool
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		conn:      newConn(in, out),
+		namespace: builtins.GlobalNamespace,
+		documents: map[string]*document{},
+	}
+}
+
+// Serve runs a language server over a pair of streams, usually the standard
+// input and output, until the client exits
+func Serve(in io.Reader, out io.Writer) error {
+	return NewServer(in, out).Run()
+}
+
+func (s *Server) Run() error {
+	for {
+		msg, err := s.conn.read()
+		if err != nil {
+			var re *responseError
+			if errors.As(err, &re) {
+				if err := s.conn.reply(json.RawMessage("null"), nil, re); err != nil {
+					return err
+				}
+				continue
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exit before shutdown")
+			}
+			return nil
+		}
+
+		result, err := s.handle(msg)
+		if msg.ID == nil {
+			if err != nil {
+				log.Printf("%s: %v", msg.Method, err)
+			}
+			continue
+		}
+		if err := s.conn.reply(msg.ID, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(msg *message) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: %v", msg.Method, r)
+		}
+	}()
+
+	switch msg.Method {
+	case "initialize":
+		return InitializeResult{
+			Capabilities: ServerCapabilities{
+				TextDocumentSync:   SyncIncremental,
+				HoverProvider:      true,
+				DefinitionProvider: true,
+				CompletionProvider: CompletionOptions{
+					TriggerCharacters: []string{"."},
+				},
+				SignatureHelpProvider: SignatureHelpOptions{
+					TriggerCharacters: []string{"(", ","},
+				},
+				DocumentSymbolProvider: true,
+			},
+			ServerInfo: ServerInfo{Name: "pinecone"},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		item := params.TextDocument
+		doc := newDocument(item.URI, item.Version, item.Text, s.namespace)
+		s.documents[item.URI] = doc
+		return nil, s.publish(doc)
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		doc, ok := s.documents[params.TextDocument.URI]
+		if !ok {
+			return nil, fmt.Errorf("document %s is not open", params.TextDocument.URI)
+		}
+		for _, change := range params.ContentChanges {
+			doc.change(change)
+		}
+		doc.version = params.TextDocument.Version
+		doc.analyze(s.namespace)
+		return nil, s.publish(doc)
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(s.documents, params.TextDocument.URI)
+		return nil, s.conn.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []Diagnostic{},
+		})
+	case "textDocument/hover":
+		doc, pos, err := s.position(msg.Params)
+		if err != nil {
+			return nil, err
+		}
+		return doc.hover(pos), nil
+	case "textDocument/definition":
+		doc, pos, err := s.position(msg.Params)
+		if err != nil {
+			return nil, err
+		}
+		return doc.definition(pos), nil
+	case "textDocument/completion":
+		doc, pos, err := s.position(msg.Params)
+		if err != nil {
+			return nil, err
+		}
+		return doc.completion(pos, s.namespace), nil
+	case "textDocument/signatureHelp":
+		doc, pos, err := s.position(msg.Params)
+		if err != nil {
+			return nil, err
+		}
+		return doc.signatureHelp(pos, s.namespace), nil
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		doc, ok := s.documents[params.TextDocument.URI]
+		if !ok {
+			return nil, fmt.Errorf("document %s is not open", params.TextDocument.URI)
+		}
+		return doc.documentSymbols(), nil
+	}
+
+	return nil, &responseError{
+		Code:    codeMethodNotFound,
+		Message: fmt.Sprintf("method %q not found", msg.Method),
+	}
+}
+
+func unmarshal(params json.RawMessage, v any) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &responseError{
+			Code:    codeInvalidParams,
+			Message: err.Error(),
+		}
+	}
+	return nil
+}
+
+func (s *Server) position(params json.RawMessage) (*document, Position, error) {
+	var p TextDocumentPositionParams
+	if err := unmarshal(params, &p); err != nil {
+		return nil, Position{}, err
+	}
+	doc, ok := s.documents[p.TextDocument.URI]
+	if !ok {
+		return nil, Position{}, fmt.Errorf("document %s is not open", p.TextDocument.URI)
+	}
+	return doc, p.Position, nil
+}
+
+func (s *Server) publish(doc *document) error {
+	return s.conn.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         doc.uri,
+		Version:     doc.version,
+		Diagnostics: doc.lspDiagnostics(),
+	})
+}
@@ -0,0 +1,273 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// client drives a server over pipes, like an editor would
+type client struct {
+	t      *testing.T
+	in     *io.PipeWriter
+	out    *textproto.Reader
+	nextID int
+	done   chan error
+	// the diagnostics published for the documents, by uri
+	diagnostics map[string][]Diagnostic
+}
+
+func newClient(t *testing.T) *client {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &client{
+		t:           t,
+		in:          inW,
+		out:         textproto.NewReader(bufio.NewReader(outR)),
+		done:        make(chan error, 1),
+		diagnostics: map[string][]Diagnostic{},
+	}
+	go func() {
+		c.done <- Serve(inR, outW)
+		outW.Close()
+	}()
+	return c
+}
+
+func (c *client) send(v any) {
+	c.t.Helper()
+	body, err := json.Marshal(v)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := fmt.Fprintf(c.in, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// incoming is a response or a notification from the server
+type incoming struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *responseError  `json:"error"`
+}
+
+func (c *client) receive() incoming {
+	c.t.Helper()
+	header, err := c.out.ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.out.R, body); err != nil {
+		c.t.Fatal(err)
+	}
+
+	var msg incoming
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+	if msg.Method == "textDocument/publishDiagnostics" {
+		var params PublishDiagnosticsParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			c.t.Fatal(err)
+		}
+		c.diagnostics[params.URI] = params.Diagnostics
+	}
+	return msg
+}
+
+// call sends a request and decodes the result of its response into result,
+// the notifications sent before the response are recorded
+func (c *client) call(method string, params any, result any) {
+	c.t.Helper()
+	c.nextID++
+	id := c.nextID
+	c.send(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"method":  method,
+		"params":  params,
+	})
+	for {
+		msg := c.receive()
+		if msg.ID == nil || *msg.ID != id {
+			continue
+		}
+		if msg.Error != nil {
+			c.t.Fatalf("%s: %s", method, msg.Error.Message)
+		}
+		if err := json.Unmarshal(msg.Result, result); err != nil {
+			c.t.Fatalf("%s: %v", method, err)
+		}
+		return
+	}
+}
+
+// notify sends a notification, and waits for the diagnostics the server
+// publishes for it
+func (c *client) notify(method string, params any) {
+	c.t.Helper()
+	c.send(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+	})
+	for c.receive().Method != "textDocument/publishDiagnostics" {
+	}
+}
+
+func (c *client) close() {
+	c.t.Helper()
+	var result any
+	c.call("shutdown", nil, &result)
+	c.send(map[string]any{"jsonrpc": "2.0", "method": "exit"})
+	if err := <-c.done; err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+const testURI = "file:///test.pine"
+
+const testScript = `//@version=5
+indicator("test")
+length = 14
+double(float x) => x * 2
+y = double(length)
+type Point
+    float x = 0.0
+    float y = 0.0
+p = Point.new()
+z = math.max(p.x, 1)
+`
+
+func at(line, character int) TextDocumentPositionParams {
+	return TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: testURI},
+		Position:     Position{Line: line, Character: character},
+	}
+}
+
+func completionLabels(c *client, params TextDocumentPositionParams) map[string]bool {
+	c.t.Helper()
+	var completion CompletionList
+	c.call("textDocument/completion", params, &completion)
+	labels := map[string]bool{}
+	for _, item := range completion.Items {
+		labels[item.Label] = true
+	}
+	return labels
+}
+
+func TestServer(t *testing.T) {
+	c := newClient(t)
+
+	var init InitializeResult
+	c.call("initialize", map[string]any{"capabilities": map[string]any{}}, &init)
+	if !init.Capabilities.HoverProvider || init.Capabilities.TextDocumentSync != SyncIncremental {
+		t.Errorf("initialize: unexpected capabilities %+v", init.Capabilities)
+	}
+
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{
+			URI:        testURI,
+			LanguageID: "pine",
+			Version:    1,
+			Text:       testScript,
+		},
+	})
+	if d := c.diagnostics[testURI]; len(d) != 0 {
+		t.Errorf("didOpen: unexpected diagnostics %+v", d)
+	}
+
+	// 'length' in 'y = double(length)'
+	var hover Hover
+	c.call("textDocument/hover", at(4, 12), &hover)
+	if !strings.Contains(hover.Contents.Value, "length") || !strings.Contains(hover.Contents.Value, "int") {
+		t.Errorf("hover: got %q", hover.Contents.Value)
+	}
+
+	// 'double' in 'y = double(length)'
+	var def Location
+	c.call("textDocument/definition", at(4, 5), &def)
+	if def.URI != testURI || def.Range.Start.Line != 3 || def.Range.Start.Character != 0 {
+		t.Errorf("definition: got %+v", def)
+	}
+
+	// the beginning of the last line
+	var completion CompletionList
+	c.call("textDocument/completion", at(10, 0), &completion)
+	labels := map[string]bool{}
+	for _, item := range completion.Items {
+		labels[item.Label] = true
+	}
+	for _, want := range []string{"length", "double", "y", "close", "ta"} {
+		if !labels[want] {
+			t.Errorf("completion: %q is missing", want)
+		}
+	}
+
+	// the members of a namespace, after 'math.'
+	if labels := completionLabels(c, at(9, 9)); !labels["max"] || labels["length"] {
+		t.Errorf("completion of 'math.': got %v", labels)
+	}
+
+	// the fields of a user defined type, after 'p.'
+	if labels := completionLabels(c, at(9, 15)); !labels["x"] || !labels["y"] || labels["length"] {
+		t.Errorf("completion of 'p.': got %v", labels)
+	}
+
+	// in 'double(length)', a function of the script
+	var help SignatureHelp
+	c.call("textDocument/signatureHelp", at(4, 11), &help)
+	if len(help.Signatures) != 1 || help.Signatures[0].Label != "double(x: float) -> float" ||
+		len(help.Signatures[0].Parameters) != 1 || help.Signatures[0].Parameters[0].Label != "x: float" {
+		t.Errorf("signatureHelp: got %+v", help)
+	}
+
+	// in 'math.max(p.x, 1)', after the comma
+	help = SignatureHelp{}
+	c.call("textDocument/signatureHelp", at(9, 17), &help)
+	if len(help.Signatures) == 0 || !strings.HasPrefix(help.Signatures[0].Label, "math.max(") || help.ActiveParameter != 1 {
+		t.Errorf("signatureHelp of a builtin: got %+v", help)
+	}
+
+	var symbols []DocumentSymbol
+	c.call("textDocument/documentSymbol", DocumentSymbolParams{TextDocument: TextDocumentIdentifier{URI: testURI}}, &symbols)
+	names := []string{}
+	for _, sym := range symbols {
+		names = append(names, sym.Name)
+		if sym.Name == "Point" && (len(sym.Children) != 2 || sym.Children[0].Name != "x" || sym.Children[1].Name != "y") {
+			t.Errorf("documentSymbol: the fields of Point are %+v", sym.Children)
+		}
+	}
+	if got := strings.Join(names, " "); got != "length double y Point p z" {
+		t.Errorf("documentSymbol: got %s", got)
+	}
+
+	// an unterminated string is reported where it begins
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument: VersionedTextDocumentIdentifier{URI: testURI, Version: 2},
+		ContentChanges: []TextDocumentContentChangeEvent{{
+			Range: &Range{Start: Position{Line: 10, Character: 0}, End: Position{Line: 10, Character: 0}},
+			Text:  `s = "abc`,
+		}},
+	})
+	d := c.diagnostics[testURI]
+	if len(d) != 1 || d[0].Message != "Unterminated string" || d[0].Range.Start != (Position{Line: 10, Character: 4}) {
+		t.Errorf("didChange: got diagnostics %+v", d)
+	}
+
+	c.close()
+}
@@ -1,29 +1,277 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 
+	"github.com/kvarenzn/pinecone/analyzer"
+	"github.com/kvarenzn/pinecone/ast"
+	"github.com/kvarenzn/pinecone/builtins"
+	"github.com/kvarenzn/pinecone/format"
+	"github.com/kvarenzn/pinecone/lsp"
+	"github.com/kvarenzn/pinecone/metainfo"
+	"github.com/kvarenzn/pinecone/migrate"
 	"github.com/kvarenzn/pinecone/parser"
 	"github.com/kvarenzn/pinecone/tokenizer"
 )
 
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: pinecone <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  dump [file]                  print the tokens and the syntax tree of a script")
+	fmt.Fprintln(os.Stderr, "  fmt [--check] [--diff] files  format scripts")
+	fmt.Fprintln(os.Stderr, "  lsp                          run a language server over the standard input and output")
+	fmt.Fprintln(os.Stderr, "  migrate --to N [--diff] [--list] files")
+	fmt.Fprintln(os.Stderr, "                               upgrade scripts to a newer version of pine script")
+	os.Exit(2)
+}
+
 func main() {
-	code, err := os.ReadFile("../1.pine")
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "dump":
+		dump(os.Args[2:])
+	case "fmt":
+		os.Exit(runFmt(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+	case "migrate":
+		os.Exit(runMigrate(os.Args[2:]))
+	case "lsp":
+		if err := lsp.Serve(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	default:
+		usage()
+	}
+}
+
+func dump(args []string) {
+	flags := flag.NewFlagSet("dump", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the tokens, the syntax tree and the errors as a JSON document")
+	withTypes := flags.Bool("types", false, "analyze the script, so that the JSON document has the types of the nodes")
+	flags.Parse(args)
+
+	path := "../1.pine"
+	if flags.NArg() > 0 {
+		path = flags.Arg(0)
+	}
+
+	code, err := os.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	fset := metainfo.NewFileSet()
+	file := fset.AddFile(path, code)
+	tokens, tokenErrs := tokenizer.TokenizeFile(file)
+	root, errs := parser.ParseFile(tokens)
+	errs = append(parser.TokenizerErrors(tokenErrs), errs...)
+	diagnostics := []metainfo.Diagnostic{}
+	for _, err := range errs {
+		diagnostics = append(diagnostics, err.Diagnostic(file))
+	}
+	if *withTypes {
+		version, _ := tokenizer.DetectVersion(tokens)
+		typeErrs := analyzer.AnalyzeTypeIn(version, builtins.GlobalNamespace, root)
+		diagnostics = append(diagnostics, analyzer.Diagnostics(file, typeErrs)...)
+	}
+
+	if !*asJSON {
+		fmt.Println(tokens)
+		for _, a := range root.Annotations {
+			fmt.Println(ast.SExpr(a))
+		}
+		for _, stmt := range root.Body {
+			fmt.Println(ast.SExpr(stmt))
+		}
+		for _, d := range diagnostics {
+			fmt.Println(fset.Format(d))
+		}
+		return
+	}
+
+	tree, err := ast.ToJSON(root)
 	if err != nil {
 		panic(err)
 	}
-	tokens := tokenizer.Tokenize(string(code))
+	type jsonError struct {
+		Row     int    `json:"row,omitempty"`
+		Column  int    `json:"column,omitempty"`
+		Offset  int    `json:"offset"`
+		End     int    `json:"end"`
+		Warning bool   `json:"warning,omitempty"`
+		Message string `json:"message"`
+	}
+	doc := struct {
+		Tokens []tokenizer.Token `json:"tokens"`
+		AST    json.RawMessage   `json:"ast"`
+		Errors []jsonError       `json:"errors"`
+	}{
+		Tokens: tokens,
+		AST:    tree,
+		Errors: []jsonError{},
+	}
+	for _, d := range diagnostics {
+		e := jsonError{
+			Offset:  -1,
+			End:     -1,
+			Warning: d.Severity == metainfo.SeverityWarning,
+			Message: d.Msg,
+		}
+		if d.Pos.IsValid() {
+			pos := file.Position(d.Pos)
+			e.Row, e.Column, e.Offset, e.End = pos.Line, pos.Column, pos.Offset, file.Offset(d.End)
+		}
+		doc.Errors = append(doc.Errors, e)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		panic(err)
+	}
+}
+
+func runFmt(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	flags.SetOutput(stderr)
+	check := flags.Bool("check", false, "list the files that are not formatted and exit with status 1 if any")
+	diff := flags.Bool("diff", false, "print the changes instead of rewriting the files")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		src, err := io.ReadAll(stdin)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		res, err := format.Source(src)
+		if err != nil {
+			fmt.Fprintf(stderr, "<stdin>:%s\n", err)
+			return 1
+		}
+		switch {
+		case *check:
+			if !bytes.Equal(src, res) {
+				fmt.Fprintln(stdout, "<stdin>")
+				return 1
+			}
+		case *diff:
+			fmt.Fprint(stdout, format.Diff("<stdin>", src, res))
+		default:
+			stdout.Write(res)
+		}
+		return 0
+	}
 
-	fmt.Println(tokens)
+	status := 0
+	for _, path := range flags.Args() {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			status = 1
+			continue
+		}
+		res, err := format.Source(src)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s:%s\n", path, err)
+			status = 1
+			continue
+		}
+		if bytes.Equal(src, res) {
+			continue
+		}
 
-	stmts, errs := parser.Parse(tokens)
+		switch {
+		case *check:
+			fmt.Fprintln(stdout, path)
+			status = 1
+		case *diff:
+			fmt.Fprint(stdout, format.Diff(path, src, res))
+		default:
+			if err := os.WriteFile(path, res, 0644); err != nil {
+				fmt.Fprintln(stderr, err)
+				status = 1
+			}
+		}
+	}
+	return status
+}
 
-	for _, stmt := range stmts {
-		fmt.Printf("%#v\n", stmt)
+func runMigrate(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	to := flags.Int("to", int(metainfo.V5), "the version to migrate to")
+	diff := flags.Bool("diff", false, "print the changes instead of rewriting the files")
+	list := flags.Bool("list", false, "list the edits instead of rewriting the files")
+	flags.Parse(args)
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
 	}
 
-	for _, err := range errs {
-		fmt.Printf("%d:%d:%s\n", err.Row, err.Col, err.Msg)
+	status := 0
+	for _, path := range paths {
+		var src []byte
+		var err error
+		name := path
+		if path == "-" {
+			name = "<stdin>"
+			src, err = io.ReadAll(os.Stdin)
+		} else {
+			src, err = os.ReadFile(path)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+
+		result, err := migrate.Migrate(src, metainfo.LanguageVersion(*to))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", name, err)
+			status = 1
+			continue
+		}
+		for _, note := range result.Notes {
+			fmt.Fprintf(os.Stderr, "%s:%d:%d: %s\n", name, note.Row, note.Column, note.Msg)
+		}
+
+		if *list {
+			for _, edit := range result.Edits {
+				fmt.Printf("%s:%d:%d-%d:%d: %q", name, edit.Begin.Row, edit.Begin.Column, edit.End.Row, edit.End.Column, edit.Text)
+				if edit.Reason != "" {
+					fmt.Printf(" (%s)", edit.Reason)
+				}
+				fmt.Println()
+			}
+			continue
+		}
+
+		res, err := migrate.Apply(src, result.Edits)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", name, err)
+			status = 1
+			continue
+		}
+
+		switch {
+		case *diff:
+			fmt.Print(format.Diff(name, src, res))
+		case path == "-":
+			os.Stdout.Write(res)
+		case !bytes.Equal(src, res):
+			if err := os.WriteFile(path, res, 0644); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				status = 1
+			}
+		}
 	}
+	return status
 }
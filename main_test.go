@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	unformatted = "//@version=5\nx=1+2\n"
+	formatted   = "//@version=5\nx = 1 + 2\n"
+)
+
+// writeScripts writes the scripts into a temporary directory, and returns
+// their paths
+func writeScripts(t *testing.T, scripts ...string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	paths := []string{}
+	for i, src := range scripts {
+		path := filepath.Join(dir, string(rune('a'+i))+".pine")
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func readScript(t *testing.T, path string) string {
+	t.Helper()
+	src, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(src)
+}
+
+func TestFmtCheck(t *testing.T) {
+	paths := writeScripts(t, formatted, unformatted)
+	var stdout, stderr bytes.Buffer
+	if status := runFmt(append([]string{"-check"}, paths...), nil, &stdout, &stderr); status != 1 {
+		t.Errorf("exit status %d, want 1", status)
+	}
+	if got := stdout.String(); got != paths[1]+"\n" {
+		t.Errorf("listed %q, want only %s", got, paths[1])
+	}
+	if readScript(t, paths[1]) != unformatted {
+		t.Errorf("the file was rewritten")
+	}
+
+	stdout.Reset()
+	if status := runFmt([]string{"-check", paths[0]}, nil, &stdout, &stderr); status != 0 || stdout.Len() > 0 {
+		t.Errorf("a formatted file: exit status %d, output %q", status, stdout.String())
+	}
+}
+
+func TestFmtDiff(t *testing.T) {
+	paths := writeScripts(t, formatted, unformatted)
+	var stdout, stderr bytes.Buffer
+	if status := runFmt(append([]string{"-diff"}, paths...), nil, &stdout, &stderr); status != 0 {
+		t.Errorf("exit status %d, want 0", status)
+	}
+	got := stdout.String()
+	if strings.Contains(got, paths[0]) || !strings.Contains(got, paths[1]) ||
+		!strings.Contains(got, "\n-x=1+2\n") || !strings.Contains(got, "\n+x = 1 + 2\n") {
+		t.Errorf("unexpected diff:\n%s", got)
+	}
+	if readScript(t, paths[1]) != unformatted {
+		t.Errorf("the file was rewritten")
+	}
+}
+
+func TestFmtWrite(t *testing.T) {
+	paths := writeScripts(t, unformatted, "//@version=5\nx = (\n")
+	var stdout, stderr bytes.Buffer
+	if status := runFmt(paths, nil, &stdout, &stderr); status != 1 {
+		t.Errorf("exit status %d, want 1 for the script with errors", status)
+	}
+	if got := readScript(t, paths[0]); got != formatted {
+		t.Errorf("rewritten as %q", got)
+	}
+	if !strings.HasPrefix(stderr.String(), paths[1]+":") {
+		t.Errorf("the error is reported as %q", stderr.String())
+	}
+}
+
+func TestFmtStdin(t *testing.T) {
+	tests := []struct {
+		args   []string
+		status int
+		out    string
+	}{
+		{nil, 0, formatted},
+		{[]string{"-check"}, 1, "<stdin>\n"},
+		{[]string{"-diff"}, 0, "--- <stdin>"},
+	}
+
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		status := runFmt(test.args, strings.NewReader(unformatted), &stdout, &stderr)
+		if status != test.status || !strings.HasPrefix(stdout.String(), test.out) {
+			t.Errorf("%v: exit status %d and output %q, want %d and %q", test.args, status, stdout.String(), test.status, test.out)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package metainfo
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"unicode/utf8"
+)
+
+// Pos is a compact position in a FileSet, like go/token.Pos. The positions
+// of a file are its base plus the byte offsets in its source.
+type Pos int
+
+const NoPos Pos = 0
+
+func (p Pos) IsValid() bool {
+	return p != NoPos
+}
+
+// Position is a Pos expanded for humans. Line and the columns start from 1,
+// Column counts runes like Location, ByteColumn counts bytes and UTF16Column
+// counts UTF-16 code units, as editors speaking LSP do.
+type Position struct {
+	Filename    string
+	Offset      int
+	Line        int
+	Column      int
+	ByteColumn  int
+	UTF16Column int
+}
+
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	s := p.Filename
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
+}
+
+// File is a source file added to a FileSet, with a table of the offsets
+// where its lines start
+type File struct {
+	name  string
+	base  int
+	src   []byte
+	lines []int
+}
+
+func newFile(name string, base int, src []byte) *File {
+	f := &File{
+		name:  name,
+		base:  base,
+		src:   src,
+		lines: []int{0},
+	}
+	// line breaks are the same as in the tokenizer: '\n', '\r\n' and a lone '\r'
+	for i := 0; i < len(src); i++ {
+		switch src[i] {
+		case '\r':
+			if i+1 < len(src) && src[i+1] == '\n' {
+				i++
+			}
+			f.lines = append(f.lines, i+1)
+		case '\n':
+			f.lines = append(f.lines, i+1)
+		}
+	}
+	return f
+}
+
+func (f *File) Name() string {
+	return f.name
+}
+
+func (f *File) Base() int {
+	return f.base
+}
+
+func (f *File) Size() int {
+	return len(f.src)
+}
+
+func (f *File) Source() []byte {
+	return f.src
+}
+
+func (f *File) LineCount() int {
+	return len(f.lines)
+}
+
+// Pos is the position of a byte offset, offsets up to the size of the file
+// are allowed and the others are clamped to them, as offsets may come from
+// clients working on another version of the source
+func (f *File) Pos(offset int) Pos {
+	return Pos(f.base + max(0, min(offset, len(f.src))))
+}
+
+// Offset is the byte offset of a position, positions out of the file are
+// clamped to its start or its end
+func (f *File) Offset(p Pos) int {
+	return max(0, min(int(p)-f.base, len(f.src)))
+}
+
+// LineStart is the offset of the first byte of a line, lines out of the
+// file are clamped to the first or the last one
+func (f *File) LineStart(line int) int {
+	return f.lines[max(1, min(line, len(f.lines)))-1]
+}
+
+func (f *File) lineOf(offset int) int {
+	return sort.Search(len(f.lines), func(i int) bool {
+		return f.lines[i] > offset
+	})
+}
+
+func (f *File) Position(p Pos) Position {
+	if !p.IsValid() {
+		return Position{}
+	}
+
+	offset := f.Offset(p)
+	line := f.lineOf(offset)
+	text := f.src[f.lines[line-1]:offset]
+	utf16 := 0
+	for _, r := range string(text) {
+		if r >= 0x10000 {
+			utf16 += 2
+		} else {
+			utf16++
+		}
+	}
+	return Position{
+		Filename:    f.name,
+		Offset:      offset,
+		Line:        line,
+		Column:      utf8.RuneCount(text) + 1,
+		ByteColumn:  len(text) + 1,
+		UTF16Column: utf16 + 1,
+	}
+}
+
+// Location converts a position to the row and rune column the tokenizer
+// gives to tokens
+func (f *File) Location(p Pos) Location {
+	pos := f.Position(p)
+	return Location{
+		Column: pos.Column,
+		Row:    pos.Line,
+		Offset: pos.Offset,
+	}
+}
+
+func (f *File) contains(p Pos) bool {
+	return int(p) >= f.base && int(p) <= f.base+len(f.src)
+}
+
+// PosOf is the position of the rune at a location, which is the position
+// the location holds if it has one
+func (f *File) PosOf(loc Location) Pos {
+	if loc.Pos.IsValid() {
+		return loc.Pos
+	}
+	return f.Pos(loc.Offset)
+}
+
+// EndOf is the position right after the rune at a location, locations at
+// the end of ranges are inclusive while positions are not
+func (f *File) EndOf(loc Location) Pos {
+	if loc.Pos.IsValid() && f.contains(loc.Pos) {
+		loc.Offset = f.Offset(loc.Pos)
+	}
+	offset := max(0, min(loc.Offset, len(f.src)))
+	_, size := utf8.DecodeRune(f.src[offset:])
+	return f.Pos(offset + size)
+}
+
+// PosForUTF16 is the position of a line and a UTF-16 column, as sent by an
+// LSP client. Columns past the end of the line are clamped to it.
+func (f *File) PosForUTF16(line, column int) Pos {
+	line = max(1, min(line, len(f.lines)))
+	offset := f.lines[line-1]
+	for units := 1; units < column && offset < len(f.src); {
+		r, size := utf8.DecodeRune(f.src[offset:])
+		if r == '\r' || r == '\n' {
+			break
+		}
+		if r >= 0x10000 {
+			units += 2
+		} else {
+			units++
+		}
+		offset += size
+	}
+	return f.Pos(offset)
+}
+
+// FileSet holds the files of a program, like a script and the libraries it
+// imports, so that a single Pos identifies a place in any of them
+type FileSet struct {
+	mutex sync.RWMutex
+	base  int
+	files []*File
+}
+
+func NewFileSet() *FileSet {
+	return &FileSet{
+		base: 1,
+	}
+}
+
+func (s *FileSet) AddFile(name string, src []byte) *File {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	f := newFile(name, s.base, src)
+	// one more position for the end of the file
+	s.base += len(src) + 1
+	s.files = append(s.files, f)
+	return f
+}
+
+// File is the file containing a position, or nil
+func (s *FileSet) File(p Pos) *File {
+	if !p.IsValid() {
+		return nil
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	i := sort.Search(len(s.files), func(i int) bool {
+		return s.files[i].base > int(p)
+	}) - 1
+	if i < 0 || !s.files[i].contains(p) {
+		return nil
+	}
+	return s.files[i]
+}
+
+func (s *FileSet) Position(p Pos) Position {
+	if f := s.File(p); f != nil {
+		return f.Position(p)
+	}
+	return Position{}
+}
+
+type Severity byte
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+// Diagnostic is an error or a warning about a range of a file, End is the
+// position right after the range
+type Diagnostic struct {
+	Pos      Pos
+	End      Pos
+	Severity Severity
+	Msg      string
+}
+
+// Diagnostic attributes a message to a range given by locations
+func (f *File) Diagnostic(begin, end Location, severity Severity, msg string) Diagnostic {
+	d := Diagnostic{
+		Severity: severity,
+		Msg:      msg,
+	}
+	if begin.IsInvalid() {
+		return d
+	}
+	d.Pos = f.PosOf(begin)
+	d.End = max(d.Pos, f.EndOf(end))
+	return d
+}
+
+// Diagnostic attributes a message to a range given by locations which hold
+// their positions, in whichever file of the set they are
+func (s *FileSet) Diagnostic(begin, end Location, severity Severity, msg string) Diagnostic {
+	if f := s.File(begin.Pos); f != nil {
+		return f.Diagnostic(begin, end, severity, msg)
+	}
+	return Diagnostic{
+		Severity: severity,
+		Msg:      msg,
+	}
+}
+
+// Format prints a diagnostic as 'file:line:column: message'
+func (s *FileSet) Format(d Diagnostic) string {
+	msg := d.Msg
+	if d.Severity == SeverityWarning {
+		msg = "warning: " + msg
+	}
+	return fmt.Sprintf("%s: %s", s.Position(d.Pos), msg)
+}
@@ -0,0 +1,33 @@
+package metainfo
+
+import "testing"
+
+func TestOutOfRange(t *testing.T) {
+	fset := NewFileSet()
+	fset.AddFile("a.pine", []byte("x = 1\n"))
+	f := fset.AddFile("b.pine", []byte("a = 1\nb = 2\n"))
+
+	tests := []struct {
+		name string
+		got  int
+		want int
+	}{
+		{"Pos(-1)", int(f.Pos(-1)), int(f.Pos(0))},
+		{"Pos(100)", int(f.Pos(100)), int(f.Pos(f.Size()))},
+		{"Offset before the file", f.Offset(Pos(f.Base() - 1)), 0},
+		{"Offset after the file", f.Offset(Pos(f.Base() + 100)), f.Size()},
+		{"LineStart(0)", f.LineStart(0), 0},
+		{"LineStart(100)", f.LineStart(100), f.Size()},
+		{"EndOf(100)", int(f.EndOf(Location{Offset: 100})), int(f.Pos(f.Size()))},
+		{"PosForUTF16 past the end", int(f.PosForUTF16(100, 100)), int(f.Pos(f.Size()))},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, test.got, test.want)
+		}
+	}
+
+	if pos := f.Position(Pos(f.Base() + 100)); pos.Filename != "b.pine" || pos.Line != 3 || pos.Column != 1 {
+		t.Errorf("Position after the file: got %v", pos)
+	}
+}
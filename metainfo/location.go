@@ -1,8 +1,24 @@
 package metainfo
 
 type Location struct {
-	Column int
-	Row    int
+	Column int `json:"column"`
+	Row    int `json:"row"`
+	// byte offset of the rune in the source
+	Offset int `json:"offset"`
+	// position of the rune in the FileSet of the source, NoPos if the
+	// source is not a file of one
+	Pos Pos `json:"-"`
+}
+
+// Moved is the location shifted down by a number of rows and bytes, like a
+// location after an edited line
+func (loc Location) Moved(rows, bytes int) Location {
+	loc.Row += rows
+	loc.Offset += bytes
+	if loc.Pos.IsValid() {
+		loc.Pos += Pos(bytes)
+	}
+	return loc
 }
 
 func (loc Location) IsInvalid() bool {
@@ -0,0 +1,30 @@
+package metainfo
+
+import "strconv"
+
+// LanguageVersion is the version of Pine Script a script is written in, as
+// given by its '//@version' annotation. v5 and v6 share their grammar, so the
+// parser only checks the annotation, the rules which changed are checked by
+// types.CanDoImplicitConversionIn, the operators of builtins and the analyzer.
+type LanguageVersion int
+
+const (
+	V4 LanguageVersion = 4
+	V5 LanguageVersion = 5
+	V6 LanguageVersion = 6
+
+	// scripts without a '//@version' annotation are checked as v5 scripts
+	DefaultVersion = V5
+)
+
+func ParseLanguageVersion(s string) (LanguageVersion, bool) {
+	v, err := strconv.Atoi(s)
+	if err != nil || v <= 0 {
+		return 0, false
+	}
+	return LanguageVersion(v), true
+}
+
+func (v LanguageVersion) String() string {
+	return "v" + strconv.Itoa(int(v))
+}
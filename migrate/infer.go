@@ -0,0 +1,115 @@
+package migrate
+
+import "github.com/kvarenzn/pinecone/ast"
+
+// builtin variables of v4 scripts and their types
+var v4Variables = map[string]string{
+	"open":      "float",
+	"high":      "float",
+	"low":       "float",
+	"close":     "float",
+	"volume":    "float",
+	"hl2":       "float",
+	"hlc3":      "float",
+	"ohlc4":     "float",
+	"time":      "int",
+	"bar_index": "int",
+	"na":        "na",
+	"true":      "bool",
+	"false":     "bool",
+}
+
+// valueType guesses the type of a value from its literals and the builtin
+// variables in it. It returns "" if the type cannot be told without the
+// analyzer, and "na" for na.
+func valueType(n ast.Node) string {
+	switch v := n.(type) {
+	case *ast.ExprStmt:
+		return valueType(v.Expr)
+	case *ast.IntLiteral:
+		return "int"
+	case *ast.FloatLiteral:
+		return "float"
+	case *ast.StringLiteral:
+		return "string"
+	case *ast.BoolLiteral:
+		return "bool"
+	case *ast.ColorLiteral:
+		return "color"
+	case *ast.Identifier:
+		return v4Variables[v.Name]
+	case *ast.AttrExpr:
+		if ast.DottedName(v.Target) == "color" {
+			return "color"
+		}
+	case *ast.UnaryExpr:
+		switch t := valueType(v.Expr); {
+		case v.Op == "not":
+			return "bool"
+		case t == "int" || t == "float":
+			return t
+		}
+	case *ast.BinaryExpr:
+		switch v.Op {
+		case "==", "!=", "<", "<=", ">", ">=", "and", "or":
+			return "bool"
+		}
+		left, right := valueType(v.Left), valueType(v.Right)
+		if left == "string" && right == "string" && v.Op == "+" {
+			return "string"
+		}
+		if v.Op == "/" && isNumber(left) && isNumber(right) {
+			return "float"
+		}
+		if isNumber(left) && isNumber(right) {
+			return unite(left, right)
+		}
+	case *ast.TernaryExpr:
+		left, right := valueType(v.True), valueType(v.False)
+		if left == "" || right == "" {
+			return ""
+		}
+		return unite(left, right)
+	}
+	return ""
+}
+
+func isNumber(t string) bool {
+	return t == "int" || t == "float"
+}
+
+// unite gives the type of a variable holding values of two types, or ""
+// if there is none
+func unite(a, b string) string {
+	switch {
+	case a == b:
+		return a
+	case a == "na":
+		return b
+	case b == "na":
+		return a
+	case isNumber(a) && isNumber(b):
+		return "float"
+	}
+	return ""
+}
+
+// naType infers the type of a variable declared with na from the values it
+// is reassigned. Like in v4, it is float when it is never reassigned, or
+// only reassigned na.
+func (m *migrator) naType(name string) (string, bool) {
+	t := "na"
+	for _, value := range m.assigned[name] {
+		vt := valueType(value)
+		if vt == "" {
+			return "", false
+		}
+		if t = unite(t, vt); t == "" {
+			return "", false
+		}
+	}
+	if t == "na" {
+		return "float", true
+	}
+	return t, true
+}
@@ -0,0 +1,378 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kvarenzn/pinecone/ast"
+	"github.com/kvarenzn/pinecone/metainfo"
+	"github.com/kvarenzn/pinecone/parser"
+	"github.com/kvarenzn/pinecone/tokenizer"
+)
+
+// Edit replaces the source from Begin up to, but not including, End with
+// Text. Edits with Begin == End insert Text.
+type Edit struct {
+	Begin  metainfo.Location
+	End    metainfo.Location
+	Text   string
+	Reason string
+}
+
+// Note reports code which has to be migrated by hand
+type Note struct {
+	metainfo.Location
+	Msg string
+}
+
+type Result struct {
+	From  metainfo.LanguageVersion
+	To    metainfo.LanguageVersion
+	Edits []Edit
+	Notes []Note
+}
+
+var targetVersions = map[metainfo.LanguageVersion]bool{
+	metainfo.V5: true,
+	metainfo.V6: true,
+}
+
+type migrator struct {
+	src []rune
+	// rune offset of the beginning of each row
+	rows   []int
+	tokens []tokenizer.Token
+	// index of the token starting at each location
+	index map[metainfo.Location]int
+	// names of user defined functions, which are never renamed
+	funcs map[string]bool
+	// the values assigned to each variable with ':='
+	assigned map[string][]ast.Node
+	from     metainfo.LanguageVersion
+	to       metainfo.LanguageVersion
+	result   *Result
+}
+
+func newMigrator(src string, tokens []tokenizer.Token, from, to metainfo.LanguageVersion) *migrator {
+	m := &migrator{
+		src:      []rune(src),
+		rows:     []int{0},
+		tokens:   tokens,
+		index:    map[metainfo.Location]int{},
+		funcs:    map[string]bool{},
+		assigned: map[string][]ast.Node{},
+		from:     from,
+		to:       to,
+		result: &Result{
+			From:  from,
+			To:    to,
+			Edits: []Edit{},
+			Notes: []Note{},
+		},
+	}
+
+	// rows are counted like the tokenizer does
+	for i, r := range m.src {
+		if r == '\n' || r == '\r' && (i+1 == len(m.src) || m.src[i+1] != '\n') {
+			m.rows = append(m.rows, i+1)
+		}
+	}
+
+	for i, token := range tokens {
+		if !token.IsMeta() {
+			m.index[token.Begin] = i
+		}
+	}
+
+	return m
+}
+
+func (m *migrator) offset(loc metainfo.Location) int {
+	if loc.Row < 1 {
+		return 0
+	}
+	if loc.Row > len(m.rows) {
+		return len(m.src)
+	}
+	return min(m.rows[loc.Row-1]+loc.Column-1, len(m.src))
+}
+
+// after is the location right after the last character of a node
+func after(n ast.Node) metainfo.Location {
+	return metainfo.Location{
+		Row:    n.End().Row,
+		Column: n.End().Column + 1,
+	}
+}
+
+func (m *migrator) steps(v metainfo.LanguageVersion) bool {
+	return m.from < v && m.to >= v
+}
+
+func (m *migrator) edit(begin, end metainfo.Location, text, reason string) {
+	m.result.Edits = append(m.result.Edits, Edit{
+		Begin:  begin,
+		End:    end,
+		Text:   text,
+		Reason: reason,
+	})
+}
+
+func (m *migrator) note(loc metainfo.Location, format string, args ...any) {
+	m.result.Notes = append(m.result.Notes, Note{
+		Location: loc,
+		Msg:      fmt.Sprintf(format, args...),
+	})
+}
+
+// take returns the source between begin and end with the edits found there
+// applied, and drops those edits, so the text can be moved elsewhere
+func (m *migrator) take(begin, end metainfo.Location) string {
+	b, e := m.offset(begin), m.offset(end)
+	inside := []Edit{}
+	rest := []Edit{}
+	for _, edit := range m.result.Edits {
+		if m.offset(edit.Begin) >= b && m.offset(edit.End) <= e {
+			inside = append(inside, edit)
+		} else {
+			rest = append(rest, edit)
+		}
+	}
+	m.result.Edits = rest
+
+	text, _ := m.apply(b, e, inside)
+	return text
+}
+
+func (m *migrator) apply(b, e int, edits []Edit) (string, error) {
+	sort.SliceStable(edits, func(i, j int) bool {
+		return m.offset(edits[i].Begin) < m.offset(edits[j].Begin)
+	})
+
+	var sb strings.Builder
+	cur := b
+	for _, edit := range edits {
+		begin, end := m.offset(edit.Begin), m.offset(edit.End)
+		if begin < cur {
+			return "", fmt.Errorf("%d:%d: overlapping edits", edit.Begin.Row, edit.Begin.Column)
+		}
+		sb.WriteString(string(m.src[cur:begin]))
+		sb.WriteString(edit.Text)
+		cur = end
+	}
+	sb.WriteString(string(m.src[cur:e]))
+	return sb.String(), nil
+}
+
+// startsLine reports whether a node is the first thing on its line
+func (m *migrator) startsLine(n ast.Node) bool {
+	i, ok := m.index[n.Begin()]
+	return ok && (i == 0 || m.tokens[i-1].IsMeta())
+}
+
+func (m *migrator) indentOf(n ast.Node) string {
+	b := m.offset(n.Begin())
+	return string(m.src[m.rows[n.Begin().Row-1]:b])
+}
+
+// removeArg removes an argument of a call together with the comma next to it
+func (m *migrator) removeArg(call *ast.CallExpr, i int, reason string) {
+	switch {
+	case i > 0:
+		m.take(after(call.Args[i-1]), after(call.Args[i]))
+		m.edit(after(call.Args[i-1]), after(call.Args[i]), "", reason)
+	case len(call.Args) > 1:
+		m.take(call.Args[0].Begin(), call.Args[1].Begin())
+		m.edit(call.Args[0].Begin(), call.Args[1].Begin(), "", reason)
+	default:
+		m.take(call.Args[0].Begin(), after(call.Args[0]))
+		m.edit(call.Args[0].Begin(), after(call.Args[0]), "", reason)
+	}
+}
+
+func findKwArg(call *ast.CallExpr, name string) (int, *ast.KwArg) {
+	for i, arg := range call.Args {
+		if kw, ok := arg.(*ast.KwArg); ok && kw.Name == name {
+			return i, kw
+		}
+	}
+	return -1, nil
+}
+
+func (m *migrator) callExpr(call *ast.CallExpr, parent ast.Node) {
+	name := ast.DottedName(call.Func)
+	_, isIdent := call.Func.(*ast.Identifier)
+	if isIdent && m.funcs[name] {
+		return
+	}
+
+	if m.steps(metainfo.V5) {
+		if i, kw := findKwArg(call, "transp"); kw != nil {
+			m.note(call.Args[i].Begin(), `"transp" was removed in v5, set the transparency with color.new instead`)
+		}
+
+		switch {
+		case isIdent && name == "input":
+			i, kw := findKwArg(call, "type")
+			if kw == nil {
+				break
+			}
+			input, ok := v5Inputs[ast.DottedName(kw.Value)]
+			if !ok {
+				m.note(kw.Begin(), `cannot migrate input of type "%s"`, ast.DottedName(kw.Value))
+				break
+			}
+			m.edit(call.Func.Begin(), after(call.Func), input, fmt.Sprintf(`"input(type = %s)" is "%s" since v5`, ast.DottedName(kw.Value), input))
+			m.removeArg(call, i, fmt.Sprintf(`"input(type = %s)" is "%s" since v5`, ast.DottedName(kw.Value), input))
+		case isIdent && name == "iff":
+			if len(call.Args) != 3 {
+				m.note(call.Begin(), `"iff" was removed in v5, use the ternary operator instead`)
+				break
+			}
+			for _, arg := range call.Args {
+				if _, ok := arg.(*ast.KwArg); ok {
+					m.note(call.Begin(), `"iff" was removed in v5, use the ternary operator instead`)
+					return
+				}
+			}
+			reason := `"iff" was removed in v5`
+			m.edit(call.Func.Begin(), call.Args[0].Begin(), "(", reason)
+			m.edit(after(call.Args[0]), call.Args[1].Begin(), " ? ", reason)
+			m.edit(after(call.Args[1]), call.Args[2].Begin(), " : ", reason)
+		case isIdent && v5Renames[name] != "":
+			m.edit(call.Func.Begin(), after(call.Func), v5Renames[name], fmt.Sprintf(`"%s" is "%s" since v5`, name, v5Renames[name]))
+		}
+	}
+
+	if m.steps(metainfo.V6) && v6WhenRemoved[name] {
+		i, kw := findKwArg(call, "when")
+		if kw == nil {
+			return
+		}
+		stmt, ok := parent.(*ast.ExprStmt)
+		if !ok || !m.startsLine(stmt) || stmt.Begin().Row != stmt.End().Row {
+			m.note(kw.Begin(), `"when" was removed in v6, call "%s" in an if statement instead`, name)
+			return
+		}
+		reason := fmt.Sprintf(`the "when" parameter of "%s" was removed in v6`, name)
+		cond := m.take(kw.Value.Begin(), after(kw.Value))
+		m.removeArg(call, i, reason)
+		m.edit(stmt.Begin(), stmt.Begin(), "if "+cond+"\n"+m.indentOf(stmt)+"    ", reason)
+	}
+}
+
+func (m *migrator) varDeclStmt(decl *ast.VarDeclStmt) {
+	if !m.steps(metainfo.V5) || decl.Type != nil {
+		return
+	}
+
+	init := decl.Initial
+	if stmt, ok := init.(*ast.ExprStmt); ok {
+		init = stmt.Expr
+	}
+	if id, ok := init.(*ast.Identifier); !ok || id.Name != "na" {
+		return
+	}
+
+	// the type goes right before the name, after 'var' and the qualifier
+	i, ok := m.index[decl.Begin()]
+	if !ok {
+		return
+	}
+	for ; i < len(m.tokens); i++ {
+		if m.tokens[i].Type == tokenizer.IDENTIFIER && m.tokens[i].Lexeme == decl.Name {
+			break
+		}
+	}
+	if i == len(m.tokens) {
+		return
+	}
+
+	t, ok := m.naType(decl.Name)
+	if !ok {
+		m.note(decl.Begin(), `the type of "%s" cannot be inferred from na since v5, declare it by hand`, decl.Name)
+		return
+	}
+	m.edit(m.tokens[i].Begin, m.tokens[i].Begin, t+" ", fmt.Sprintf(`the type of "%s" cannot be inferred from na since v5`, decl.Name))
+}
+
+func (m *migrator) version() {
+	text := fmt.Sprintf("//@version=%d", m.to)
+	for _, token := range m.tokens {
+		for _, a := range token.Annotations {
+			if strings.HasPrefix(a.Lexeme, "//@version") {
+				m.edit(a.Begin, metainfo.Location{Row: a.End.Row, Column: a.End.Column + 1}, text, "")
+				return
+			}
+		}
+	}
+	m.edit(metainfo.Location{Row: 1, Column: 1}, metainfo.Location{Row: 1, Column: 1}, text+"\n", "")
+}
+
+// Migrate computes the edits which upgrade a script to the given version
+func Migrate(src []byte, to metainfo.LanguageVersion) (*Result, error) {
+	if !targetVersions[to] {
+		return nil, fmt.Errorf("cannot migrate to %s", to)
+	}
+
+	tokens, tokenErrs := tokenizer.TokenizeLossless(string(src))
+	if len(tokenErrs) > 0 {
+		return nil, tokenErrs[0]
+	}
+	// scripts without a version are v1 scripts
+	from, ok := tokenizer.DetectVersion(tokens)
+	if !ok {
+		return nil, fmt.Errorf(`missing or invalid "//@version" annotation, the version of the script is unknown`)
+	}
+	if from < metainfo.V4 {
+		return nil, fmt.Errorf("cannot migrate from %s, only scripts of v4 and later can be migrated", from)
+	}
+	stmts, errs := parser.Parse(tokens)
+	for _, e := range errs {
+		// older versions are not supported by the parser, which is why we
+		// are migrating them
+		if !e.Warning && !errors.Is(e, parser.ErrUnsupportedVersion) {
+			return nil, e
+		}
+	}
+
+	m := newMigrator(string(src), tokens, from, to)
+	if from >= to {
+		return m.result, nil
+	}
+
+	for _, stmt := range stmts {
+		if f, ok := stmt.(*ast.FuncDeclStmt); ok {
+			m.funcs[f.Name] = true
+		}
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			if r, ok := n.(*ast.ReassignStmt); ok && r.Op == ":=" {
+				if id, ok := r.Target.(*ast.Identifier); ok {
+					m.assigned[id.Name] = append(m.assigned[id.Name], r.Value)
+				}
+			}
+			return true
+		})
+	}
+	for _, stmt := range stmts {
+		m.walk(stmt, nil)
+	}
+	m.version()
+
+	sort.SliceStable(m.result.Edits, func(i, j int) bool {
+		return m.offset(m.result.Edits[i].Begin) < m.offset(m.result.Edits[j].Begin)
+	})
+	return m.result, nil
+}
+
+// Apply applies edits made by Migrate to the source they were made for
+func Apply(src []byte, edits []Edit) ([]byte, error) {
+	m := newMigrator(string(src), nil, 0, 0)
+	text, err := m.apply(0, len(m.src), append([]Edit{}, edits...))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(text), nil
+}
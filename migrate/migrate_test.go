@@ -0,0 +1,195 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kvarenzn/pinecone/metainfo"
+)
+
+func migrate(t *testing.T, src string) (string, *Result) {
+	t.Helper()
+	return migrateTo(t, src, metainfo.V5)
+}
+
+func migrateTo(t *testing.T, src string, target metainfo.LanguageVersion) (string, *Result) {
+	t.Helper()
+	result, err := Migrate([]byte(src), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := Apply([]byte(src), result.Edits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(res), result
+}
+
+func TestNaDeclarations(t *testing.T) {
+	got, result := migrate(t, `//@version=4
+study("x")
+var a = na
+a := "text"
+var b = na
+b := close * 2
+var c = na
+var d = na
+d := 1
+d := 1.5
+var e = na
+e := f()
+var g = na
+g := close > open ? 1 : na
+var h = na
+h := close > open ? 1 : "one"
+`)
+
+	want := `//@version=5
+indicator("x")
+var string a = na
+a := "text"
+var float b = na
+b := close * 2
+var float c = na
+var float d = na
+d := 1
+d := 1.5
+var e = na
+e := f()
+var int g = na
+g := close > open ? 1 : na
+var h = na
+h := close > open ? 1 : "one"
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if len(result.Notes) != 2 || result.Notes[0].Row != 11 || !strings.Contains(result.Notes[0].Msg, `"e"`) ||
+		result.Notes[1].Row != 15 || !strings.Contains(result.Notes[1].Msg, `"h"`) {
+		t.Errorf("unexpected notes %+v", result.Notes)
+	}
+}
+
+func TestUnsupportedVersions(t *testing.T) {
+	for _, src := range []string{
+		"study(\"x\")\n",
+		"//@version=3\nstudy(\"x\")\n",
+	} {
+		if _, err := Migrate([]byte(src), metainfo.V5); err == nil {
+			t.Errorf("%q: was migrated from an unknown or unsupported version", src)
+		}
+	}
+}
+
+func TestV5Renames(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`x = sma(close, 14)`, `x = ta.sma(close, 14)`},
+		{`x = security(tickerid, "D", close)`, `x = request.security(tickerid, "D", close)`},
+		{`x = tostring(abs(-1))`, `x = str.tostring(math.abs(-1))`},
+		// not a call, and a function of the script shadowing a builtin
+		{`sma = 1`, `sma = 1`},
+		{"max(a, b) => a > b ? a : b\nx = max(1, 2)", "max(a, b) => a > b ? a : b\nx = max(1, 2)"},
+	}
+
+	for _, test := range tests {
+		got, _ := migrate(t, "//@version=4\nstudy(\"x\")\n"+test.src+"\n")
+		if want := "//@version=5\nindicator(\"x\")\n" + test.want + "\n"; got != want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", test.src, got, want)
+		}
+	}
+}
+
+func TestV5Inputs(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+		note string
+	}{
+		{`n = input(14, type = input.integer)`, `n = input.int(14)`, ""},
+		{`n = input(14, "Length", input.integer)`, `n = input(14, "Length", input.integer)`, ""},
+		{`tf = input("D", type = input.resolution, title = "TF")`, `tf = input.timeframe("D", title = "TF")`, ""},
+		{`n = input(14)`, `n = input(14)`, ""},
+		{`n = input(14, type = custom)`, `n = input(14, type = custom)`, `cannot migrate input of type "custom"`},
+	}
+
+	for _, test := range tests {
+		got, result := migrate(t, "//@version=4\nstudy(\"x\")\n"+test.src+"\n")
+		if want := "//@version=5\nindicator(\"x\")\n" + test.want + "\n"; got != want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", test.src, got, want)
+		}
+		if test.note == "" && len(result.Notes) > 0 || test.note != "" && (len(result.Notes) != 1 || result.Notes[0].Msg != test.note) {
+			t.Errorf("%s: notes %+v, want %q", test.src, result.Notes, test.note)
+		}
+	}
+}
+
+func TestIff(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+		note bool
+	}{
+		{`x = iff(close > open, 1, 0)`, `x = (close > open ? 1 : 0)`, false},
+		{`x = iff(a, iff(b, 1, 2), 3)`, `x = (a ? (b ? 1 : 2) : 3)`, false},
+		{`x = iff(a, 1)`, `x = iff(a, 1)`, true},
+		{`x = iff(cond = a, then = 1, _else = 0)`, `x = iff(cond = a, then = 1, _else = 0)`, true},
+	}
+
+	for _, test := range tests {
+		got, result := migrate(t, "//@version=4\nstudy(\"x\")\n"+test.src+"\n")
+		if want := "//@version=5\nindicator(\"x\")\n" + test.want + "\n"; got != want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", test.src, got, want)
+		}
+		if noted := len(result.Notes) > 0; noted != test.note {
+			t.Errorf("%s: notes %+v", test.src, result.Notes)
+		}
+	}
+}
+
+func TestV6WhenRemoved(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+		note bool
+	}{
+		{
+			`strategy.entry("L", strategy.long, when = close > open)`,
+			"if close > open\n    strategy.entry(\"L\", strategy.long)",
+			false,
+		},
+		{
+			"if time > 0\n    strategy.close(\"L\", when = close < open)",
+			"if time > 0\n    if close < open\n        strategy.close(\"L\")",
+			false,
+		},
+		{
+			`strategy.close_all(when = done)`,
+			"if done\n    strategy.close_all()",
+			false,
+		},
+		{
+			`strategy.entry("L", strategy.long)`,
+			`strategy.entry("L", strategy.long)`,
+			false,
+		},
+		// only a whole statement can be moved into an if statement
+		{
+			`x = strategy.entry("L", strategy.long, when = ok)`,
+			`x = strategy.entry("L", strategy.long, when = ok)`,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		got, result := migrateTo(t, "//@version=5\nstrategy(\"x\")\n"+test.src+"\n", metainfo.V6)
+		if want := "//@version=6\nstrategy(\"x\")\n" + test.want + "\n"; got != want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", test.src, got, want)
+		}
+		if noted := len(result.Notes) > 0; noted != test.note {
+			t.Errorf("%s: notes %+v", test.src, result.Notes)
+		}
+	}
+}
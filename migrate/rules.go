@@ -0,0 +1,122 @@
+package migrate
+
+// functions moved into namespaces by v5
+var v5Renames = map[string]string{
+	"study": "indicator",
+
+	"security":  "request.security",
+	"financial": "request.financial",
+	"quandl":    "request.quandl",
+	"splits":    "request.splits",
+	"dividends": "request.dividends",
+	"earnings":  "request.earnings",
+
+	"tostring": "str.tostring",
+	"tonumber": "str.tonumber",
+
+	"abs":              "math.abs",
+	"acos":             "math.acos",
+	"asin":             "math.asin",
+	"atan":             "math.atan",
+	"avg":              "math.avg",
+	"ceil":             "math.ceil",
+	"cos":              "math.cos",
+	"exp":              "math.exp",
+	"floor":            "math.floor",
+	"log":              "math.log",
+	"log10":            "math.log10",
+	"max":              "math.max",
+	"min":              "math.min",
+	"pow":              "math.pow",
+	"random":           "math.random",
+	"round":            "math.round",
+	"round_to_mintick": "math.round_to_mintick",
+	"sign":             "math.sign",
+	"sin":              "math.sin",
+	"sqrt":             "math.sqrt",
+	"sum":              "math.sum",
+	"tan":              "math.tan",
+	"todegrees":        "math.todegrees",
+	"toradians":        "math.toradians",
+
+	"alma":                            "ta.alma",
+	"atr":                             "ta.atr",
+	"barssince":                       "ta.barssince",
+	"bb":                              "ta.bb",
+	"bbw":                             "ta.bbw",
+	"cci":                             "ta.cci",
+	"change":                          "ta.change",
+	"cmo":                             "ta.cmo",
+	"cog":                             "ta.cog",
+	"correlation":                     "ta.correlation",
+	"cross":                           "ta.cross",
+	"crossover":                       "ta.crossover",
+	"crossunder":                      "ta.crossunder",
+	"cum":                             "ta.cum",
+	"dev":                             "ta.dev",
+	"dmi":                             "ta.dmi",
+	"ema":                             "ta.ema",
+	"falling":                         "ta.falling",
+	"highest":                         "ta.highest",
+	"highestbars":                     "ta.highestbars",
+	"hma":                             "ta.hma",
+	"kc":                              "ta.kc",
+	"kcw":                             "ta.kcw",
+	"linreg":                          "ta.linreg",
+	"lowest":                          "ta.lowest",
+	"lowestbars":                      "ta.lowestbars",
+	"macd":                            "ta.macd",
+	"median":                          "ta.median",
+	"mfi":                             "ta.mfi",
+	"mode":                            "ta.mode",
+	"mom":                             "ta.mom",
+	"percentile_linear_interpolation": "ta.percentile_linear_interpolation",
+	"percentile_nearest_rank":         "ta.percentile_nearest_rank",
+	"percentrank":                     "ta.percentrank",
+	"pivothigh":                       "ta.pivothigh",
+	"pivotlow":                        "ta.pivotlow",
+	"range":                           "ta.range",
+	"rising":                          "ta.rising",
+	"rma":                             "ta.rma",
+	"roc":                             "ta.roc",
+	"rsi":                             "ta.rsi",
+	"sar":                             "ta.sar",
+	"sma":                             "ta.sma",
+	"stdev":                           "ta.stdev",
+	"stoch":                           "ta.stoch",
+	"supertrend":                      "ta.supertrend",
+	"swma":                            "ta.swma",
+	"tsi":                             "ta.tsi",
+	"valuewhen":                       "ta.valuewhen",
+	"variance":                        "ta.variance",
+	"vwap":                            "ta.vwap",
+	"vwma":                            "ta.vwma",
+	"wma":                             "ta.wma",
+	"wpr":                             "ta.wpr",
+}
+
+// the 'type' argument of the v4 'input' function, and the v5 function
+// replacing each of them
+var v5Inputs = map[string]string{
+	"input.bool":       "input.bool",
+	"input.integer":    "input.int",
+	"input.float":      "input.float",
+	"input.string":     "input.string",
+	"input.symbol":     "input.symbol",
+	"input.resolution": "input.timeframe",
+	"input.session":    "input.session",
+	"input.source":     "input.source",
+	"input.time":       "input.time",
+	"input.color":      "input.color",
+}
+
+// strategy functions which lost their 'when' parameter in v6
+var v6WhenRemoved = map[string]bool{
+	"strategy.entry":      true,
+	"strategy.order":      true,
+	"strategy.exit":       true,
+	"strategy.close":      true,
+	"strategy.close_all":  true,
+	"strategy.cancel":     true,
+	"strategy.cancel_all": true,
+}
@@ -0,0 +1,18 @@
+package migrate
+
+import "github.com/kvarenzn/pinecone/ast"
+
+// walk visits the children of a node before the node itself, so that the
+// edits inside an expression exist when the expression is moved
+func (m *migrator) walk(n, parent ast.Node) {
+	for _, c := range ast.Children(n) {
+		m.walk(c.Node, n)
+	}
+
+	switch v := n.(type) {
+	case *ast.CallExpr:
+		m.callExpr(v, parent)
+	case *ast.VarDeclStmt:
+		m.varDeclStmt(v)
+	}
+}
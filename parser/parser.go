@@ -1,11 +1,13 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 
 	"github.com/kvarenzn/pinecone/ast"
+	"github.com/kvarenzn/pinecone/metainfo"
 	"github.com/kvarenzn/pinecone/tokenizer"
 )
 
@@ -32,26 +34,93 @@ func parseNumber(token tokenizer.Token) ast.Node {
 	}, token.Begin, token.End)
 }
 
-func parseString(token tokenizer.Token) ast.Node {
+// unquote decodes a string literal. The escapes are '\n', '\t', '\r',
+// '\uXXXX' and a backslash followed by a quote or a backslash, a backslash
+// before any other character is ignored. A line break in a wrapped string is
+// removed together with the indentation of the next line.
+//
+// A string the tokenizer found unterminated is decoded up to its end, and
+// errUnterminated is returned with it.
+func unquote(lexeme string) (string, error) {
+	runes := []rune(lexeme)
+	if len(runes) == 0 || runes[0] != '"' && runes[0] != '\'' {
+		return "", fmt.Errorf("missing quotes")
+	}
+	// a closing quote after an odd number of backslashes is escaped
+	backslashes := 0
+	for i := len(runes) - 2; i > 0 && runes[i] == '\\'; i-- {
+		backslashes++
+	}
+	var unterminated error
+	body := runes[1:]
+	if len(runes) < 2 || runes[0] != runes[len(runes)-1] || backslashes%2 == 1 {
+		unterminated = errUnterminated
+	} else {
+		body = runes[1 : len(runes)-1]
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(body); i++ {
+		r := body[i]
+		switch r {
+		case '\\':
+			i++
+			if i == len(body) {
+				return "", fmt.Errorf("unfinished escape sequence")
+			}
+			switch body[i] {
+			case 'n':
+				sb.WriteRune('\n')
+			case 't':
+				sb.WriteRune('\t')
+			case 'r':
+				sb.WriteRune('\r')
+			case 'u':
+				if i+5 > len(body) {
+					return "", fmt.Errorf("invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(string(body[i+1:i+5]), 16, 32)
+				if err != nil {
+					return "", fmt.Errorf("invalid unicode escape \\u%s", string(body[i+1:i+5]))
+				}
+				sb.WriteRune(rune(code))
+				i += 4
+			case '\n', '\r':
+				// a backslash at the end of a wrapped line
+				i--
+			default:
+				sb.WriteRune(body[i])
+			}
+		case '\n', '\r':
+			for i+1 < len(body) && (body[i+1] == '\n' || body[i+1] == ' ' || body[i+1] == '\t') {
+				i++
+			}
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String(), unterminated
+}
+
+// errUnterminated is already reported by the tokenizer
+var errUnterminated = errors.New("unterminated string")
+
+// parseString returns the literal of an unterminated string together with
+// errUnterminated
+func parseString(token tokenizer.Token) (ast.Node, error) {
 	if token.Type != tokenizer.STRING {
-		return nil
+		return nil, fmt.Errorf("not a string")
 	}
-	lexeme := token.Lexeme
-	lexeme = strings.ReplaceAll(lexeme, "\n", "")
-	if lexeme[0] == '\'' {
-		lexeme = lexeme[1 : len(lexeme)-1]
-		lexeme = strings.ReplaceAll(lexeme, "\\'", "'")
-		lexeme = strings.ReplaceAll(lexeme, "\"", "\\\"")
-		lexeme = `"` + lexeme + `"`
-	}
-	str, err := strconv.Unquote(lexeme)
-	if err != nil {
-		return nil
+
+	str, err := unquote(token.Lexeme)
+	if err != nil && err != errUnterminated {
+		return nil, err
 	}
 
 	return ast.WithRange(&ast.StringLiteral{
 		Value: str,
-	}, token.Begin, token.End)
+		Raw:   token.Lexeme,
+	}, token.Begin, token.End), err
 }
 
 func parseColor(token tokenizer.Token) ast.Node {
@@ -86,9 +155,9 @@ func parseColor(token tokenizer.Token) ast.Node {
 		blue = float64((num >> 0) & 0xff)
 		transparent = 0
 	case 9: // #RRGGBBAA
-		red = float64((num>>24)&0xff) / 0xff
-		green = float64((num>>16)&0xff) / 0xff
-		blue = float64((num>>8)&0xff) / 0xff
+		red = float64((num >> 24) & 0xff)
+		green = float64((num >> 16) & 0xff)
+		blue = float64((num >> 8) & 0xff)
 		transparent = 100 - float64((num>>0)&0xff)/0xff*100
 	default:
 		return nil
@@ -112,13 +181,120 @@ func pickLexeme(token *tokenizer.Token) *string {
 type ParseError struct {
 	Row int
 	Col int
+	// byte offset of the error in the source
+	Offset int
+	// position of the error, see metainfo.Location
+	Pos metainfo.Pos
 	Msg string
+	// warnings do not prevent the script from being compiled
+	Warning bool
+	// ErrUnsupportedVersion or ErrMissingVersion for the errors about the
+	// version of the script, nil for the others
+	Err error
+}
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported version")
+	ErrMissingVersion     = errors.New("missing version")
+)
+
+func (e ParseError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Row, e.Col, e.Msg)
+}
+
+func (e ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Diagnostic attributes the error to the file the script was parsed from
+func (e ParseError) Diagnostic(file *metainfo.File) metainfo.Diagnostic {
+	severity := metainfo.SeverityError
+	if e.Warning {
+		severity = metainfo.SeverityWarning
+	}
+	if e.Offset < 0 {
+		return metainfo.Diagnostic{
+			Severity: severity,
+			Msg:      e.Msg,
+		}
+	}
+	pos := e.Pos
+	if !pos.IsValid() {
+		pos = file.Pos(e.Offset)
+	}
+	return metainfo.Diagnostic{
+		Pos:      pos,
+		End:      pos,
+		Severity: severity,
+		Msg:      e.Msg,
+	}
+}
+
+// TokenizerErrors converts the errors of the tokenizer, so that they can be
+// reported together with the errors of the parser
+func TokenizerErrors(errs []tokenizer.Error) []ParseError {
+	result := []ParseError{}
+	for _, e := range errs {
+		result = append(result, ParseError{
+			Row:    e.Row,
+			Col:    e.Col,
+			Offset: e.Offset,
+			Pos:    e.Pos,
+			Msg:    e.Msg,
+		})
+	}
+	return result
+}
+
+// parseAnnotation splits an annotation comment like '//@param x the value'
+// into its name ("param") and value ("x the value"). For '//@version=5' the
+// value is "5".
+func parseAnnotation(token tokenizer.Token) *ast.Annotation {
+	text := strings.TrimPrefix(token.Lexeme, "//@")
+	end := strings.IndexAny(text, " \t=")
+	name, value := text, ""
+	if end >= 0 {
+		name = text[:end]
+		value = strings.TrimSpace(text[end+1:])
+	}
+
+	return ast.WithRange(&ast.Annotation{
+		Name:  name,
+		Value: strings.TrimSpace(value),
+	}, token.Begin, token.End).(*ast.Annotation)
+}
+
+func parseAnnotations(token *tokenizer.Token) []*ast.Annotation {
+	if token == nil || len(token.Annotations) == 0 {
+		return nil
+	}
+
+	annotations := []*ast.Annotation{}
+	for _, a := range token.Annotations {
+		annotations = append(annotations, parseAnnotation(a))
+	}
+	return annotations
+}
+
+var supportedVersions = map[metainfo.LanguageVersion]bool{
+	metainfo.V5: true,
+	metainfo.V6: true,
+}
+
+// fileAnnotations are the annotations of the whole script, they are kept on
+// the root instead of the statement after them
+var fileAnnotations = map[string]bool{
+	"version":                true,
+	"description":            true,
+	"strategy_alert_message": true,
 }
 
 type parser struct {
 	tokens  []tokenizer.Token
 	current int
 	errors  []ParseError
+	// the annotations of the root, see ParseFile
+	annotations []*ast.Annotation
 }
 
 func (p parser) eof() bool {
@@ -175,7 +351,6 @@ func (p *parser) consume(tts ...tokenizer.TokenType) *tokenizer.Token {
 		}
 	}
 	p.current++
-	fmt.Println(token)
 	return token
 }
 
@@ -185,21 +360,6 @@ func (p *parser) seekToType(tt tokenizer.TokenType) {
 	}
 }
 
-func (p *parser) hasTokenBeforeNewLine(tt tokenizer.TokenType) bool {
-	offset := 1
-	for {
-		t := p.peekType(offset)
-		if t.In(tokenizer.UNKNOWN, tokenizer.NEWLINE, tokenizer.INDENT, tokenizer.DEDENT) {
-			break
-		}
-		if t == tt {
-			return true
-		}
-		offset++
-	}
-	return false
-}
-
 func (p *parser) next() {
 	p.current++
 }
@@ -213,15 +373,18 @@ func (p *parser) error(format string, args ...any) {
 	msg := fmt.Sprintf(format, args...)
 	if token == nil {
 		p.errors = append(p.errors, ParseError{
-			Row: -1,
-			Col: -1,
-			Msg: msg,
+			Row:    -1,
+			Col:    -1,
+			Offset: -1,
+			Msg:    msg,
 		})
 	} else {
 		p.errors = append(p.errors, ParseError{
-			Row: token.Begin.Row,
-			Col: token.Begin.Column,
-			Msg: msg,
+			Row:    token.Begin.Row,
+			Col:    token.Begin.Column,
+			Offset: token.Begin.Offset,
+			Pos:    token.Begin.Pos,
+			Msg:    msg,
 		})
 	}
 }
@@ -243,9 +406,9 @@ func (p *parser) parseType(silent bool) ast.Node {
 		}
 		return nil
 	}
-	var t ast.Node = &ast.SimpleType{
+	var t ast.Node = ast.WithRange(&ast.SimpleType{
 		Name: name.Lexeme,
-	}
+	}, name.Begin, name.End)
 
 	for {
 		token := p.consume(tokenizer.LEFT_ANG_BRACKET, tokenizer.DOT, tokenizer.LEFT_SQ_BRACKET)
@@ -377,6 +540,7 @@ func (p *parser) parseParenExpr(silent bool) ast.Node {
 		if !silent {
 			p.error(`Expect ")" to match "(", but got %s`, p.peekLexeme())
 		}
+		return ast.WithRange(expr, lparen.Begin, expr.End())
 	}
 
 	return ast.WithRange(expr, lparen.Begin, rparen.End)
@@ -388,6 +552,7 @@ func (p *parser) parseAtom(silent bool) ast.Node {
 		if !silent {
 			p.error(`Unexpected EOF, file might be truncated`)
 		}
+		return nil
 	}
 
 	switch token.Type {
@@ -400,9 +565,9 @@ func (p *parser) parseAtom(silent bool) ast.Node {
 		p.consume(tokenizer.NUMBER)
 		return num
 	case tokenizer.STRING:
-		str := parseString(*token)
-		if str == nil {
-			p.error(`Invalid string literal %s`, p.peekLexeme())
+		str, err := parseString(*token)
+		if err != nil && err != errUnterminated {
+			p.error(`Invalid string literal %s: %s`, p.peekLexeme(), err)
 			return nil
 		}
 		p.consume(tokenizer.STRING)
@@ -414,7 +579,7 @@ func (p *parser) parseAtom(silent bool) ast.Node {
 			return nil
 		}
 		p.consume(tokenizer.COLOR)
-		return nil
+		return color
 	case tokenizer.TRUE:
 		p.consume(tokenizer.TRUE)
 		return ast.WithRange(&ast.BoolLiteral{
@@ -515,12 +680,17 @@ func (p *parser) parseAtomExpr(silent bool) ast.Node {
 			if offset == nil {
 				return nil
 			}
-			if p.consume(tokenizer.RIGHT_SQ_BRACKET) == nil {
+			rsq := p.consume(tokenizer.RIGHT_SQ_BRACKET)
+			if rsq == nil {
 				if !silent {
 					p.error(`Expect "]" to match "[", but got %s`, p.peekLexeme())
 				}
 				return nil
 			}
+			atom = ast.WithRange(&ast.HRefExpr{
+				Series: atom,
+				Offset: offset,
+			}, atom.Begin(), rsq.End)
 		} else if p.consume(tokenizer.LEFT_ANG_BRACKET) != nil {
 			begin := p.tell()
 			typeArgs := p.parseTypeArgList(true)
@@ -753,7 +923,7 @@ func (p *parser) parseIfStmt() ast.Node {
 		True: suite,
 	}
 
-	ifStmt.SetBegin(ifToken.Begin)
+	ifStmt.SetRange(ifToken.Begin, suite.End())
 
 	if p.consume(tokenizer.ELSE) != nil {
 		f := p.parseSuite()
@@ -1108,7 +1278,7 @@ func (p *parser) parseParamDecl() ast.Node {
 	if p.peekType(0).In(tokenizer.EQUAL, tokenizer.COMMA, tokenizer.RIGHT_PAREN) {
 		var def ast.Node = nil
 		if p.consume(tokenizer.EQUAL) != nil {
-			def = p.parseAtom(false)
+			def = p.parseTestExpr(false)
 			if def == nil {
 				return nil
 			}
@@ -1144,7 +1314,7 @@ func (p *parser) parseParamDecl() ast.Node {
 
 	var def ast.Node = nil
 	if p.consume(tokenizer.EQUAL) != nil {
-		def = p.parseAtom(false)
+		def = p.parseTestExpr(false)
 		if def == nil {
 			return nil
 		}
@@ -1204,6 +1374,27 @@ func (p *parser) parseParamList() []*ast.ParamDecl {
 	return params
 }
 
+// isFuncDecl parses the header of a function declaration speculatively, so
+// that a call like 'f(a, b)' is not mistaken for the declaration 'f(a, b) =>'.
+// The position and the errors of the parser are restored afterwards.
+func (p *parser) isFuncDecl() bool {
+	begin, errs := p.tell(), len(p.errors)
+	defer func() {
+		p.seek(begin)
+		p.errors = p.errors[:errs]
+	}()
+
+	p.consume(tokenizer.EXPORT)
+	p.consume(tokenizer.METHOD)
+	if p.getIdentifier() == nil || p.peekType(0) != tokenizer.LEFT_PAREN {
+		return false
+	}
+	if p.parseParamList() == nil {
+		return false
+	}
+	return p.peekType(0) == tokenizer.RIGHT_FAT_ARROW
+}
+
 func (p *parser) parseFuncDeclStmt() ast.Node {
 	export := p.consume(tokenizer.EXPORT)
 	method := p.consume(tokenizer.METHOD)
@@ -1248,6 +1439,7 @@ func (p *parser) parseFuncDeclStmt() ast.Node {
 }
 
 func (p *parser) parseMemberDecl() ast.Node {
+	declMode := p.consume(tokenizer.VARIP, tokenizer.VAR)
 	begin := p.tell()
 	name := p.getIdentifier()
 	if name == nil {
@@ -1263,15 +1455,20 @@ func (p *parser) parseMemberDecl() ast.Node {
 				return nil
 			}
 		}
+		beginLoc := name.Begin
+		if declMode != nil {
+			beginLoc = declMode.Begin
+		}
 		endLoc := name.End
 		if def != nil {
 			endLoc = def.End()
 		}
 		return ast.WithRange(&ast.MemberDecl{
-			Type:    nil,
-			Name:    name.Lexeme,
-			Default: def,
-		}, name.Begin, endLoc)
+			DeclMode: pickLexeme(declMode),
+			Type:     nil,
+			Name:     name.Lexeme,
+			Default:  def,
+		}, beginLoc, endLoc)
 	}
 
 	p.seek(begin)
@@ -1294,15 +1491,20 @@ func (p *parser) parseMemberDecl() ast.Node {
 		}
 	}
 
+	beginLoc := t.Begin()
+	if declMode != nil {
+		beginLoc = declMode.Begin
+	}
 	endLoc := name.End
 	if def != nil {
 		endLoc = def.End()
 	}
 	return ast.WithRange(&ast.MemberDecl{
-		Type:    t,
-		Name:    name.Lexeme,
-		Default: def,
-	}, t.Begin(), endLoc)
+		DeclMode: pickLexeme(declMode),
+		Type:     t,
+		Name:     name.Lexeme,
+		Default:  def,
+	}, beginLoc, endLoc)
 }
 
 func (p *parser) parseTypeDeclStmt() ast.Node {
@@ -1354,8 +1556,85 @@ func (p *parser) parseTypeDeclStmt() ast.Node {
 	}, typeToken.Begin, endLoc)
 }
 
+func (p *parser) parseEnumField() *ast.EnumField {
+	name := p.getIdentifier()
+	if name == nil {
+		p.error(`Expect an identifier as enum field name, but got %s`, p.peekLexeme())
+		return nil
+	}
+
+	var title ast.Node = nil
+	endLoc := name.End
+	if p.consume(tokenizer.EQUAL) != nil {
+		token := p.peek(0)
+		if token == nil || token.Type != tokenizer.STRING {
+			p.error(`Expect a string as the title of enum field "%s", but got %s`, name.Lexeme, p.peekLexeme())
+			return nil
+		}
+		title = p.parseAtom(false)
+		if title == nil {
+			return nil
+		}
+		endLoc = title.End()
+	}
+
+	return ast.WithRange(&ast.EnumField{
+		Name:  name.Lexeme,
+		Title: title,
+	}, name.Begin, endLoc).(*ast.EnumField)
+}
+
+func (p *parser) parseEnumDeclStmt() ast.Node {
+	export := p.consume(tokenizer.EXPORT)
+	enumToken := p.consume(tokenizer.ENUM)
+	if enumToken == nil {
+		p.error(`Expect "enum", but got %s`, p.peekLexeme())
+		return nil
+	}
+
+	beginLoc := enumToken.Begin
+	if export != nil {
+		beginLoc = export.Begin
+	}
+
+	name := p.getIdentifier()
+	if name == nil {
+		p.error(`Expect an identifer as enum name, but got %s`, p.peekLexeme())
+		return nil
+	}
+
+	if p.consume(tokenizer.INDENT) == nil {
+		p.error(`Expect indent, but got %s`, p.peekLexeme())
+		return nil
+	}
+
+	endLoc := name.End
+	fields := []*ast.EnumField{}
+	for {
+		token := p.peek(0)
+		if token != nil && token.Type == tokenizer.DEDENT {
+			p.consume(tokenizer.DEDENT)
+			endLoc = token.End
+			break
+		}
+
+		field := p.parseEnumField()
+		if field == nil {
+			return nil
+		}
+
+		fields = append(fields, field)
+		p.consume(tokenizer.NEWLINE)
+	}
+
+	return ast.WithRange(&ast.EnumDeclStmt{
+		Export: export != nil,
+		Name:   name.Lexeme,
+		Fields: fields,
+	}, beginLoc, endLoc)
+}
+
 func (p *parser) parseReassignStmt() ast.Node {
-	println("reassign")
 	lhs := p.parseAtomExpr(false)
 	switch lhs.(type) {
 	case *ast.Identifier, *ast.AttrExpr:
@@ -1370,10 +1649,7 @@ func (p *parser) parseReassignStmt() ast.Node {
 		return nil
 	}
 
-	println("a")
 	rhs := p.parseStmt()
-	println("b")
-	fmt.Println(rhs)
 
 	if rhs == nil {
 		return nil
@@ -1442,6 +1718,104 @@ func (p *parser) parseImportStmt() ast.Node {
 }
 
 func (p *parser) parseStmt() ast.Node {
+	tkn := p.peek(0)
+	if tkn == nil {
+		p.error(`Unexpected EOF, file might be truncated`)
+		return nil
+	}
+
+	stmt := p.parseBareStmt()
+	annotations := []*ast.Annotation{}
+	for _, a := range parseAnnotations(tkn) {
+		if fileAnnotations[a.Name] {
+			p.annotations = append(p.annotations, a)
+		} else {
+			annotations = append(annotations, a)
+		}
+	}
+	if len(annotations) > 0 && !p.attachAnnotations(stmt, annotations) {
+		// annotations of a statement which can not have any are kept, too
+		p.annotations = append(p.annotations, annotations...)
+	}
+	return stmt
+}
+
+// attachAnnotations sets the annotations of a declaration, it returns false
+// for the other statements
+func (p *parser) attachAnnotations(stmt ast.Node, annotations []*ast.Annotation) bool {
+	switch s := stmt.(type) {
+	case *ast.VarDeclStmt:
+		s.Annotations = annotations
+	case *ast.TypeDeclStmt:
+		s.Annotations = annotations
+	case *ast.EnumDeclStmt:
+		s.Annotations = annotations
+	case *ast.FuncDeclStmt:
+		s.Annotations = annotations
+		for _, a := range annotations {
+			if a.Name != "param" {
+				continue
+			}
+			name, _, _ := strings.Cut(a.Value, " ")
+			found := false
+			for _, param := range s.Params {
+				if param.Name == name {
+					found = true
+					break
+				}
+			}
+			if !found {
+				p.errors = append(p.errors, ParseError{
+					Row:     a.Begin().Row,
+					Col:     a.Begin().Column,
+					Offset:  a.Begin().Offset,
+					Pos:     a.Begin().Pos,
+					Msg:     fmt.Sprintf(`Function "%s" has no parameter named "%s"`, s.Name, name),
+					Warning: true,
+				})
+			}
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// checkVersion validates the first '//@version' annotation in the tokens,
+// and reports whether there is one
+func (p *parser) checkVersion() bool {
+	for _, token := range p.tokens {
+		for _, a := range parseAnnotations(&token) {
+			if a.Name != "version" {
+				continue
+			}
+			if v, ok := metainfo.ParseLanguageVersion(a.Value); !ok || !supportedVersions[v] {
+				p.errors = append(p.errors, ParseError{
+					Row:    a.Begin().Row,
+					Col:    a.Begin().Column,
+					Offset: a.Begin().Offset,
+					Pos:    a.Begin().Pos,
+					Msg:    fmt.Sprintf(`Unsupported version "%s"`, a.Value),
+					Err:    ErrUnsupportedVersion,
+				})
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) missingVersion() {
+	p.errors = append(p.errors, ParseError{
+		Row:     1,
+		Col:     1,
+		Msg:     `Missing "//@version" annotation`,
+		Warning: true,
+		Err:     ErrMissingVersion,
+	})
+}
+
+func (p *parser) parseBareStmt() ast.Node {
 	tkn := p.peek(0)
 	switch tkn.Type {
 	case tokenizer.BREAK:
@@ -1461,6 +1835,9 @@ func (p *parser) parseStmt() ast.Node {
 	case tokenizer.SWITCH:
 		return p.parseSwitchStmt()
 	case tokenizer.EXPORT:
+		if p.peekType(1) == tokenizer.ENUM {
+			return p.parseEnumDeclStmt()
+		}
 		if p.peekType(1) == tokenizer.TYPE && p.peekType(3) == tokenizer.IDENTIFIER {
 			return p.parseTypeDeclStmt()
 		}
@@ -1471,8 +1848,14 @@ func (p *parser) parseStmt() ast.Node {
 		return p.parseFuncDeclStmt()
 	case tokenizer.VAR, tokenizer.VARIP, tokenizer.CONST, tokenizer.SIMPLE:
 		return p.parseVarDeclStmt()
+	case tokenizer.ENUM:
+		if p.peekType(1) == tokenizer.IDENTIFIER && p.peekType(2) == tokenizer.INDENT {
+			return p.parseEnumDeclStmt()
+		}
+		// 'enum' used as an identifier
+		fallthrough
 	case tokenizer.IDENTIFIER:
-		if p.peekType(1) == tokenizer.LEFT_PAREN && p.hasTokenBeforeNewLine(tokenizer.RIGHT_FAT_ARROW) {
+		if p.peekType(1) == tokenizer.LEFT_PAREN && p.isFuncDecl() {
 			return p.parseFuncDeclStmt()
 		} else if p.peekType(1) == tokenizer.EQUAL {
 			return p.parseVarDeclStmt()
@@ -1483,6 +1866,9 @@ func (p *parser) parseStmt() ast.Node {
 			lhs := p.parseTestExpr(true)
 			afterExpr := p.tell()
 			if lhs == nil {
+				// parsed again to report why it is not an expression
+				p.seek(begin)
+				p.parseTestExpr(false)
 				return nil
 			}
 			typeSatisfy := false
@@ -1611,23 +1997,108 @@ func (p *parser) parseSuite() ast.Node {
 }
 
 func Parse(tokens []tokenizer.Token) ([]ast.Node, []ParseError) {
+	root, errs := ParseFile(tokens)
+	return root.Body, errs
+}
+
+// ParseFile parses a script into its root suite, which holds the top level
+// statements and the annotations of the script (see ast.Suite)
+func ParseFile(tokens []tokenizer.Token) (*ast.Suite, []ParseError) {
 	p := parser{
 		tokens:  tokens,
 		current: 0,
 		errors:  []ParseError{},
 	}
+	if !p.checkVersion() {
+		p.missingVersion()
+	}
+
+	stmts := p.parseStatements()
+	return newRoot(stmts, p.annotations), p.errors
+}
+
+// newRoot makes the root suite of a script, which spans the statements and
+// the annotations
+func newRoot(stmts []ast.Node, annotations []*ast.Annotation) *ast.Suite {
+	root := &ast.Suite{
+		Annotations: annotations,
+		Body:        stmts,
+	}
+	nodes := []ast.Node{}
+	for _, a := range annotations {
+		nodes = append(nodes, a)
+	}
+	nodes = append(nodes, stmts...)
+	if len(nodes) == 0 {
+		return root
+	}
+	begin, end := nodes[0].Begin(), nodes[0].End()
+	for _, n := range nodes[1:] {
+		if n.Begin().Offset < begin.Offset {
+			begin = n.Begin()
+		}
+		if n.End().Offset > end.Offset {
+			end = n.End()
+		}
+	}
+	root.SetRange(begin, end)
+	return root
+}
+
+// parseStatements parses the tokens one top level statement at a time, so
+// that an error in a statement never affects the others. This also makes
+// the statements of a script parse the same way when they are parsed in
+// chunks by a Tree.
+func (p *parser) parseStatements() []ast.Node {
+	tokens := p.tokens
+	var eof *tokenizer.Token
+	if n := len(tokens); n > 0 && tokens[n-1].Type == tokenizer.EOF {
+		eof = &tokens[n-1]
+		tokens = tokens[:n-1]
+	}
 
 	stmts := []ast.Node{}
-	for !p.eof() {
-		stmt := p.parseStmtGroup()
-		if stmt == nil {
-			p.seekToType(tokenizer.NEWLINE)
-			p.consume(tokenizer.NEWLINE)
-		} else {
-			stmts = append(stmts, stmt)
-			p.consume(tokenizer.NEWLINE)
+	for len(tokens) > 0 {
+		end := statementEnd(tokens)
+		p.tokens = tokens[:end]
+		p.current = 0
+		for !p.eof() {
+			stmt := p.parseStmtGroup()
+			if stmt == nil {
+				p.seekToType(tokenizer.NEWLINE)
+				p.consume(tokenizer.NEWLINE)
+			} else {
+				stmts = append(stmts, stmt)
+				p.consume(tokenizer.NEWLINE)
+			}
 		}
+		tokens = tokens[end:]
 	}
+	// the annotations after the last statement
+	p.annotations = append(p.annotations, parseAnnotations(eof)...)
+	p.tokens = tokens
+	return stmts
+}
 
-	return stmts, p.errors
+// statementEnd finds the end of the first top level statement, which is
+// after a NEWLINE outside of any block, or after the DEDENT closing the last
+// block of the statement, unless the statement goes on with 'else'
+func statementEnd(tokens []tokenizer.Token) int {
+	level := 0
+	for i, token := range tokens {
+		switch token.Type {
+		case tokenizer.INDENT:
+			level++
+		case tokenizer.DEDENT:
+			level--
+			if level <= 0 && (i+1 == len(tokens) || tokens[i+1].Type != tokenizer.ELSE) {
+				return i + 1
+			}
+		case tokenizer.NEWLINE:
+			if level <= 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(tokens)
 }
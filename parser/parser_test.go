@@ -0,0 +1,236 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kvarenzn/pinecone/ast"
+	"github.com/kvarenzn/pinecone/tokenizer"
+)
+
+// parseExpr parses the expression of the script 'x = <src>'
+func parseExpr(t *testing.T, src string) ast.Node {
+	t.Helper()
+	tokens, tokenErrs := tokenizer.Tokenize("//@version=5\nx = " + src + "\n")
+	if len(tokenErrs) > 0 {
+		t.Fatalf("%s: %v", src, tokenErrs)
+	}
+	stmts, errs := Parse(tokens)
+	if len(errs) > 0 {
+		t.Fatalf("%s: %v", src, errs)
+	}
+	if len(stmts) != 1 {
+		t.Fatalf("%s: expected 1 statement, got %d", src, len(stmts))
+	}
+	decl, ok := stmts[0].(*ast.VarDeclStmt)
+	if !ok {
+		t.Fatalf("%s: expected a variable declaration, got %s", src, ast.SExpr(stmts[0]))
+	}
+	return decl.Initial.(*ast.ExprStmt).Expr
+}
+
+func TestHistoryReference(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{
+			`close[1]`,
+			`(HRefExpr series:(Identifier name:"close") offset:(IntLiteral value:1))`,
+		},
+		{
+			`ta.sma(close, 10)[2]`,
+			`(HRefExpr series:(CallExpr func:(AttrExpr target:(Identifier name:"ta") name:"sma") args:((Identifier name:"close") (IntLiteral value:10))) offset:(IntLiteral value:2))`,
+		},
+		{
+			`close[1][2]`,
+			`(HRefExpr series:(HRefExpr series:(Identifier name:"close") offset:(IntLiteral value:1)) offset:(IntLiteral value:2))`,
+		},
+	}
+
+	for _, test := range tests {
+		if got := ast.SExpr(parseExpr(t, test.src)); got != test.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", test.src, got, test.want)
+		}
+	}
+}
+
+func TestColorLiteral(t *testing.T) {
+	// every channel is in [0, 255], T is the transparency in [0, 100]
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`#f0a`, `(ColorLiteral r:255 g:0 b:170 t:0)`},
+		{`#f0a0`, `(ColorLiteral r:255 g:0 b:170 t:100)`},
+		{`#f0af`, `(ColorLiteral r:255 g:0 b:170 t:0)`},
+		{`#ff0080`, `(ColorLiteral r:255 g:0 b:128 t:0)`},
+		{`#ff008000`, `(ColorLiteral r:255 g:0 b:128 t:100)`},
+		{`#ff0080ff`, `(ColorLiteral r:255 g:0 b:128 t:0)`},
+		{`#000000`, `(ColorLiteral r:0 g:0 b:0 t:0)`},
+	}
+
+	for _, test := range tests {
+		if got := ast.SExpr(parseExpr(t, test.src)); got != test.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", test.src, got, test.want)
+		}
+	}
+}
+
+func TestFileAnnotations(t *testing.T) {
+	src := `//@version=5
+//@description a library
+//@function doubles a value
+double(float x) => x * 2
+//@variable after the last statement
+`
+	tokens, tokenErrs := tokenizer.Tokenize(src)
+	if len(tokenErrs) > 0 {
+		t.Fatal(tokenErrs)
+	}
+	root, errs := ParseFile(tokens)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	names := []string{}
+	for _, a := range root.Annotations {
+		names = append(names, a.Name)
+	}
+	if got, want := strings.Join(names, " "), "version description variable"; got != want {
+		t.Errorf("annotations of the root: got %q, want %q", got, want)
+	}
+
+	f := root.Body[0].(*ast.FuncDeclStmt)
+	if len(f.Annotations) != 1 || f.Annotations[0].Name != "function" {
+		t.Errorf("annotations of the function: got %s", ast.SExpr(f))
+	}
+	if root.End().Offset != len(src)-2 {
+		t.Errorf("the root ends at %d, want %d", root.End().Offset, len(src)-2)
+	}
+}
+
+func TestFuncDeclDetection(t *testing.T) {
+	// the kinds of the top level statements of each script
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"f(a, b) => a + b", "FuncDeclStmt"},
+		{"f(simple int n, series float s = 1.0) =>\n    n + s", "FuncDeclStmt"},
+		{"f(x = -1) => x", "FuncDeclStmt"},
+		{"export f(int x) => x", "FuncDeclStmt"},
+		{"method m(float x) => x", "FuncDeclStmt"},
+		{"f(a, b)", "ExprStmt"},
+		{"f(a, 1)", "ExprStmt"},
+		{"f(close[1])", "ExprStmt"},
+		{"f(a, b)\ng(x) => x", "ExprStmt FuncDeclStmt"},
+		// a call as the condition of a case is not a declaration
+		{"x = switch\n    f(a) => 1\n    => 2", "VarDeclStmt"},
+	}
+
+	for _, test := range tests {
+		tokens, tokenErrs := tokenizer.Tokenize("//@version=5\n" + test.src + "\n")
+		if len(tokenErrs) > 0 {
+			t.Fatalf("%q: %v", test.src, tokenErrs)
+		}
+		stmts, errs := Parse(tokens)
+		if len(errs) > 0 {
+			t.Errorf("%q: %v", test.src, errs)
+			continue
+		}
+		kinds := []string{}
+		for _, stmt := range stmts {
+			kinds = append(kinds, strings.TrimPrefix(strings.Fields(ast.SExpr(stmt))[0], "("))
+		}
+		if got := strings.Join(kinds, " "); got != test.want {
+			t.Errorf("%q: got %s, want %s", test.src, got, test.want)
+		}
+	}
+}
+
+func TestMalformedFuncHeader(t *testing.T) {
+	// the errors of the speculative parse of the header are dropped, the
+	// ones of the call are reported once
+	tokens, _ := tokenizer.Tokenize("//@version=5\nf(1 +) => 2\n")
+	_, errs := Parse(tokens)
+	if len(errs) != 1 || errs[0].Row != 2 {
+		t.Errorf("got %v, want one error on row 2", errs)
+	}
+}
+
+func TestStringLiteral(t *testing.T) {
+	tests := []struct {
+		src   string
+		value string
+	}{
+		{`""`, ``},
+		{`"a\"b"`, `a"b`},
+		{`'it\'s'`, `it's`},
+		{`'say "hi"'`, `say "hi"`},
+		{`"a\tb\nc\\"`, "a\tb\nc\\"},
+		{`"\u00e9t\u00e9"`, "été"},
+		// a backslash before another character is dropped
+		{`"\q"`, `q`},
+		// the line break and the indentation of a wrapped string are removed
+		{"f(\"ab\n   cd\")", `abcd`},
+	}
+
+	for _, test := range tests {
+		expr := parseExpr(t, test.src)
+		if call, ok := expr.(*ast.CallExpr); ok {
+			expr = call.Args[0]
+		}
+		lit, ok := expr.(*ast.StringLiteral)
+		if !ok {
+			t.Errorf("%s: got %s", test.src, ast.SExpr(expr))
+			continue
+		}
+		if lit.Value != test.value {
+			t.Errorf("%s: got value %q, want %q", test.src, lit.Value, test.value)
+		}
+		if raw := strings.TrimPrefix(test.src, "f("); lit.Raw != strings.TrimSuffix(raw, ")") {
+			t.Errorf("%s: got raw %q", test.src, lit.Raw)
+		}
+	}
+}
+
+func TestInvalidStringLiteral(t *testing.T) {
+	tests := []struct {
+		src string
+		// the literal parsed despite the error, if any
+		value string
+		err   string
+	}{
+		{`x = "abc`, `abc`, "Unterminated string"},
+		// the last quote is escaped
+		{`x = "abc\"`, `abc"`, "Unterminated string"},
+		{`x = "\u00g1"`, ``, "invalid unicode escape"},
+	}
+
+	for _, test := range tests {
+		tokens, tokenErrs := tokenizer.Tokenize("//@version=5\n" + test.src + "\n")
+		stmts, parseErrs := Parse(tokens)
+		msgs := []string{}
+		for _, err := range tokenErrs {
+			msgs = append(msgs, err.Msg)
+		}
+		for _, err := range parseErrs {
+			msgs = append(msgs, err.Msg)
+		}
+		if len(msgs) != 1 || !strings.Contains(msgs[0], test.err) {
+			t.Errorf("%s: got errors %v, want %q", test.src, msgs, test.err)
+		}
+		if test.value == "" {
+			continue
+		}
+		if len(stmts) != 1 {
+			t.Errorf("%s: got %d statements", test.src, len(stmts))
+			continue
+		}
+		lit, ok := stmts[0].(*ast.VarDeclStmt).Initial.(*ast.ExprStmt).Expr.(*ast.StringLiteral)
+		if !ok || lit.Value != test.value || lit.Raw != strings.TrimPrefix(test.src, "x = ") {
+			t.Errorf("%s: got %s", test.src, ast.SExpr(stmts[0]))
+		}
+	}
+}
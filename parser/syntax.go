@@ -0,0 +1,201 @@
+package parser
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/kvarenzn/pinecone/ast"
+	"github.com/kvarenzn/pinecone/tokenizer"
+)
+
+// SyntaxTree is a script parsed from the tokens of
+// tokenizer.TokenizeLossless, which remembers the tokens each node was
+// parsed from, and what the node was like then. Tools change the nodes
+// under Root (see ast.Apply), and format.Tree prints the script back with
+// the comments and the whitespace kept around the nodes they changed.
+//
+// The nodes are known by their identity: a node built by a tool, or copied
+// with ast.Clone, has no tokens even if it takes the range of the node it
+// replaces.
+type SyntaxTree struct {
+	Root   *ast.Suite
+	Tokens []tokenizer.Token
+	nodes  map[ast.Node]*parsedNode
+}
+
+// Span is the tokens a node was parsed from, by their indices in the
+// tokens of the tree. The trivia before the first token and after the last
+// one are not part of it.
+type Span struct {
+	First, Last int
+}
+
+type parsedNode struct {
+	span     Span
+	values   map[string]any
+	children []ast.Child
+}
+
+// Change is a value of a node which differs from the value the node was
+// parsed with. A pointer value is given by the value it points to, or nil.
+type Change struct {
+	Field    string
+	Old, New any
+}
+
+// ParseLossless parses a script and maps its nodes to their tokens. The
+// errors of the tokenizer come first.
+func ParseLossless(source string) (*SyntaxTree, []ParseError) {
+	tokens, tokenErrs := tokenizer.TokenizeLossless(source)
+	root, errs := ParseFile(tokens)
+	ast.SetParents(root)
+
+	t := &SyntaxTree{
+		Root:   root,
+		Tokens: tokens,
+		nodes:  map[ast.Node]*parsedNode{},
+	}
+
+	// the indices of the tokens which are written in the source
+	indices := []int{}
+	for i, token := range tokens {
+		if !token.IsMeta() && token.Type != tokenizer.EOF {
+			indices = append(indices, i)
+		}
+	}
+	ast.Inspect(root, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		if _, ok := n.(*ast.Annotation); ok {
+			// annotations are comments, they are trivia of the tokens
+			return false
+		}
+		first := sort.Search(len(indices), func(i int) bool {
+			return tokens[indices[i]].Begin.Offset >= n.Begin().Offset
+		})
+		last := sort.Search(len(indices), func(i int) bool {
+			return tokens[indices[i]].End.Offset > n.End().Offset
+		}) - 1
+		if first <= last {
+			t.nodes[n] = &parsedNode{
+				span:     Span{indices[first], indices[last]},
+				values:   values(n),
+				children: ast.Children(n),
+			}
+		}
+		return true
+	})
+
+	return t, append(TokenizerErrors(tokenErrs), errs...)
+}
+
+// values gives the fields of a node which are not nodes, by name
+func values(n ast.Node) map[string]any {
+	nodeType := reflect.TypeOf((*ast.Node)(nil)).Elem()
+	isNode := func(t reflect.Type) bool {
+		return t == nodeType || t.Implements(nodeType)
+	}
+
+	result := map[string]any{}
+	v := reflect.ValueOf(n).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.Anonymous || !f.IsExported() || isNode(f.Type) || f.Type.Kind() == reflect.Slice && isNode(f.Type.Elem()) {
+			continue
+		}
+		result[f.Name] = value(v.Field(i))
+	}
+	return result
+}
+
+func value(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return v.Elem().Interface()
+	case reflect.Slice:
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(c, v)
+		return c.Interface()
+	}
+	return v.Interface()
+}
+
+// Span gives the tokens a node was parsed from. Nodes built after parsing,
+// and nodes with no tokens of their own like annotations, have none.
+func (t *SyntaxTree) Span(n ast.Node) (Span, bool) {
+	p, ok := t.nodes[n]
+	if !ok {
+		return Span{}, false
+	}
+	return p.span, true
+}
+
+// ParsedChildren gives the children a node had when it was parsed, or nil
+// if the node has no tokens
+func (t *SyntaxTree) ParsedChildren(n ast.Node) []ast.Child {
+	if p, ok := t.nodes[n]; ok {
+		return p.children
+	}
+	return nil
+}
+
+// Changes lists the values of a node which were changed since it was
+// parsed, sorted by field. It is false for nodes with no tokens.
+func (t *SyntaxTree) Changes(n ast.Node) ([]Change, bool) {
+	p, ok := t.nodes[n]
+	if !ok {
+		return nil, false
+	}
+	changes := []Change{}
+	for name, v := range values(n) {
+		if !reflect.DeepEqual(v, p.values[name]) {
+			changes = append(changes, Change{
+				Field: name,
+				Old:   p.values[name],
+				New:   v,
+			})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes, true
+}
+
+// Changed tells whether a node was built or changed after parsing: it has
+// no tokens, one of its values was changed, or one of its children was
+// replaced, added or removed. Changes further down are not counted.
+func (t *SyntaxTree) Changed(n ast.Node) bool {
+	changes, ok := t.Changes(n)
+	if !ok || len(changes) > 0 {
+		return true
+	}
+	children := ast.Children(n)
+	parsed := t.nodes[n].children
+	if len(children) != len(parsed) {
+		return true
+	}
+	for i, c := range children {
+		if c != parsed[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// Text gives the source text of a span, with the trivia between its tokens
+func (t *SyntaxTree) Text(s Span) string {
+	var sb strings.Builder
+	sb.WriteString(t.Tokens[s.First].Lexeme)
+	for i := s.First + 1; i <= s.Last; i++ {
+		sb.WriteString(t.Tokens[i-1].TrailingText())
+		sb.WriteString(t.Tokens[i].LeadingText())
+		sb.WriteString(t.Tokens[i].Lexeme)
+	}
+	return sb.String()
+}
@@ -0,0 +1,104 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kvarenzn/pinecone/ast"
+)
+
+func TestSyntaxTreeSpans(t *testing.T) {
+	tree, errs := ParseLossless(treeScript)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	texts := map[string]bool{}
+	ast.Inspect(tree.Root, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		if _, ok := n.(*ast.Annotation); ok {
+			if _, ok := tree.Span(n); ok {
+				t.Errorf("the annotation at %v has tokens", n.Begin())
+			}
+			return false
+		}
+		span, ok := tree.Span(n)
+		if !ok {
+			t.Errorf("the %T at %v has no tokens", n, n.Begin())
+			return false
+		}
+		// suites begin with their annotations or their INDENT token
+		if _, ok := n.(*ast.Suite); !ok && tree.Tokens[span.First].Begin != n.Begin() {
+			t.Errorf("the %T at %v begins with the token at %v", n, n.Begin(), tree.Tokens[span.First].Begin)
+		}
+		texts[tree.Text(span)] = true
+		return true
+	})
+
+	for _, text := range []string{
+		`input.int(14, "Length")`,
+		"a := a * 2",
+		"if a > 0\n        a := a * 2\n    else\n        a := -a",
+		"double(v) => v * 2",
+		"plot(ta.sma(src, length),\n     color = #ff0080,\n     title = \"SMA\")",
+	} {
+		if !texts[text] {
+			t.Errorf("no node is written %q", text)
+		}
+	}
+}
+
+func TestSyntaxTreeChanges(t *testing.T) {
+	tree, errs := ParseLossless(treeScript)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	var decl *ast.VarDeclStmt
+	var call *ast.CallExpr
+	ast.Inspect(tree.Root, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.VarDeclStmt:
+			if decl == nil {
+				decl = n
+			}
+		case *ast.CallExpr:
+			if call == nil {
+				call = n
+			}
+		}
+		return true
+	})
+	if tree.Changed(decl) || tree.Changed(call) || tree.Changed(tree.Root) {
+		t.Fatal("the nodes are changed after parsing")
+	}
+
+	mode := "var"
+	decl.DeclMode = &mode
+	decl.Name = "len"
+	changes, ok := tree.Changes(decl)
+	want := []Change{
+		{Field: "DeclMode", Old: nil, New: "var"},
+		{Field: "Name", Old: "length", New: "len"},
+	}
+	if !ok || !reflect.DeepEqual(changes, want) {
+		t.Errorf("got changes %+v, want %+v", changes, want)
+	}
+
+	// a replaced argument changes the call, but not the other children
+	ast.Replace(call, call.Args[0], &ast.IntLiteral{Value: 20})
+	if !tree.Changed(call) || tree.Changed(call.Func) {
+		t.Error("a replaced argument is not a change of the call only")
+	}
+	if children := tree.ParsedChildren(call); len(children) != 2 || children[1].Node == call.Args[0] {
+		t.Errorf("the call was parsed with the children %+v", children)
+	}
+	if _, ok := tree.Span(call.Args[0]); ok || !tree.Changed(call.Args[0]) {
+		t.Error("a new node has tokens")
+	}
+	if tree.Changed(tree.Root) {
+		t.Error("the statements of the root are changed")
+	}
+}
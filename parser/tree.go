@@ -0,0 +1,243 @@
+package parser
+
+import (
+	"github.com/kvarenzn/pinecone/ast"
+	"github.com/kvarenzn/pinecone/metainfo"
+	"github.com/kvarenzn/pinecone/tokenizer"
+)
+
+// Tree is a script kept parsed while it is edited. The script is split into
+// chunks of top level statements (see tokenizer.Chunk), and an edit only
+// tokenizes and parses again the chunks it touches, the syntax trees of the
+// other chunks are reused.
+//
+// The chunks after an edit are only moved by it, their tokens, nodes and
+// errors are shifted when they are read. The nodes are copied then, so the
+// statements returned before an edit keep their locations.
+type Tree struct {
+	source string
+	// the location of the first byte of the script
+	start  metainfo.Location
+	chunks []*chunk
+}
+
+type chunk struct {
+	tokenizer.Chunk
+	stmts       []ast.Node
+	annotations []*ast.Annotation
+	errors      []ParseError
+	// the rows and bytes the chunk has moved down by since its tokens, nodes
+	// and errors were last shifted
+	rows, bytes int
+}
+
+func newChunk(c tokenizer.Chunk) *chunk {
+	p := parser{
+		tokens: c.Tokens,
+		errors: TokenizerErrors(c.Errors),
+	}
+	stmts := p.parseStatements()
+	return &chunk{
+		Chunk:       c,
+		stmts:       stmts,
+		annotations: p.annotations,
+		errors:      p.errors,
+	}
+}
+
+// NewTree parses a script
+func NewTree(source string) *Tree {
+	return newTree(source, metainfo.Location{Row: 1, Column: 1})
+}
+
+// NewFileTree parses the script of a file, the locations in the tree hold
+// their positions in the FileSet of the file like with
+// tokenizer.TokenizeFile. The positions of the script after an edit are
+// those of a file of the same base.
+func NewFileTree(f *metainfo.File) *Tree {
+	return newTree(string(f.Source()), metainfo.Location{Row: 1, Column: 1, Pos: f.Pos(0)})
+}
+
+func newTree(source string, start metainfo.Location) *Tree {
+	t := &Tree{source: source, start: start}
+	for _, c := range tokenizer.TokenizeChunks(source, start, nil) {
+		t.chunks = append(t.chunks, newChunk(c))
+	}
+	return t
+}
+
+func (t *Tree) Source() string {
+	return t.source
+}
+
+func (t *Tree) Tokens() []tokenizer.Token {
+	tokens := []tokenizer.Token{}
+	for _, c := range t.chunks {
+		tokens = append(tokens, c.tokens()...)
+	}
+	return tokens
+}
+
+// Stmts returns the top level statements, like Parse
+func (t *Tree) Stmts() []ast.Node {
+	stmts := []ast.Node{}
+	for _, c := range t.chunks {
+		c.settle()
+		stmts = append(stmts, c.stmts...)
+	}
+	return stmts
+}
+
+// Root returns the root suite, like ParseFile
+func (t *Tree) Root() *ast.Suite {
+	stmts := t.Stmts()
+	annotations := []*ast.Annotation{}
+	for _, c := range t.chunks {
+		annotations = append(annotations, c.annotations...)
+	}
+	return newRoot(stmts, annotations)
+}
+
+// Errors returns the errors of the script, like Parse
+func (t *Tree) Errors() []ParseError {
+	p := parser{errors: []ParseError{}}
+	found := false
+	for _, c := range t.chunks {
+		p.tokens = c.tokens()
+		if found = p.checkVersion(); found {
+			break
+		}
+	}
+	if !found {
+		p.missingVersion()
+	}
+
+	for _, c := range t.chunks {
+		p.errors = append(p.errors, c.movedErrors()...)
+	}
+	return p.errors
+}
+
+// Edit replaces the bytes from start to end of the script with text
+func (t *Tree) Edit(start, end int, text string) {
+	source := t.source[:start] + text + t.source[end:]
+	delta := len(text) - (end - start)
+
+	// tokenizing starts from the chunk before the edit, as the edit may turn
+	// the first line of its chunk into a continuation of the chunk before
+	first := 0
+	for first+1 < len(t.chunks) && t.chunks[first+1].begin().Offset <= start {
+		first++
+	}
+	first = max(first-1, 0)
+
+	begin := t.start
+	if first < len(t.chunks) {
+		begin = t.chunks[first].begin()
+	}
+
+	// and stops at the first chunk after the edit which begins where a chunk
+	// of the old script did, the chunks from there on are the same
+	reused := len(t.chunks)
+	rows := 0
+	next := first
+	stop := func(loc metainfo.Location) bool {
+		if loc.Offset < start+len(text) {
+			return false
+		}
+		for next < len(t.chunks) && t.chunks[next].begin().Offset+delta < loc.Offset {
+			next++
+		}
+		if next < len(t.chunks) && t.chunks[next].begin().Offset+delta == loc.Offset && t.chunks[next].begin().Offset >= end {
+			reused = next
+			rows = loc.Row - t.chunks[next].begin().Row
+			return true
+		}
+		return false
+	}
+
+	parsed := tokenizer.TokenizeChunks(source, begin, stop)
+	chunks := make([]*chunk, 0, first+len(parsed)+len(t.chunks)-reused)
+	chunks = append(chunks, t.chunks[:first]...)
+	for _, c := range parsed {
+		chunks = append(chunks, newChunk(c))
+	}
+	for _, c := range t.chunks[reused:] {
+		c.rows += rows
+		c.bytes += delta
+		chunks = append(chunks, c)
+	}
+
+	t.source = source
+	t.chunks = chunks
+}
+
+// begin is the location of the chunk in the script
+func (c *chunk) begin() metainfo.Location {
+	return c.Begin.Moved(c.rows, c.bytes)
+}
+
+// tokens returns the tokens of the chunk where the chunk is
+func (c *chunk) tokens() []tokenizer.Token {
+	if c.rows == 0 && c.bytes == 0 {
+		return c.Tokens
+	}
+	tokens := make([]tokenizer.Token, len(c.Tokens))
+	for i, token := range c.Tokens {
+		tokens[i] = token.Moved(c.rows, c.bytes)
+	}
+	return tokens
+}
+
+// movedErrors returns the errors of the chunk where the chunk is
+func (c *chunk) movedErrors() []ParseError {
+	if c.rows == 0 && c.bytes == 0 {
+		return c.errors
+	}
+	errs := make([]ParseError, len(c.errors))
+	for i, e := range c.errors {
+		// some errors are reported without a position
+		if e.Offset >= 0 {
+			e.Row += c.rows
+			e.Offset += c.bytes
+		}
+		if e.Pos.IsValid() {
+			e.Pos += metainfo.Pos(c.bytes)
+		}
+		errs[i] = e
+	}
+	return errs
+}
+
+// settle shifts the tokens, nodes and errors of a moved chunk to where it
+// is. The nodes are copied, since the statements returned before the chunk
+// moved still hold them.
+func (c *chunk) settle() {
+	if c.rows == 0 && c.bytes == 0 {
+		return
+	}
+
+	move := func(n ast.Node) bool {
+		if n != nil {
+			n.SetRange(n.Begin().Moved(c.rows, c.bytes), n.End().Moved(c.rows, c.bytes))
+		}
+		return true
+	}
+	annotations := make([]*ast.Annotation, len(c.annotations))
+	for i, a := range c.annotations {
+		annotations[i] = ast.Clone(a).(*ast.Annotation)
+		move(annotations[i])
+	}
+	stmts := make([]ast.Node, len(c.stmts))
+	for i, stmt := range c.stmts {
+		stmts[i] = ast.Clone(stmt)
+		ast.Inspect(stmts[i], move)
+	}
+
+	c.Begin = c.begin()
+	c.Tokens = c.tokens()
+	c.errors = c.movedErrors()
+	c.annotations = annotations
+	c.stmts = stmts
+	c.rows, c.bytes = 0, 0
+}
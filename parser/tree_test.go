@@ -0,0 +1,235 @@
+package parser
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/kvarenzn/pinecone/ast"
+	"github.com/kvarenzn/pinecone/metainfo"
+	"github.com/kvarenzn/pinecone/tokenizer"
+)
+
+const treeScript = `//@version=5
+indicator("tree")
+
+length = input.int(14, "Length")
+src = close
+
+f(x, y) =>
+    a = x + y
+    if a > 0
+        a := a * 2
+    else
+        a := -a
+    a
+
+//@function doubles a value
+double(v) => v * 2
+
+plot(ta.sma(src, length),
+     color = #ff0080,
+     title = "SMA")
+s = "a string"
+//@variable an annotation after the last statement
+`
+
+// bigScript repeats a block of statements n times
+func bigScript(n int) string {
+	var sb strings.Builder
+	sb.WriteString("//@version=5\nindicator(\"big\")\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "v%d = close[%d] + ta.sma(close, %d)\n", i, i%10, i+1)
+		fmt.Fprintf(&sb, "f%d(x) =>\n    y = x * %d\n    if y > 0\n        y := y - 1\n    y\n", i, i)
+		fmt.Fprintf(&sb, "plot(f%d(v%d),\n     color = #ff0080,\n     title = \"plot %d\")\n", i, i, i)
+	}
+	return sb.String()
+}
+
+func dumpTree(t *testing.T, root *ast.Suite, tokens []tokenizer.Token, errs []ParseError) string {
+	t.Helper()
+	data, err := ast.ToJSON(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sb strings.Builder
+	sb.Write(data)
+	for _, token := range tokens {
+		fmt.Fprintf(&sb, "\n%v %v-%v", token, token.Begin, token.End)
+	}
+	// a tree reports the errors chunk by chunk
+	errs = slices.Clone(errs)
+	slices.SortStableFunc(errs, func(a, b ParseError) int {
+		return a.Offset - b.Offset
+	})
+	for _, e := range errs {
+		fmt.Fprintf(&sb, "\n%v (%d)", e, e.Offset)
+	}
+	return sb.String()
+}
+
+// checkTree compares a tree with a tree parsed from scratch
+func checkTree(t *testing.T, tree *Tree, step string) {
+	t.Helper()
+	full := NewTree(tree.Source())
+	got := dumpTree(t, tree.Root(), tree.Tokens(), tree.Errors())
+	want := dumpTree(t, full.Root(), full.Tokens(), full.Errors())
+	if got != want {
+		t.Fatalf("%s: the edited tree differs from a new one\nsource:\n%s\ngot:\n%s\nwant:\n%s", step, tree.Source(), got, want)
+	}
+
+	// and with the script parsed as a whole
+	tokens, tokenErrs := tokenizer.Tokenize(tree.Source())
+	root, errs := ParseFile(tokens)
+	want = dumpTree(t, root, tokens, append(TokenizerErrors(tokenErrs), errs...))
+	if got != want {
+		t.Fatalf("%s: the edited tree differs from the parsed script\nsource:\n%s\ngot:\n%s\nwant:\n%s", step, tree.Source(), got, want)
+	}
+}
+
+func TestTreeEdit(t *testing.T) {
+	edits := []struct {
+		name string
+		old  string
+		new  string
+	}{
+		{"rename a variable", "length = ", "len = "},
+		{"insert a statement", "src = close\n", "src = close\nx = 1\n"},
+		{"change a block", "        a := a * 2\n", "        a := a * 3\n        a += 1\n"},
+		{"wrap a line", "x = 1\n", "x = 1 +\n     2\n"},
+		{"unwrap a line", "x = 1 +\n     2\n", "x = 1\n"},
+		{"continue the line before", "\nsrc = close", "\n  src = close"},
+		{"undo the continuation", "\n  src = close", "\nsrc = close"},
+		{"unterminate a string", `s = "a string"`, `s = "a string`},
+		{"terminate the string", `s = "a string`, `s = "a string"`},
+		{"break the indentation", "    a\n\n//@function", "  a\n\n//@function"},
+		{"fix the indentation", "  a\n\n//@function", "    a\n\n//@function"},
+		{"remove an annotation", "//@function doubles a value\n", ""},
+		{"add an else", "        a := -a\n", "        a := -a\n    else if a < 0\n        a := 0\n"},
+		{"delete a function", "double(v) => v * 2\n", ""},
+		{"append at the end", `s = "a string"` + "\n", `s = "a string"` + "\nt = s + s\n"},
+	}
+
+	tree := NewTree(treeScript)
+	checkTree(t, tree, "new")
+	for _, e := range edits {
+		start := strings.Index(tree.Source(), e.old)
+		if start < 0 {
+			t.Fatalf("%s: %q not found", e.name, e.old)
+		}
+		tree.Edit(start, start+len(e.old), e.new)
+		checkTree(t, tree, e.name)
+	}
+}
+
+func TestTreeEditEverywhere(t *testing.T) {
+	// insert and delete a character at every offset of the script
+	for i := 0; i <= len(treeScript); i++ {
+		tree := NewTree(treeScript)
+		tree.Edit(i, i, "x")
+		checkTree(t, tree, fmt.Sprintf("insert at %d", i))
+		tree.Edit(i, i+1, "")
+		checkTree(t, tree, fmt.Sprintf("delete at %d", i))
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	src := bigScript(1000)
+	b.SetBytes(int64(len(src)))
+	for i := 0; i < b.N; i++ {
+		tokens, _ := tokenizer.Tokenize(src)
+		Parse(tokens)
+	}
+}
+
+func BenchmarkReparse(b *testing.B) {
+	src := bigScript(1000)
+	b.SetBytes(int64(len(src)))
+	tree := NewTree(src)
+	// type and delete a character in the middle of the script
+	at := strings.Index(src, "y := y - 1") + len("y := y - ")
+	for i := 0; i < b.N; i++ {
+		if i%2 == 0 {
+			tree.Edit(at, at, "2")
+		} else {
+			tree.Edit(at, at+1, "")
+		}
+	}
+}
+
+func TestFileTreePositions(t *testing.T) {
+	fset := metainfo.NewFileSet()
+	fset.AddFile("lib.pine", []byte("//@version=5\nlibrary(\"lib\")\n"))
+	file := fset.AddFile("tree.pine", []byte(treeScript))
+
+	// every node of the second file knows its file, after edits too
+	check := func(step string, tree *Tree) {
+		t.Helper()
+		f := metainfo.NewFileSet()
+		f.AddFile("lib.pine", []byte("//@version=5\nlibrary(\"lib\")\n"))
+		edited := f.AddFile("tree.pine", []byte(tree.Source()))
+		ast.Inspect(tree.Root(), func(n ast.Node) bool {
+			if n == nil {
+				return false
+			}
+			for _, loc := range []metainfo.Location{n.Begin(), n.End()} {
+				if loc.Pos != edited.Pos(loc.Offset) {
+					t.Fatalf("%s: %s at %d has position %d, want %d", step, ast.SExpr(n), loc.Offset, loc.Pos, edited.Pos(loc.Offset))
+				}
+			}
+			return true
+		})
+		for _, e := range tree.Errors() {
+			if e.Offset >= 0 && e.Pos.IsValid() && f.Position(e.Pos).Filename != "tree.pine" {
+				t.Fatalf("%s: %v is not attributed to tree.pine", step, e)
+			}
+		}
+	}
+
+	tree := NewFileTree(file)
+	check("new", tree)
+	at := strings.Index(treeScript, "src = close")
+	tree.Edit(at, at, "x = 1\n")
+	check("insert a statement", tree)
+	tree.Edit(at, at+len("x = 1\n"), "")
+	check("delete it", tree)
+}
+
+func TestTreeEditKeepsSnapshots(t *testing.T) {
+	tree := NewTree(treeScript)
+	root, tokens, errs := tree.Root(), tree.Tokens(), tree.Errors()
+	want := dumpTree(t, root, tokens, errs)
+
+	// the statements after the edit are moved by it
+	at := strings.Index(treeScript, "src = close")
+	tree.Edit(at, at, "x = 1\n")
+	checkTree(t, tree, "insert a statement")
+	if got := dumpTree(t, root, tokens, errs); got != want {
+		t.Errorf("the tree returned before the edit changed with it\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestTreeEditCost(t *testing.T) {
+	// an edit at the beginning of a script moves all the chunks after it,
+	// which costs the same whatever the size of the script
+	allocs := func(n int) float64 {
+		src := bigScript(n)
+		tree := NewTree(src)
+		tree.Root()
+		at := strings.Index(src, "y := y - 1") + len("y := y - ")
+		i := 0
+		return testing.AllocsPerRun(100, func() {
+			if i%2 == 0 {
+				tree.Edit(at, at, "2")
+			} else {
+				tree.Edit(at, at+1, "")
+			}
+			i++
+		})
+	}
+	small, big := allocs(10), allocs(1000)
+	if big > small {
+		t.Errorf("an edit allocates %v times in a script of 1000 blocks, and %v times in one of 10", big, small)
+	}
+}
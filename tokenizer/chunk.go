@@ -0,0 +1,94 @@
+package tokenizer
+
+import "github.com/kvarenzn/pinecone/metainfo"
+
+// Chunk is a run of top level statements which can be tokenized on its own:
+// it begins at the start of a line, and no bracket, indented block or
+// compiler annotation from the text before it goes on into it.
+//
+// The NEWLINE or DEDENT tokens ending the last statement of a chunk belong
+// to the chunk, though they are placed on the line break before the next
+// statement, which may be in the next chunk.
+type Chunk struct {
+	Begin  metainfo.Location
+	Tokens []Token
+	// the errors of the text of the chunk, see Tokenize
+	Errors []Error
+}
+
+// TokenizeChunks tokenizes the source from the beginning of a chunk, and
+// splits the tokens into chunks. After each chunk it calls stop, if it is
+// not nil, with the beginning of the next one, and gives up the rest of the
+// source when stop returns true.
+func TokenizeChunks(source string, begin metainfo.Location, stop func(next metainfo.Location) bool) []Chunk {
+	if stop == nil {
+		stop = func(metainfo.Location) bool {
+			return false
+		}
+	}
+
+	t := newTokenizer(source, begin)
+	t.stop = stop
+	t.chunks = []Chunk{{Begin: begin}}
+	t.run()
+
+	if t.stopped {
+		// the chunk stop was called with is left untokenized
+		return t.chunks[:len(t.chunks)-1]
+	}
+	t.closeChunk(len(t.tokens), len(t.source))
+	return t.chunks
+}
+
+// closeChunk ends the last chunk before the token at index end, and the
+// error at offset errorEnd
+func (t *tokenizer) closeChunk(end, errorEnd int) {
+	c := &t.chunks[len(t.chunks)-1]
+	c.Tokens = t.tokens[t.chunkStart:end:end]
+	c.Errors = []Error{}
+	for t.errorStart < len(t.errors) && t.errors[t.errorStart].Offset < errorEnd {
+		c.Errors = append(c.Errors, t.errors[t.errorStart])
+		t.errorStart++
+	}
+	t.chunkStart = end
+}
+
+// endChunk is called with the first token after a top level statement. The
+// statement ends the chunk, unless it goes on with 'else', or compiler
+// annotations from its last line are attached to the token.
+func (t *tokenizer) endChunk(next Token) {
+	ended := t.ended
+	t.ended = -1
+	if next.Type == ELSE {
+		return
+	}
+
+	last := ended - 1
+	for last > 0 && t.tokens[last].IsMeta() {
+		last--
+	}
+
+	// the next chunk begins at the line after the statement
+	begin := t.location(t.tokens[last].End.Row+1, 1, t.tokens[last].stop)
+	for begin.Offset < len(t.source) && t.source[begin.Offset] != '\n' && t.source[begin.Offset] != '\r' {
+		begin.Offset++
+	}
+	if begin.Offset+1 < len(t.source) && t.source[begin.Offset] == '\r' && t.source[begin.Offset+1] == '\n' {
+		begin.Offset++
+	}
+	begin.Offset++
+	begin.Pos = t.pos(begin.Offset)
+
+	for _, a := range next.Annotations {
+		if a.Begin.Offset < begin.Offset {
+			return
+		}
+	}
+
+	t.closeChunk(ended, begin.Offset)
+	t.chunks = append(t.chunks, Chunk{Begin: begin})
+	if t.stop(begin) {
+		t.stopped = true
+		t.tokens = t.tokens[:ended]
+	}
+}
@@ -0,0 +1,33 @@
+package tokenizer
+
+import (
+	"fmt"
+
+	"github.com/kvarenzn/pinecone/metainfo"
+)
+
+// Error is a piece of source text which cannot be tokenized. The tokenizer
+// reports it and goes on with the text after it.
+type Error struct {
+	Row int
+	Col int
+	// byte offset of the error in the source
+	Offset int
+	// position of the error, see metainfo.Location
+	Pos metainfo.Pos
+	Msg string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Row, e.Col, e.Msg)
+}
+
+func (t *tokenizer) errorAt(row, col, offset int, format string, args ...any) {
+	t.errors = append(t.errors, Error{
+		Row:    row,
+		Col:    col,
+		Offset: offset,
+		Pos:    t.pos(offset),
+		Msg:    fmt.Sprintf(format, args...),
+	})
+}
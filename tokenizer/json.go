@@ -0,0 +1,120 @@
+package tokenizer
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/kvarenzn/pinecone/metainfo"
+)
+
+func (t TokenType) MarshalText() ([]byte, error) {
+	name, ok := TOKEN_TYPE_NAMES[t]
+	if !ok {
+		return nil, fmt.Errorf("unknown token type %d", t)
+	}
+	return []byte(name), nil
+}
+
+func (t *TokenType) UnmarshalText(text []byte) error {
+	for tt, name := range TOKEN_TYPE_NAMES {
+		if name == string(text) {
+			*t = tt
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown token type %q", text)
+}
+
+var triviaKindNames = map[TriviaKind]string{
+	WhitespaceTrivia: "whitespace",
+	NewlineTrivia:    "newline",
+	CommentTrivia:    "comment",
+}
+
+func (k TriviaKind) MarshalText() ([]byte, error) {
+	name, ok := triviaKindNames[k]
+	if !ok {
+		return nil, fmt.Errorf("unknown trivia kind %d", k)
+	}
+	return []byte(name), nil
+}
+
+func (k *TriviaKind) UnmarshalText(text []byte) error {
+	for kind, name := range triviaKindNames {
+		if name == string(text) {
+			*k = kind
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown trivia kind %q", text)
+}
+
+type jsonTrivia struct {
+	Kind TriviaKind `json:"kind"`
+	Text string     `json:"text"`
+}
+
+type jsonToken struct {
+	Type        TokenType         `json:"type"`
+	Lexeme      string            `json:"lexeme"`
+	Begin       metainfo.Location `json:"begin"`
+	End         metainfo.Location `json:"end"`
+	Annotations []Token           `json:"annotations,omitempty"`
+	Leading     []jsonTrivia      `json:"leading,omitempty"`
+	Trailing    []jsonTrivia      `json:"trailing,omitempty"`
+}
+
+func toJSONTrivia(trivia []Trivia) []jsonTrivia {
+	if trivia == nil {
+		return nil
+	}
+	result := make([]jsonTrivia, len(trivia))
+	for i, tr := range trivia {
+		result[i] = jsonTrivia(tr)
+	}
+	return result
+}
+
+func fromJSONTrivia(trivia []jsonTrivia) []Trivia {
+	if trivia == nil {
+		return nil
+	}
+	result := make([]Trivia, len(trivia))
+	for i, tr := range trivia {
+		result[i] = Trivia(tr)
+	}
+	return result
+}
+
+func (t Token) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonToken{
+		Type:        t.Type,
+		Lexeme:      t.Lexeme,
+		Begin:       t.Begin,
+		End:         t.End,
+		Annotations: t.Annotations,
+		Leading:     toJSONTrivia(t.Leading),
+		Trailing:    toJSONTrivia(t.Trailing),
+	})
+}
+
+// UnmarshalJSON decodes a token encoded by MarshalJSON. The source offsets
+// of the token are not encoded, so a decoded token can't be used by the
+// lossless printer.
+func (t *Token) UnmarshalJSON(data []byte) error {
+	var jt jsonToken
+	if err := json.Unmarshal(data, &jt); err != nil {
+		return err
+	}
+
+	*t = Token{
+		Type:        jt.Type,
+		Lexeme:      jt.Lexeme,
+		Begin:       jt.Begin,
+		End:         jt.End,
+		Annotations: jt.Annotations,
+		Leading:     fromJSONTrivia(jt.Leading),
+		Trailing:    fromJSONTrivia(jt.Trailing),
+	}
+	return nil
+}
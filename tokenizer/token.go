@@ -2,6 +2,7 @@ package tokenizer
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/kvarenzn/pinecone/metainfo"
 )
@@ -11,14 +12,69 @@ type Token struct {
 	Lexeme string
 	Begin  metainfo.Location
 	End    metainfo.Location
+	// compiler annotations (like '//@param') in the comments before this token
+	Annotations []Token
+	// whitespace, line breaks and comments around this token, only recorded
+	// by TokenizeLossless
+	Leading  []Trivia
+	Trailing []Trivia
+
+	// offsets of the first rune and the rune after the token in the source
+	start int
+	stop  int
 }
 
 func (t Token) String() string {
 	return fmt.Sprintf("<%v: %q>", TOKEN_TYPE_NAMES[t.Type], t.Lexeme)
 }
 
+// FullText returns the lexeme together with its leading and trailing trivia
+func (t Token) FullText() string {
+	return t.LeadingText() + t.Lexeme + t.TrailingText()
+}
+
+// LeadingText returns the text of the leading trivia
+func (t Token) LeadingText() string {
+	return triviaText(t.Leading)
+}
+
+// TrailingText returns the text of the trailing trivia
+func (t Token) TrailingText() string {
+	return triviaText(t.Trailing)
+}
+
+func triviaText(trivia []Trivia) string {
+	var sb strings.Builder
+	for _, tr := range trivia {
+		sb.WriteString(tr.Text)
+	}
+	return sb.String()
+}
+
+// Moved is the token shifted down by a number of rows and bytes, for a token
+// after an edited line. The annotations are copied, the token it is moved
+// from is left as it is.
+func (t Token) Moved(rows, bytes int) Token {
+	t.Begin = t.Begin.Moved(rows, bytes)
+	t.End = t.End.Moved(rows, bytes)
+	t.start += bytes
+	t.stop += bytes
+	if t.Annotations != nil {
+		annotations := make([]Token, len(t.Annotations))
+		for i, a := range t.Annotations {
+			annotations[i] = a.Moved(rows, bytes)
+		}
+		t.Annotations = annotations
+	}
+	return t
+}
+
+func (t Token) IsMeta() bool {
+	return t.Type > metaBegin && t.Type < metaEnd
+}
+
 func (t Token) IsSoftKeyword() bool {
-	return t.Type == TYPE || t.Type == CATCH || t.Type == CLASS || t.Type == DO || t.Type == ELLIPSE || t.Type == IS || t.Type == POLYGON || t.Type == RANGE || t.Type == RETURN || t.Type == STRUCT || t.Type == TEXT || t.Type == THROW || t.Type == TRY
+	return t.Type == TYPE || t.Type == ENUM || t.Type == CATCH || t.Type == CLASS || t.Type == DO || t.Type == ELLIPSE || t.Type == IS || t.Type == POLYGON || t.Type == RANGE || t.Type == RETURN || t.Type == STRUCT || t.Type == TEXT || t.Type == THROW || t.Type == TRY
 }
 
 func (t Token) IsLiteral() bool {
@@ -9,6 +9,8 @@ const (
 	NEWLINE
 	INDENT
 	DEDENT
+	ANNOTATION
+	EOF
 	metaEnd
 
 	delimiterBegin
@@ -58,6 +60,7 @@ const (
 	AS
 	TYPE
 	METHOD
+	ENUM
 	AND
 	OR
 	NOT
@@ -106,6 +109,8 @@ var TOKEN_TYPE_NAMES = map[TokenType]string{
 	NEWLINE:           "NEWLINE",
 	INDENT:            "INDENT",
 	DEDENT:            "DEDENT",
+	ANNOTATION:        "ANNOTATION",
+	EOF:               "EOF",
 	metaEnd:           "metaEnd",
 	delimiterBegin:    "delimiterBegin",
 	LEFT_PAREN:        "LEFT_PAREN",
@@ -150,6 +155,7 @@ var TOKEN_TYPE_NAMES = map[TokenType]string{
 	AS:                "AS",
 	TYPE:              "TYPE",
 	METHOD:            "METHOD",
+	ENUM:              "ENUM",
 	AND:               "AND",
 	OR:                "OR",
 	NOT:               "NOT",
@@ -191,6 +197,7 @@ var KEYWORDS = map[string]TokenType{
 	"as":       AS,
 	"type":     TYPE,
 	"method":   METHOD,
+	"enum":     ENUM,
 	"and":      AND,
 	"or":       OR,
 	"not":      NOT,
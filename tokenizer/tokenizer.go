@@ -1,7 +1,8 @@
 package tokenizer
 
 import (
-	"fmt"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/kvarenzn/pinecone/metainfo"
 )
@@ -23,7 +24,12 @@ func isIdentifierRune(r rune) bool {
 }
 
 type tokenizer struct {
-	source     []rune
+	source string
+	// position of the first byte of the source in its FileSet, NoPos if the
+	// source is not a file of one
+	base metainfo.Pos
+	// byte offsets of the start of the token being scanned, and of the next
+	// rune
 	start      int
 	current    int
 	startRow   int
@@ -32,8 +38,33 @@ type tokenizer struct {
 	currentCol int
 	prevRow    int
 	prevCol    int
+	// byte offset of the rune before current
+	prevOffset int
 	tokens     []Token
+	errors     []Error
 	indents    []int
+	// nesting level of parentheses and square brackets, line breaks inside
+	// them do not end a statement, unless the next line is indented by a
+	// multiple of 4 no deeper than the block around it
+	depth int
+	// row, column and byte offset of the outermost open bracket
+	openRow    int
+	openCol    int
+	openOffset int
+	// annotations waiting for the next token
+	annotations []Token
+
+	// see TokenizeChunks
+	chunks []Chunk
+	// index of the first token and of the first error of the last chunk
+	chunkStart int
+	errorStart int
+	// index of the token after the NEWLINE or DEDENT tokens ending a top
+	// level statement, -1 if the last statement is not ended yet
+	ended int
+	stop  func(next metainfo.Location) bool
+	// set when stop returns true
+	stopped bool
 }
 
 const eof rune = -1
@@ -43,11 +74,17 @@ func (ts tokenizer) eof() bool {
 }
 
 func (ts tokenizer) peek(n int) rune {
-	if ts.current+n >= len(ts.source) {
+	i := ts.current
+	for ; n > 0 && i < len(ts.source); n-- {
+		_, size := utf8.DecodeRuneInString(ts.source[i:])
+		i += size
+	}
+	if i >= len(ts.source) {
 		return eof
 	}
 
-	return ts.source[ts.current+n]
+	r, _ := utf8.DecodeRuneInString(ts.source[i:])
+	return r
 }
 
 func (ts *tokenizer) consume() {
@@ -55,23 +92,25 @@ func (ts *tokenizer) consume() {
 		return
 	}
 
+	r, size := utf8.DecodeRuneInString(ts.source[ts.current:])
 	ts.prevCol = ts.currentCol
 	ts.prevRow = ts.currentRow
+	ts.prevOffset = ts.current
 
-	if ts.peek(0) == '\n' || ts.peek(0) == '\r' && ts.peek(1) != '\n' {
+	if r == '\n' || r == '\r' && ts.peek(1) != '\n' {
 		ts.currentCol = 1
 		ts.currentRow++
 	} else {
 		ts.currentCol++
 	}
 
-	ts.current++
+	ts.current += size
 }
 
 func (ts *tokenizer) advance() rune {
-	pos := ts.current
+	r, _ := utf8.DecodeRuneInString(ts.source[ts.current:])
 	ts.consume()
-	return ts.source[pos]
+	return r
 }
 
 func (ts *tokenizer) match(target rune) bool {
@@ -79,7 +118,7 @@ func (ts *tokenizer) match(target rune) bool {
 		return false
 	}
 
-	if ts.source[ts.current] != target {
+	if ts.peek(0) != target {
 		return false
 	}
 
@@ -88,21 +127,17 @@ func (ts *tokenizer) match(target rune) bool {
 }
 
 func (ts tokenizer) take() string {
-	return string(ts.source[ts.start:ts.current])
+	return ts.source[ts.start:ts.current]
 }
 
 func (ts tokenizer) takeAs(tt TokenType) Token {
 	return Token{
 		Type:   tt,
 		Lexeme: ts.take(),
-		Begin: metainfo.Location{
-			Row:    ts.startRow,
-			Column: ts.startCol,
-		},
-		End: metainfo.Location{
-			Row:    ts.prevRow,
-			Column: ts.prevCol,
-		},
+		Begin:  ts.location(ts.startRow, ts.startCol, ts.start),
+		End:    ts.location(ts.prevRow, ts.prevCol, ts.prevOffset),
+		start:  ts.start,
+		stop:   ts.current,
 	}
 }
 
@@ -113,37 +148,90 @@ func (ts *tokenizer) fastForward() {
 }
 
 func (t *tokenizer) record(tt TokenType) {
-	t.tokens = append(t.tokens, t.takeAs(tt))
+	token := t.takeAs(tt)
+	if !tt.In(NEWLINE, INDENT, DEDENT) && len(t.annotations) > 0 {
+		token.Annotations = t.annotations
+		t.annotations = nil
+	}
+	if t.stop != nil && t.ended >= 0 && !token.IsMeta() {
+		t.endChunk(token)
+		if t.stopped {
+			return
+		}
+	}
+	t.tokens = append(t.tokens, token)
 }
 
+// setCurrentIndent implements the line wrapping rules of pine script: a line
+// indented by a multiple of 4 spaces starts a new statement (or a new block),
+// while any other indentation continues the previous line. Inside
+// parentheses or brackets every line continues the previous one.
 func (t *tokenizer) setCurrentIndent(indent int) {
+	top := t.indents[len(t.indents)-1]
+	if t.depth > 0 {
+		if indent > top || indent%4 != 0 {
+			return
+		}
+		// a line indented by a multiple of 4, but not deeper than the
+		// block around it, starts a new statement, the bracket is never
+		// closed
+		t.errorAt(t.openRow, t.openCol, t.openOffset, "Unclosed bracket")
+		t.depth = 0
+	}
+
 	if indent%4 != 0 {
-		if t.tokens[len(t.tokens)-1].Type == NEWLINE {
+		// the first line has nothing to continue
+		if len(t.tokens) > 0 && t.tokens[len(t.tokens)-1].Type == NEWLINE {
 			t.tokens = t.tokens[:len(t.tokens)-1]
+			if t.ended > len(t.tokens) {
+				t.ended = -1
+			}
 		}
 		return
 	}
 
-	top := t.indents[len(t.indents)-1]
 	if indent > top {
 		t.indents = append(t.indents, indent)
 		t.record(INDENT)
 		return
 	} else if indent < top {
-		for i, v := range t.indents {
-			if v == indent {
-				for j := i + 1; j < len(t.indents); j++ {
-					t.record(DEDENT)
-				}
-				t.indents = t.indents[:i+1]
-				return
-			}
+		// a line dedented to a level no block is indented by closes the
+		// blocks indented deeper than it
+		i := len(t.indents) - 1
+		for t.indents[i] > indent {
+			i--
+		}
+		if t.indents[i] != indent {
+			t.errorAt(t.currentRow, t.currentCol, t.current, "Invalid indent")
 		}
-		panic("Invalid Indent")
+		for j := i + 1; j < len(t.indents); j++ {
+			t.record(DEDENT)
+		}
+		t.indents = t.indents[:i+1]
+		if i == 0 {
+			t.ended = len(t.tokens)
+		}
+		return
 	}
 
 	if len(t.tokens) > 0 {
 		t.record(NEWLINE)
+		if len(t.indents) == 1 {
+			t.ended = len(t.tokens)
+		}
+	}
+}
+
+func (t *tokenizer) openBracket() {
+	if t.depth == 0 {
+		t.openRow, t.openCol, t.openOffset = t.startRow, t.startCol, t.start
+	}
+	t.depth++
+}
+
+func (t *tokenizer) closeBracket() {
+	if t.depth > 0 {
+		t.depth--
 	}
 }
 
@@ -161,9 +249,8 @@ func (t *tokenizer) scanIndent() {
 			t.advance()
 		case '/':
 			if t.peek(1) == '/' {
-				for t.peek(0) != '\r' && t.peek(0) != '\n' && !t.eof() {
-					t.advance()
-				}
+				t.fastForward()
+				t.scanComment()
 				return
 			}
 			t.setCurrentIndent(indent)
@@ -177,21 +264,83 @@ func (t *tokenizer) scanIndent() {
 	}
 }
 
+// scanComment skips a comment, which starts at the current position or one
+// rune before it. Compiler annotations like '//@version=5' are kept, and
+// attached to the next token.
+func (t *tokenizer) scanComment() {
+	for t.peek(0) != '\n' && t.peek(0) != '\r' && !t.eof() {
+		t.advance()
+	}
+
+	if strings.HasPrefix(t.take(), "//@") {
+		t.annotations = append(t.annotations, t.takeAs(ANNOTATION))
+	}
+}
+
 func (t *tokenizer) atStart() rune {
-	return t.source[t.start]
+	r, _ := utf8.DecodeRuneInString(t.source[t.start:])
+	return r
+}
+
+// continuesLine reports whether the line after the line break at the
+// current position is a wrapped line
+func (t *tokenizer) continuesLine() bool {
+	i := 0
+	if t.peek(0) == '\r' && t.peek(1) == '\n' {
+		i++
+	}
+	i++
+
+	indent := 0
+	for {
+		switch t.peek(i) {
+		case ' ':
+			indent++
+		case '\t':
+			indent += 4
+		default:
+			if t.depth > 0 && indent > t.indents[len(t.indents)-1] {
+				return true
+			}
+			return indent%4 != 0
+		}
+		i++
+	}
 }
 
+// scanString scans a string literal. A string ends on the line it begins,
+// unless the line is wrapped, in which case the string goes on in the next
+// line. Unterminated strings are reported at their opening quote, and end
+// at the end of the line.
 func (t *tokenizer) scanString() {
-	start := t.atStart()
+	quote := t.atStart()
 	for !t.eof() {
-		r := t.advance()
-		if r == start {
+		switch t.peek(0) {
+		case quote:
+			t.advance()
 			t.record(STRING)
-			break
-		} else if r == '\\' {
+			return
+		case '\\':
+			t.advance()
+			if t.peek(0) != '\n' && t.peek(0) != '\r' {
+				t.advance()
+			}
+		case '\n', '\r':
+			if !t.continuesLine() {
+				t.unterminatedString()
+				return
+			}
+			t.advance()
+		default:
 			t.advance()
 		}
 	}
+	t.unterminatedString()
+}
+
+func (t *tokenizer) unterminatedString() {
+	t.errorAt(t.startRow, t.startCol, t.start, "Unterminated string")
+	t.record(STRING)
 }
 
 func (t *tokenizer) scanColor() {
@@ -203,6 +352,7 @@ func (t *tokenizer) scanColor() {
 		}
 		t.advance()
 	}
+	t.record(COLOR)
 }
 
 func (t *tokenizer) scanNumber() {
@@ -252,12 +402,16 @@ func (t *tokenizer) scanToken() {
 	r := t.advance()
 	switch r {
 	case '(':
+		t.openBracket()
 		t.record(LEFT_PAREN)
 	case ')':
+		t.closeBracket()
 		t.record(RIGHT_PAREN)
 	case '[':
+		t.openBracket()
 		t.record(LEFT_SQ_BRACKET)
 	case ']':
+		t.closeBracket()
 		t.record(RIGHT_SQ_BRACKET)
 	case '<':
 		if t.match('=') {
@@ -314,10 +468,8 @@ func (t *tokenizer) scanToken() {
 			t.record(STAR)
 		}
 	case '/':
-		if t.match('/') {
-			for t.peek(0) != '\n' && t.peek(0) != '\r' && !t.eof() {
-				t.advance()
-			}
+		if t.peek(0) == '/' {
+			t.scanComment()
 		} else if t.match('=') {
 			t.record(SLASH_EQUAL)
 		} else {
@@ -350,23 +502,43 @@ func (t *tokenizer) scanToken() {
 			t.scanIdentifier()
 			return
 		}
-		panic(fmt.Sprintf("Unexpected character: %#U", r))
+		t.errorAt(t.startRow, t.startCol, t.start, "Unexpected character: %#U", r)
 	}
 }
 
-func Tokenize(source string) []Token {
-	t := &tokenizer{
-		source:     []rune(source),
-		start:      0,
-		current:    0,
-		startRow:   1,
+// newTokenizer makes a tokenizer starting at a location of the source, the
+// tokens have positions if the location has one
+func newTokenizer(source string, begin metainfo.Location) *tokenizer {
+	base := metainfo.NoPos
+	if begin.Pos.IsValid() {
+		base = begin.Pos - metainfo.Pos(begin.Offset)
+	}
+	return &tokenizer{
+		source:     source,
+		base:       base,
+		start:      begin.Offset,
+		current:    begin.Offset,
+		startRow:   begin.Row,
 		startCol:   1,
-		prevRow:    1,
+		prevRow:    begin.Row,
 		prevCol:    1,
-		currentRow: 1,
+		prevOffset: begin.Offset,
+		currentRow: begin.Row,
 		currentCol: 1,
 		tokens:     []Token{},
 		indents:    []int{0},
+		ended:      -1,
+	}
+}
+
+// byte order mark, which some editors put at the beginning of a file
+const bom = "\uFEFF"
+
+func (t *tokenizer) run() {
+	if t.current == 0 && strings.HasPrefix(t.source, bom) {
+		// the mark is not a character of the first line
+		t.current += len(bom)
+		t.prevOffset = t.current
 	}
 
 	if !t.eof() {
@@ -374,13 +546,69 @@ func Tokenize(source string) []Token {
 		t.scanIndent()
 	}
 
-	for !t.eof() {
+	for !t.eof() && !t.stopped {
 		t.fastForward()
 		t.scanToken()
 	}
+	if t.stopped {
+		return
+	}
 
 	t.fastForward()
 	t.setCurrentIndent(0)
+	if len(t.annotations) > 0 {
+		// annotations after the last statement are kept on an EOF token
+		end := t.location(t.currentRow, t.currentCol, t.current)
+		t.tokens = append(t.tokens, Token{
+			Type:        EOF,
+			Begin:       end,
+			End:         end,
+			Annotations: t.annotations,
+			start:       t.current,
+			stop:        t.current,
+		})
+		t.annotations = nil
+	}
+}
+
+func (t tokenizer) pos(offset int) metainfo.Pos {
+	if !t.base.IsValid() {
+		return metainfo.NoPos
+	}
+	return t.base + metainfo.Pos(offset)
+}
+
+func (t tokenizer) location(row, col, offset int) metainfo.Location {
+	return metainfo.Location{
+		Row:    row,
+		Column: col,
+		Offset: offset,
+		Pos:    t.pos(offset),
+	}
+}
+
+func tokenize(source string, base metainfo.Pos) *tokenizer {
+	t := newTokenizer(source, metainfo.Location{
+		Row:    1,
+		Column: 1,
+		Pos:    base,
+	})
+	t.run()
+	return t
+}
+
+// Tokenize splits a script into tokens. Text which cannot be tokenized is
+// reported as errors and skipped. The tokens end with an EOF token only if
+// there are compiler annotations after the last statement, which it holds.
+func Tokenize(source string) ([]Token, []Error) {
+	t := tokenize(source, metainfo.NoPos)
+	return t.tokens, t.errors
+}
 
-	return t.tokens
+// TokenizeFile tokenizes the source of a file like Tokenize, the locations
+// of the tokens and of the errors hold their positions in the FileSet of
+// the file
+func TokenizeFile(f *metainfo.File) ([]Token, []Error) {
+	t := tokenize(string(f.Source()), f.Pos(0))
+	return t.tokens, t.errors
 }
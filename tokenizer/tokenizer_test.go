@@ -0,0 +1,135 @@
+package tokenizer
+
+import (
+	"strings"
+	"testing"
+)
+
+// layout renders the tokens of a script, with the tokens delimiting
+// statements and blocks spelled out
+func layout(tokens []Token) string {
+	parts := []string{}
+	for _, token := range tokens {
+		switch token.Type {
+		case NEWLINE:
+			parts = append(parts, "NL")
+		case INDENT:
+			parts = append(parts, "IN")
+		case DEDENT:
+			parts = append(parts, "DE")
+		default:
+			parts = append(parts, token.Lexeme)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestWrappedLines(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			"wrapped call",
+			"plot(close,\n    color = red,\n  title = 'x')\ny = 1\n",
+			"plot ( close , color = red , title = 'x' ) NL y = 1 NL",
+		},
+		{
+			"closing parenthesis on its own line",
+			"plot(close,\n     color = red\n     )\n",
+			"plot ( close , color = red ) NL",
+		},
+		{
+			"wrapped call in a block",
+			"if c\n    plot(close,\n         title = 'x')\n    y = 1\n",
+			"if c IN plot ( close , title = 'x' ) NL y = 1 DE",
+		},
+		{
+			"wrapped array",
+			"a = [1,\n     2,\n    3]\nb = a\n",
+			"a = [ 1 , 2 , 3 ] NL b = a NL",
+		},
+		{
+			"wrapped expression",
+			"a = 1 +\n  2\nb = a\n",
+			"a = 1 + 2 NL b = a NL",
+		},
+	}
+
+	for _, test := range tests {
+		tokens, errs := Tokenize(test.src)
+		if len(errs) > 0 {
+			t.Errorf("%s: %v", test.name, errs)
+		}
+		if got := layout(tokens); got != test.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestUnclosedBracket(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+		row  int
+		col  int
+	}{
+		{
+			"statement after an unclosed call",
+			"plot(close,\ny = 1\nz = 2\n",
+			"plot ( close , NL y = 1 NL z = 2 NL",
+			1, 5,
+		},
+		{
+			"statement after an unclosed array in a block",
+			"if c\n    a = [1, 2\n    b = 1\nz = 2\n",
+			"if c IN a = [ 1 , 2 NL b = 1 DE z = 2 NL",
+			2, 9,
+		},
+	}
+
+	for _, test := range tests {
+		tokens, errs := Tokenize(test.src)
+		if got := layout(tokens); got != test.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", test.name, got, test.want)
+		}
+		if len(errs) != 1 || errs[0].Msg != "Unclosed bracket" || errs[0].Row != test.row || errs[0].Col != test.col {
+			t.Errorf("%s: got %v, want an unclosed bracket at %d:%d", test.name, errs, test.row, test.col)
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+		// the position of the unterminated string, if any
+		row, col int
+	}{
+		{"escaped quote", `a = "x\"y" + 'z'`, `a = "x\"y" + 'z' NL`, 0, 0},
+		{"quotes of the other kind", `a = 'x"y'`, `a = 'x"y' NL`, 0, 0},
+		{"escaped backslash", `a = "x\\"`, `a = "x\\" NL`, 0, 0},
+		{"wrapped string", "f(\"x\n   y\")", "f ( \"x\n   y\" ) NL", 0, 0},
+		{"unterminated string", "a = \"xy\nb = 1", "a = \"xy NL b = 1 NL", 1, 5},
+		{"escaped last quote", "a = 'xy\\'\nb = 1", "a = 'xy\\' NL b = 1 NL", 1, 5},
+	}
+
+	for _, test := range tests {
+		tokens, errs := Tokenize(test.src + "\n")
+		if got := layout(tokens); got != test.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", test.name, got, test.want)
+		}
+		if test.row == 0 {
+			if len(errs) > 0 {
+				t.Errorf("%s: %v", test.name, errs)
+			}
+			continue
+		}
+		if len(errs) != 1 || errs[0].Msg != "Unterminated string" || errs[0].Row != test.row || errs[0].Col != test.col {
+			t.Errorf("%s: got %v, want an unterminated string at %d:%d", test.name, errs, test.row, test.col)
+		}
+	}
+}
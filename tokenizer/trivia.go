@@ -0,0 +1,133 @@
+package tokenizer
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kvarenzn/pinecone/metainfo"
+)
+
+type TriviaKind byte
+
+const (
+	WhitespaceTrivia TriviaKind = iota
+	NewlineTrivia
+	CommentTrivia
+)
+
+// Trivia is a piece of source text that carries no meaning for the parser
+type Trivia struct {
+	Kind TriviaKind
+	Text string
+}
+
+func splitTrivia(text string) []Trivia {
+	trivia := []Trivia{}
+	for i := 0; i < len(text); {
+		_, size := utf8.DecodeRuneInString(text[i:])
+		j := i + size
+		switch r := text[i]; {
+		case r == '\r' || r == '\n':
+			if r == '\r' && j < len(text) && text[j] == '\n' {
+				j++
+			}
+			trivia = append(trivia, Trivia{
+				Kind: NewlineTrivia,
+				Text: text[i:j],
+			})
+		case r == '/':
+			for j < len(text) && text[j] != '\r' && text[j] != '\n' {
+				j++
+			}
+			trivia = append(trivia, Trivia{
+				Kind: CommentTrivia,
+				Text: text[i:j],
+			})
+		default:
+			for j < len(text) && strings.IndexByte(" \t\f", text[j]) >= 0 {
+				j++
+			}
+			trivia = append(trivia, Trivia{
+				Kind: WhitespaceTrivia,
+				Text: text[i:j],
+			})
+		}
+		i = j
+	}
+	return trivia
+}
+
+// TokenizeLossless works like Tokenize, but also records every whitespace,
+// line break and comment as trivia of the tokens around it, so that the
+// source can be rebuilt byte by byte with Untokenize.
+//
+// A token owns the trivia up to the end of its line as trailing trivia, the
+// rest belongs to the next token as leading trivia. The text of NEWLINE,
+// INDENT and DEDENT tokens is carried by trivia too, so their lexemes are
+// empty. The last token is always an EOF token, which holds the trivia at
+// the end of the source. Text skipped because of an error is kept as trivia
+// too.
+//
+// The syntax tree parsed from the tokens holds no trivia. Tools which
+// rewrite a script without losing its formatting parse it with
+// parser.ParseLossless, which maps the nodes to their tokens, and print the
+// changed tree with format.Tree.
+func TokenizeLossless(source string) ([]Token, []Error) {
+	t := tokenize(source, metainfo.NoPos)
+
+	tokens := []Token{}
+	prev := -1
+	prevStop := 0
+	var annotations []Token
+	for _, token := range t.tokens {
+		if token.Type == EOF {
+			annotations = token.Annotations
+			continue
+		}
+		if token.IsMeta() {
+			token.Lexeme = ""
+			tokens = append(tokens, token)
+			continue
+		}
+
+		token.Leading = splitLeading(tokens, prev, t.source[prevStop:token.start])
+		prev = len(tokens)
+		prevStop = token.stop
+		tokens = append(tokens, token)
+	}
+
+	end := t.location(t.currentRow, t.currentCol, t.current)
+	return append(tokens, Token{
+		Type:        EOF,
+		Begin:       end,
+		End:         end,
+		Annotations: annotations,
+		Leading:     splitLeading(tokens, prev, t.source[prevStop:]),
+		start:       len(t.source),
+		stop:        len(t.source),
+	}), t.errors
+}
+
+// splitLeading gives the trivia up to the end of the line of the previous
+// token, if any, to that token, and returns the rest
+func splitLeading(tokens []Token, prev int, text string) []Trivia {
+	trivia := splitTrivia(text)
+	if prev < 0 {
+		return trivia
+	}
+	trailing := 0
+	for trailing < len(trivia) && trivia[trailing].Kind != NewlineTrivia {
+		trailing++
+	}
+	tokens[prev].Trailing = trivia[:trailing]
+	return trivia[trailing:]
+}
+
+// Untokenize rebuilds the source from the tokens of TokenizeLossless
+func Untokenize(tokens []Token) string {
+	var sb strings.Builder
+	for _, token := range tokens {
+		sb.WriteString(token.FullText())
+	}
+	return sb.String()
+}
@@ -0,0 +1,50 @@
+package tokenizer
+
+import "testing"
+
+func TestLosslessRoundTrip(t *testing.T) {
+	sources := []string{
+		"",
+		"\n\n",
+		"// only a comment",
+		"//@version=5\nindicator(\"x\")\nplot(close) // trailing\n",
+		"//@version=5\r\nx = 1\r\n\r\n// between\r\ny = x\r\n",
+		"\uFEFF//@version=5\nx = 1",
+		"//@version=5\nf(x) =>\n    // in a block\n    y = x * 2\n\n    y\n\nplot(f(1),\n     color = #ff0080,  // wrapped\n     title = 'é')\n",
+		"x = 1\t+\t2   \n   \n",
+		// text skipped because of errors
+		"x = \"unterminated\ny = 1\n",
+		"x = 1 ¤ 2\n",
+		"if x\n   y = 1\n",
+		"//@version=5\nx = 1\n//@variable at the end\n",
+	}
+
+	for _, src := range sources {
+		tokens, _ := TokenizeLossless(src)
+		if got := Untokenize(tokens); got != src {
+			t.Errorf("%q: rebuilt as %q", src, got)
+		}
+		if n := len(tokens); n == 0 || tokens[n-1].Type != EOF {
+			t.Errorf("%q: the tokens do not end with EOF", src)
+		}
+	}
+}
+
+func TestRewriteThroughTokens(t *testing.T) {
+	src := "//@version=5\nlength = 14 // the length\nplot(ta.sma(close, length))\n"
+	tokens, errs := TokenizeLossless(src)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	// rename 'length', keeping the comment and the layout
+	for i := range tokens {
+		if tokens[i].Type == IDENTIFIER && tokens[i].Lexeme == "length" {
+			tokens[i].Lexeme = "len"
+		}
+	}
+	want := "//@version=5\nlen = 14 // the length\nplot(ta.sma(close, len))\n"
+	if got := Untokenize(tokens); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,25 @@
+package tokenizer
+
+import (
+	"strings"
+
+	"github.com/kvarenzn/pinecone/metainfo"
+)
+
+// DetectVersion finds the '//@version' annotation of a script, and returns
+// metainfo.DefaultVersion if there is none
+func DetectVersion(tokens []Token) (metainfo.LanguageVersion, bool) {
+	for _, token := range tokens {
+		for _, a := range token.Annotations {
+			value, ok := strings.CutPrefix(a.Lexeme, "//@version=")
+			if !ok {
+				continue
+			}
+			if v, ok := metainfo.ParseLanguageVersion(strings.TrimSpace(value)); ok {
+				return v, true
+			}
+			return metainfo.DefaultVersion, false
+		}
+	}
+	return metainfo.DefaultVersion, false
+}
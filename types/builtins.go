@@ -67,11 +67,19 @@ type TypeWithName struct {
 	Name     string
 	Type     Type
 	Optional bool
+	// the default value of an optional parameter as it is written, if known
+	Default string
 }
 type structType struct {
 	BaseType
+	name   string
 	fields []TypeWithName
 }
+type enumType struct {
+	BaseType
+	name   string
+	fields []string
+}
 type tupleType struct {
 	BaseType
 	items []Type
@@ -117,12 +125,30 @@ func MatrixOf(unit Type) Type {
 	}
 }
 
-func StructOf(fields []TypeWithName) Type {
-	return structType{
+func StructOf(name string, fields []TypeWithName) Type {
+	return &structType{
+		name:   name,
+		fields: fields,
+	}
+}
+
+// EnumOf creates a user defined enum, each enum is a distinct type like the
+// structs made by StructOf
+func EnumOf(name string, fields []string) Type {
+	return &enumType{
+		name:   name,
 		fields: fields,
 	}
 }
 
+// SetFields fills the fields of a struct created by StructOf, which allows
+// a struct to have fields of its own type
+func SetFields(st Type, fields []TypeWithName) {
+	if s, ok := Peel(st).(*structType); ok {
+		s.fields = fields
+	}
+}
+
 func TupleOf(items []Type) Type {
 	return tupleType{
 		items: items,
@@ -213,6 +239,10 @@ func (s structType) Kind() TypeKind {
 	return StructKind
 }
 
+func (e enumType) Kind() TypeKind {
+	return EnumKind
+}
+
 func (t tupleType) Kind() TypeKind {
 	return TupleKind
 }
@@ -290,11 +320,20 @@ func (m matrixType) String() string {
 	return fmt.Sprintf("matrix<%s>", m.unit)
 }
 
+// String renders a parameter or a field like in a signature, parameters
+// without a type are written with their names only
 func (twn TypeWithName) String() string {
-	return fmt.Sprintf("%s %s", twn.Type, twn.Name)
+	typ := ""
+	if twn.Type != nil && twn.Type.Kind() != UncertainKind {
+		typ = twn.Type.String()
+	}
+	return formatParam(twn.Name, typ, twn.Optional, twn.Default)
 }
 
 func (s structType) String() string {
+	if s.name != "" {
+		return s.name
+	}
 	fs := []string{}
 	for _, f := range s.fields {
 		fs = append(fs, f.String())
@@ -302,6 +341,10 @@ func (s structType) String() string {
 	return fmt.Sprintf("{%s}", strings.Join(fs, "; "))
 }
 
+func (e enumType) String() string {
+	return e.name
+}
+
 func (t tupleType) String() string {
 	is := []string{}
 	for _, i := range t.items {
@@ -311,11 +354,7 @@ func (t tupleType) String() string {
 }
 
 func (f functionType) String() string {
-	ins := []string{}
-	for _, i := range f.in {
-		ins = append(ins, i.String())
-	}
-	return fmt.Sprintf("(%s) => %s", strings.Join(ins, ", "), f.out.String())
+	return Signature("", f)
 }
 
 // containers
@@ -357,6 +396,10 @@ func (st structType) Count() int {
 	return len(st.fields)
 }
 
+func (e enumType) Count() int {
+	return len(e.fields)
+}
+
 func (tt tupleType) Count() int {
 	return len(tt.items)
 }
@@ -395,6 +438,37 @@ func (st structType) FieldByName(name string) *TypeWithName {
 	return nil
 }
 
+// the fields of an enum are values of the enum itself
+func (e *enumType) Fields() []TypeWithName {
+	fields := []TypeWithName{}
+	for _, f := range e.fields {
+		fields = append(fields, TypeWithName{
+			Name: f,
+			Type: e,
+		})
+	}
+	return fields
+}
+
+func (e *enumType) Field(i int) TypeWithName {
+	return TypeWithName{
+		Name: e.fields[i],
+		Type: e,
+	}
+}
+
+func (e *enumType) FieldByName(name string) *TypeWithName {
+	for _, f := range e.fields {
+		if f == name {
+			return &TypeWithName{
+				Name: f,
+				Type: e,
+			}
+		}
+	}
+	return nil
+}
+
 // tuple
 func (bt BaseType) Items() []Type {
 	panic("not applicable")
@@ -1,10 +1,16 @@
 package types
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/kvarenzn/pinecone/metainfo"
+)
 
 type Callable interface {
 	Call(args []any) (any, error)
-	Dispatch(args []Type, kwargs map[string]Type) (Type, error)
+	// Dispatch checks the arguments of a call with the conversion rules of
+	// a language version, and gives the type of the result
+	Dispatch(version metainfo.LanguageVersion, args []Type, kwargs map[string]Type) (Type, error)
 	IsMethod() bool
 	FirstArgType() Type
 }
@@ -13,16 +19,28 @@ type BuiltinFunction struct {
 	Name     string
 	Function func(args ...any) (any, error)
 	Types    []Type
-	OutType  func(args []Type, kwargs map[string]Type) (Type, error)
+	OutType  func(version metainfo.LanguageVersion, args []Type, kwargs map[string]Type) (Type, error)
 	SelfType Type
 	Method   bool
+	// functions like 'label.new' or 'strategy.entry' change the state of
+	// the chart or the strategy when called, and so do user defined
+	// functions calling them
+	SideEffect bool
 }
 
 func (bf BuiltinFunction) Call(args []any) (any, error) {
 	return bf.Function(args...)
 }
 
-func matchArgumentType(argTypes []TypeWithName, args []Type, kwargs map[string]Type) bool {
+func acceptArgument(version metainfo.LanguageVersion, param, arg Type) bool {
+	if param.Kind() == UncertainKind {
+		// parameters declared without a type accept any argument
+		return true
+	}
+	return Equal(param, arg) || CanDoImplicitConversionIn(version, arg, param)
+}
+
+func matchArgumentType(version metainfo.LanguageVersion, argTypes []TypeWithName, args []Type, kwargs map[string]Type) bool {
 	argc := len(argTypes)
 	if argc < len(args)+len(kwargs) {
 		return false
@@ -30,7 +48,7 @@ func matchArgumentType(argTypes []TypeWithName, args []Type, kwargs map[string]T
 
 	remainIndex := 0
 	for i, a := range args {
-		if !Equal(argTypes[i].Type, a) && !CanDoImplicitConversion(a, argTypes[i].Type) {
+		if !acceptArgument(version, argTypes[i].Type, a) {
 			return false
 		}
 		remainIndex++
@@ -47,7 +65,7 @@ func matchArgumentType(argTypes []TypeWithName, args []Type, kwargs map[string]T
 		if !ok {
 			return false
 		}
-		if !Equal(req.Type, v) && !CanDoImplicitConversion(v, req.Type) {
+		if !acceptArgument(version, req.Type, v) {
 			return false
 		}
 
@@ -63,9 +81,9 @@ func matchArgumentType(argTypes []TypeWithName, args []Type, kwargs map[string]T
 	return true
 }
 
-func (bf BuiltinFunction) Dispatch(args []Type, kwargs map[string]Type) (Type, error) {
+func (bf BuiltinFunction) Dispatch(version metainfo.LanguageVersion, args []Type, kwargs map[string]Type) (Type, error) {
 	if bf.OutType != nil {
-		return bf.OutType(args, kwargs)
+		return bf.OutType(version, args, kwargs)
 	}
 
 	if len(bf.Types) == 0 {
@@ -74,7 +92,7 @@ func (bf BuiltinFunction) Dispatch(args []Type, kwargs map[string]Type) (Type, e
 
 	for _, a := range bf.Types {
 		allIn := a.AllIn()
-		if matchArgumentType(allIn, args, kwargs) {
+		if matchArgumentType(version, allIn, args, kwargs) {
 			return a.Out(), nil
 		}
 	}
@@ -82,6 +100,10 @@ func (bf BuiltinFunction) Dispatch(args []Type, kwargs map[string]Type) (Type, e
 	return nil, fmt.Errorf("mismatch argument type %v, %v", args, kwargs)
 }
 
+func (bf BuiltinFunction) HasSideEffect() bool {
+	return bf.SideEffect
+}
+
 func (bf BuiltinFunction) IsMethod() bool {
 	return bf.Method == true
 }
@@ -112,6 +134,62 @@ func (bf BuiltinFunction) FirstArgType() Type {
 	return selfType
 }
 
+// MethodAccepts reports whether the method c can be called on a value of
+// type self.
+func MethodAccepts(version metainfo.LanguageVersion, c Callable, self Type) bool {
+	if !c.IsMethod() {
+		return false
+	}
+
+	if gf, ok := c.(GenericFunction); ok {
+		return gf.acceptsSelf(version, self)
+	}
+
+	bf, ok := c.(BuiltinFunction)
+	if !ok || bf.SelfType != nil {
+		return Equal(self, c.FirstArgType())
+	}
+
+	for _, fnt := range bf.Types {
+		if fnt.Count() > 0 && acceptArgument(version, fnt.In(0).Type, self) {
+			return true
+		}
+	}
+	return false
+}
+
+// BoundMethod is a method with its receiver already given, as in 'obj.method'.
+type BoundMethod struct {
+	Method Callable
+	Self   Type
+}
+
+// Call expects the receiver to be passed as the first argument
+func (bm BoundMethod) Call(args []any) (any, error) {
+	return bm.Method.Call(args)
+}
+
+func (bm BoundMethod) Dispatch(version metainfo.LanguageVersion, args []Type, kwargs map[string]Type) (Type, error) {
+	return bm.Method.Dispatch(version, append([]Type{bm.Self}, args...), kwargs)
+}
+
+func (bm BoundMethod) HasSideEffect() bool {
+	return HasSideEffect(bm.Method)
+}
+
+// HasSideEffect reports whether calling c changes anything but its result
+func HasSideEffect(c Callable) bool {
+	se, ok := c.(interface{ HasSideEffect() bool })
+	return ok && se.HasSideEffect()
+}
+
+func (bm BoundMethod) IsMethod() bool {
+	return false
+}
+
+func (bm BoundMethod) FirstArgType() Type {
+	return nil
+}
 
 func CallableTypeWrap(callable Callable) CallableType {
 	return CallableType{
@@ -129,5 +207,25 @@ func (ct CallableType) Kind() TypeKind {
 }
 
 func (ct CallableType) String() string {
-	return "callable"
+	if bm, ok := ct.Callable.(BoundMethod); ok {
+		return CallableTypeWrap(bm.Method).String()
+	}
+	if gf, ok := ct.Callable.(GenericFunction); ok && len(gf.Overloads) > 0 {
+		s := gf.Overloads[0].String()
+		if len(gf.Overloads) > 1 {
+			s += fmt.Sprintf(" (+%d overloads)", len(gf.Overloads)-1)
+		}
+		return s
+	}
+
+	bf, ok := ct.Callable.(BuiltinFunction)
+	if !ok || len(bf.Types) == 0 {
+		return "callable"
+	}
+
+	s := Signature(bf.Name, bf.Types[0])
+	if len(bf.Types) > 1 {
+		s += fmt.Sprintf(" (+%d overloads)", len(bf.Types)-1)
+	}
+	return s
 }
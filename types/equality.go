@@ -54,6 +54,15 @@ func Equal(type1, type2 Type) bool {
 	case MapKind:
 		return Equal(type1.Key(), type2.Key()) && Equal(type1.Value(), type2.Value())
 	case StructKind:
+		// user defined types are nominal, a type is only equal to itself,
+		// not to a type of the same name declared elsewhere, like in a
+		// library
+		s1, ok1 := Peel(type1).(*structType)
+		s2, ok2 := Peel(type2).(*structType)
+		if ok1 && ok2 && (s1.name != "" || s2.name != "") {
+			return s1 == s2
+		}
+
 		count := type1.Count()
 		if count != type2.Count() {
 			return false
@@ -65,6 +74,10 @@ func Equal(type1, type2 Type) bool {
 			}
 		}
 		return true
+	case EnumKind:
+		e1, ok1 := Peel(type1).(*enumType)
+		e2, ok2 := Peel(type2).(*enumType)
+		return ok1 && ok2 && e1 == e2
 	case TupleKind:
 		count := type1.Count()
 		if count != type2.Count() {
@@ -95,6 +108,8 @@ func Equal(type1, type2 Type) bool {
 		}
 
 		return true
+	case TypeVarKind:
+		return type1.String() == type2.String()
 	case UnionKind:
 		return UnionEqual(type1.Members(), type2.Members())
 	}
@@ -0,0 +1,30 @@
+package types
+
+import "testing"
+
+func TestUserDefinedTypesAreNominal(t *testing.T) {
+	fields := []TypeWithName{{Name: "x", Type: Float}}
+	point := StructOf("Point", fields)
+	// a type of the same name, like one of a library
+	other := StructOf("Point", fields)
+
+	if !Equal(point, point) || !Equal(ArrayOf(point), ArrayOf(point)) {
+		t.Errorf("a type is not equal to itself")
+	}
+	if Equal(point, other) || Equal(ArrayOf(point), ArrayOf(other)) {
+		t.Errorf("types of the same name declared twice are equal")
+	}
+
+}
+
+func TestEnumsAreNominal(t *testing.T) {
+	dir := EnumOf("Direction", []string{"up", "down"})
+	other := EnumOf("Direction", []string{"up", "down"})
+
+	if !Equal(dir, dir) || !Equal(MapOf(dir, Int), MapOf(dir, Int)) {
+		t.Errorf("an enum is not equal to itself")
+	}
+	if Equal(dir, other) {
+		t.Errorf("enums of the same name declared twice are equal")
+	}
+}
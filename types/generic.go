@@ -0,0 +1,253 @@
+package types
+
+import (
+	"fmt"
+
+	"github.com/kvarenzn/pinecone/metainfo"
+)
+
+// typeVar is a type parameter of a generic signature, like 'T' in
+// 'array.get<T>(id: array<T>, index: series int) -> series T'
+type typeVar struct {
+	BaseType
+	name string
+}
+
+func TypeVar(name string) Type {
+	return typeVar{
+		name: name,
+	}
+}
+
+func (tv typeVar) Kind() TypeKind {
+	return TypeVarKind
+}
+
+func (tv typeVar) String() string {
+	return tv.name
+}
+
+// GenericType is a type in a generic signature with its qualifier, which is
+// either fixed or a qualifier variable like 'q' in 'nz<T>(source: q T) -> q
+// T'. A type without qualifier accepts values of any qualifier.
+type GenericType struct {
+	Qualifier    QualifierKind
+	QualifierVar string
+	Type         Type
+}
+
+func (gt GenericType) String() string {
+	if gt.QualifierVar != "" {
+		return fmt.Sprintf("%s %s", gt.QualifierVar, gt.Type)
+	}
+	if gt.Qualifier != NoQualifier {
+		return fmt.Sprintf("%s %s", gt.Qualifier, gt.Type)
+	}
+	return gt.Type.String()
+}
+
+type GenericParam struct {
+	Name string
+	GenericType
+	Optional bool
+	// the default value of an optional parameter as it is written, if any
+	Default string
+}
+
+// String renders a parameter like it is written in a signature
+func (gp GenericParam) String() string {
+	return formatParam(gp.Name, gp.GenericType.String(), gp.Optional, gp.Default)
+}
+
+// Bindings are the types and qualifiers the variables of a signature have
+// been bound to at a call
+type Bindings struct {
+	Types      map[string]Type
+	Qualifiers map[string]QualifierKind
+	// type variables given explicitly, like in 'array.new<float>()', or
+	// bound by the items of containers, which are not widened any more
+	fixed map[string]bool
+}
+
+func NewBindings() Bindings {
+	return Bindings{
+		Types:      map[string]Type{},
+		Qualifiers: map[string]QualifierKind{},
+		fixed:      map[string]bool{},
+	}
+}
+
+func (b Bindings) clone() Bindings {
+	c := NewBindings()
+	b.copyTo(c)
+	return c
+}
+
+func (b Bindings) copyTo(c Bindings) {
+	for k, v := range b.Types {
+		c.Types[k] = v
+	}
+	for k, v := range b.Qualifiers {
+		c.Qualifiers[k] = v
+	}
+	for k, v := range b.fixed {
+		c.fixed[k] = v
+	}
+}
+
+// Apply replaces the bound type variables in t, the unbound ones are kept
+func (b Bindings) Apply(t Type) Type {
+	return b.substitute(t, func(tv typeVar) Type {
+		return tv
+	})
+}
+
+// Resolve gives the type of a value declared with gt, like the result of a
+// call. Unbound type variables, which are only given na, are uncertain.
+func (b Bindings) Resolve(gt GenericType) Type {
+	t := b.substitute(gt.Type, func(typeVar) Type {
+		return Uncertain
+	})
+
+	qualifier := gt.Qualifier
+	if gt.QualifierVar != "" {
+		qualifier = b.Qualifiers[gt.QualifierVar]
+	}
+	if qualifier == NoQualifier || t.Kind() == VoidKind {
+		return t
+	}
+	return TypeWithQualifier{
+		Qualifier: qualifier,
+		Type:      t,
+	}
+}
+
+func (b Bindings) substitute(t Type, unbound func(typeVar) Type) Type {
+	switch t := Peel(t).(type) {
+	case typeVar:
+		if bound, ok := b.Types[t.name]; ok {
+			return bound
+		}
+		return unbound(t)
+	case arrayType:
+		return ArrayOf(b.substitute(t.item, unbound))
+	case matrixType:
+		return MatrixOf(b.substitute(t.unit, unbound))
+	case mapType:
+		return MapOf(b.substitute(t.key, unbound), b.substitute(t.value, unbound))
+	case tupleType:
+		items := []Type{}
+		for _, i := range t.items {
+			items = append(items, b.substitute(i, unbound))
+		}
+		return TupleOf(items)
+	case unionType:
+		members := []Type{}
+		for _, m := range t.members {
+			members = append(members, b.substitute(m, unbound))
+		}
+		return UnionOf(members)
+	}
+	return t
+}
+
+// Unify checks an argument against a parameter declared with param, and
+// binds the variables of param in b. A type variable is bound to the type of
+// its first argument, and widened when a later argument can not be converted
+// to it but it can be converted to the argument, like from int to float,
+// unless it is the item type of a container. Conversions follow the rules of
+// the given language version.
+func Unify(version metainfo.LanguageVersion, param GenericType, arg Type, b Bindings) error {
+	if arg == nil {
+		return nil
+	}
+
+	// qualifier variables are not shown to users, the qualifier a variable
+	// is bound to by the arguments before is shown instead, if any
+	expected := GenericType{
+		Qualifier: param.Qualifier,
+		Type:      b.Apply(param.Type),
+	}
+	if param.QualifierVar != "" {
+		expected.Qualifier = b.Qualifiers[param.QualifierVar]
+	}
+	mismatch := func() error {
+		return fmt.Errorf("expected %s, got %s", expected, arg)
+	}
+
+	q := arg.QualifierKind()
+	if param.QualifierVar != "" {
+		b.Qualifiers[param.QualifierVar] = max(b.Qualifiers[param.QualifierVar], q)
+	} else if param.Qualifier != NoQualifier && q > param.Qualifier {
+		return mismatch()
+	}
+
+	if !b.unify(version, param.Type, Peel(arg), false) {
+		return mismatch()
+	}
+	return nil
+}
+
+// unify matches a type against a parameter type, the items of containers
+// are matched exactly as 'array<int>' is not an 'array<float>'
+func (b Bindings) unify(version metainfo.LanguageVersion, param, arg Type, nested bool) bool {
+	arg = Peel(arg)
+	if arg.Kind() == UncertainKind {
+		// na fits every type
+		return true
+	}
+
+	switch param.Kind() {
+	case UncertainKind:
+		return true
+	case TypeVarKind:
+		name := param.String()
+		bound, ok := b.Types[name]
+		if !ok || bound.Kind() == UncertainKind {
+			b.Types[name] = arg
+			b.fixed[name] = nested
+			return true
+		}
+		if Equal(bound, arg) {
+			b.fixed[name] = b.fixed[name] || nested
+			return true
+		}
+		if !nested && CanDoImplicitConversionIn(version, arg, bound) {
+			return true
+		}
+		if !b.fixed[name] && CanDoImplicitConversionIn(version, bound, arg) {
+			b.Types[name] = arg
+			b.fixed[name] = nested
+			return true
+		}
+		return false
+	case UnionKind:
+		for _, m := range param.Members() {
+			c := b.clone()
+			if c.unify(version, m, arg, nested) {
+				c.copyTo(b)
+				return true
+			}
+		}
+		return false
+	case ArrayKind, MatrixKind:
+		return arg.Kind() == param.Kind() && b.unify(version, param.Unit(), arg.Unit(), true)
+	case MapKind:
+		return arg.Kind() == MapKind && b.unify(version, param.Key(), arg.Key(), true) && b.unify(version, param.Value(), arg.Value(), true)
+	case TupleKind:
+		if arg.Kind() != TupleKind || arg.Count() != param.Count() {
+			return false
+		}
+		for i := 0; i < param.Count(); i++ {
+			if !b.unify(version, param.Item(i), arg.Item(i), true) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if nested {
+		return Equal(param, arg)
+	}
+	return Equal(param, arg) || CanDoImplicitConversionIn(version, arg, param)
+}
@@ -0,0 +1,48 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/kvarenzn/pinecone/metainfo"
+)
+
+func TestMismatchHidesQualifierVariables(t *testing.T) {
+	f := NewGenericFunction("max(a: q int, b: q int) -> q int")
+	series := TypeWithQualifier{Qualifier: Series, Type: Int}
+	tests := []struct {
+		args []Type
+		want string
+	}{
+		{[]Type{String, Int}, "argument 'a' of 'max': expected int, got string"},
+		{[]Type{Int, String}, "argument 'b' of 'max': expected int, got string"},
+		{[]Type{series, String}, "argument 'b' of 'max': expected series int, got string"},
+	}
+
+	for _, test := range tests {
+		_, err := f.Dispatch(metainfo.V5, test.args, nil)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("max%v: got %v, want %s", test.args, err, test.want)
+		}
+	}
+}
+
+func TestSignatureSyntax(t *testing.T) {
+	// a builtin and a function of a script with the same signature are
+	// rendered alike, and the rendering of a builtin parses back
+	text := "ta.sma(source: series float, length: simple int = 14) -> series float"
+	gs := MustParseSignature(text)
+	f := FunctionOf([]TypeWithName{
+		{Name: "source", Type: TypeWithQualifier{Qualifier: Series, Type: Float}},
+		{Name: "length", Type: TypeWithQualifier{Qualifier: Simple, Type: Int}, Optional: true, Default: "14"},
+	}, TypeWithQualifier{Qualifier: Series, Type: Float})
+
+	if got := gs.String(); got != text {
+		t.Errorf("builtin rendered as %s", got)
+	}
+	if got := Signature("ta.sma", f); got != text {
+		t.Errorf("function rendered as %s", got)
+	}
+	if _, err := ParseSignature(Signature("ta.sma", f)); err != nil {
+		t.Error(err)
+	}
+}
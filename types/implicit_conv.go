@@ -1,23 +1,26 @@
 package types
 
-func CanDoImplicitConversion(from, to Type) bool {
+import "github.com/kvarenzn/pinecone/metainfo"
+
+func CanDoImplicitConversionIn(version metainfo.LanguageVersion, from, to Type) bool {
 	if to.Kind() == UnionKind {
 		return false
 	}
 	if from.Kind() == UnionKind {
 		for _, m := range from.Members() {
-			if !CanDoImplicitConversion(m, to) {
+			if !CanDoImplicitConversionIn(version, m, to) {
 				return false
 			}
 		}
 		return true
 	}
 	if from.Kind() == UncertainKind && to.Kind() != UncertainKind && to.Kind() != VoidKind {
-		// na can be casted to almost any types
-		return true
+		// na can be casted to almost any types, but bools cannot be na since v6
+		return version < metainfo.V6 || to.Kind() != BoolKind
 	}
 	if to.Kind() == BoolKind {
-		if from.Kind() == IntKind || from.Kind() == FloatKind {
+		// v6 removed the implicit casting of numbers to bool
+		if version < metainfo.V6 && (from.Kind() == IntKind || from.Kind() == FloatKind) {
 			return true
 		}
 	} else if to.Kind() == FloatKind && from.Kind() == IntKind {
@@ -26,4 +29,3 @@ func CanDoImplicitConversion(from, to Type) bool {
 
 	return false
 }
-
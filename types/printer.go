@@ -0,0 +1,65 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// formatParam renders a parameter of a signature, or a field, like
+// 'length: simple int = 14', typ is empty for parameters without a type
+func formatParam(name, typ string, optional bool, def string) string {
+	s := name
+	if def == "" && optional {
+		s += "?"
+	}
+	if typ != "" {
+		s += ": " + typ
+	}
+	if def != "" {
+		s += " = " + def
+	}
+	return s
+}
+
+// formatSignature renders the signature of a function in the syntax of the
+// catalog of builtins (see GenericSignature), out is empty for functions
+// returning void
+func formatSignature(name string, typeParams []string, params []string, out string) string {
+	var sb strings.Builder
+	sb.WriteString(name)
+	if len(typeParams) > 0 {
+		fmt.Fprintf(&sb, "<%s>", strings.Join(typeParams, ", "))
+	}
+	fmt.Fprintf(&sb, "(%s)", strings.Join(params, ", "))
+	if out != "" {
+		fmt.Fprintf(&sb, " -> %s", out)
+	}
+	return sb.String()
+}
+
+// Signature renders a function type as a declaration of a function named
+// name, like 'ta.sma(source: series float, length: simple int) -> series
+// float', builtins and functions of scripts alike. Other types are rendered
+// as 'name: type'.
+func Signature(name string, t Type) string {
+	if t == nil {
+		return name
+	}
+	f, ok := Peel(t).(functionType)
+	if !ok {
+		if name == "" {
+			return t.String()
+		}
+		return formatParam(name, t.String(), false, "")
+	}
+
+	params := []string{}
+	for _, in := range f.in {
+		params = append(params, in.String())
+	}
+	out := ""
+	if f.out != nil && f.out.Kind() != VoidKind {
+		out = f.out.String()
+	}
+	return formatSignature(name, nil, params, out)
+}
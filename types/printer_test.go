@@ -0,0 +1,72 @@
+package types
+
+import "testing"
+
+func TestPrinter(t *testing.T) {
+	simple := func(t Type) Type { return TypeWithQualifier{Qualifier: Simple, Type: t} }
+	series := func(t Type) Type { return TypeWithQualifier{Qualifier: Series, Type: t} }
+	point := StructOf("Point", []TypeWithName{{Name: "x", Type: Float}, {Name: "y", Type: Float}})
+	band := series(Tuple(Float, Float))
+
+	tests := []struct {
+		name string
+		t    Type
+		want string
+	}{
+		{"no qualifier", TypeWithQualifier{Type: Int}, "int"},
+		{"qualifier", series(Float), "series float"},
+		{"type", NewTocType(Int), "int"},
+		{"type constructor", NewTocCtor("array<T>", nil), "array<T>"},
+		{"array of a qualified type", ArrayOf(simple(Int)), "array<simple int>"},
+		{"map of a user defined type", MapOf(String, point), "map<string, Point>"},
+		{"user defined type", point, "Point"},
+		{"anonymous struct", StructOf("", []TypeWithName{{Name: "x", Type: Float}}), "{x: float}"},
+		{"tuple", Tuple(series(Float), simple(Int)), "[series float, simple int]"},
+		{"qualified tuple", simple(Tuple(Float, series(Int))), "[simple float, series int]"},
+		{
+			"tuple return",
+			FunctionOf([]TypeWithName{{Name: "src", Type: series(Float)}}, band),
+			"(src: series float) -> [series float, series float]",
+		},
+		{
+			"defaults",
+			FunctionOf([]TypeWithName{
+				{Name: "x", Type: Float},
+				{Name: "n", Type: simple(Int), Optional: true, Default: "14"},
+				{Name: "p", Type: point, Optional: true},
+			}, nil),
+			"(x: float, n: simple int = 14, p?: Point)",
+		},
+		{"untyped parameter", FunctionOf([]TypeWithName{{Name: "v", Type: Uncertain}}, Void), "(v)"},
+	}
+
+	for _, test := range tests {
+		if got := test.t.String(); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSignature(t *testing.T) {
+	f := FunctionOf([]TypeWithName{
+		{Name: "src", Type: TypeWithQualifier{Qualifier: Series, Type: Float}},
+		{Name: "mult", Type: Float, Optional: true, Default: "2.0"},
+	}, Tuple(Float, Float, Float))
+
+	tests := []struct {
+		name string
+		t    Type
+		want string
+	}{
+		{"bands", f, "bands(src: series float, mult: float = 2.0) -> [float, float, float]"},
+		{"x", TypeWithQualifier{Qualifier: Const, Type: Int}, "x: const int"},
+		{"", Int, "int"},
+		{"y", nil, "y"},
+	}
+
+	for _, test := range tests {
+		if got := Signature(test.name, test.t); got != test.want {
+			t.Errorf("%q: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
@@ -37,8 +37,24 @@ func (twq TypeWithQualifier) Kind() TypeKind {
 	return twq.Type.Kind()
 }
 
+// String renders the qualifier before the type, the qualifier of a tuple is
+// given to each of its items which has none, like '[series float, series
+// float]'
 func (twq TypeWithQualifier) String() string {
-	return fmt.Sprintf("%s %s", twq.Qualifier.String(), twq.Type.String())
+	if twq.Qualifier == NoQualifier {
+		return twq.Type.String()
+	}
+	if t, ok := Peel(twq.Type).(tupleType); ok {
+		items := []Type{}
+		for _, item := range t.items {
+			if item.QualifierKind() == NoQualifier {
+				item = TypeWithQualifier{Qualifier: twq.Qualifier, Type: item}
+			}
+			items = append(items, item)
+		}
+		return TupleOf(items).String()
+	}
+	return fmt.Sprintf("%s %s", twq.Qualifier, Peel(twq.Type))
 }
 
 func (bt BaseType) QualifierKind() QualifierKind {
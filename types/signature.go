@@ -0,0 +1,548 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/kvarenzn/pinecone/metainfo"
+)
+
+// GenericSignature is a signature of a builtin function with type variables
+// and qualifier variables, written like
+//
+//	array.get<T>(id: array<T>, index: series int) -> series T
+//	nz<T>(source: q T, replacement?: q T) -> q T
+//
+// The type variables are declared after the name, a word before a type which
+// is not a qualifier is a qualifier variable. Optional parameters are marked
+// with '?' or given a default value, tuples are written as '[int, float]',
+// unions as 'int | float', and a signature without '->' returns void.
+type GenericSignature struct {
+	Name       string
+	TypeParams []string
+	Params     []GenericParam
+	Out        GenericType
+}
+
+func (gs GenericSignature) String() string {
+	params := []string{}
+	for _, p := range gs.Params {
+		params = append(params, p.String())
+	}
+	out := ""
+	if gs.Out.Type.Kind() != VoidKind {
+		out = gs.Out.String()
+	}
+	return formatSignature(gs.Name, gs.TypeParams, params, out)
+}
+
+// bind checks the arguments of a call against the signature, matched is the
+// number of arguments accepted before an error, to tell the closest
+// overload
+func (gs GenericSignature) bind(version metainfo.LanguageVersion, typeArgs []Type, args []Type, kwargs map[string]Type) (out Type, matched int, err error) {
+	if len(args) > len(gs.Params) {
+		return nil, 0, fmt.Errorf("'%s' takes at most %d arguments, got %d", gs.Name, len(gs.Params), len(args))
+	}
+
+	given := map[string]Type{}
+	for i, a := range args {
+		given[gs.Params[i].Name] = a
+	}
+	for k, v := range kwargs {
+		found := false
+		for _, p := range gs.Params {
+			if p.Name == k {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, 0, fmt.Errorf("'%s' has no parameter named '%s'", gs.Name, k)
+		}
+		if _, ok := given[k]; ok {
+			return nil, 0, fmt.Errorf("'%s' got multiple values for argument '%s'", gs.Name, k)
+		}
+		given[k] = v
+	}
+
+	b := NewBindings()
+	for i, t := range typeArgs {
+		b.Types[gs.TypeParams[i]] = t
+		b.fixed[gs.TypeParams[i]] = true
+	}
+
+	matched = 1
+	for _, p := range gs.Params {
+		arg, ok := given[p.Name]
+		if !ok {
+			if !p.Optional {
+				return nil, matched, fmt.Errorf("'%s' requires the '%s' argument", gs.Name, p.Name)
+			}
+			continue
+		}
+		if err := Unify(version, p.GenericType, arg, b); err != nil {
+			return nil, matched, fmt.Errorf("argument '%s' of '%s': %w", p.Name, gs.Name, err)
+		}
+		matched++
+	}
+
+	return b.Resolve(gs.Out), matched, nil
+}
+
+// GenericFunction is a builtin function described by generic signatures,
+// one for each overload
+type GenericFunction struct {
+	Name      string
+	Overloads []GenericSignature
+	Function  func(args ...any) (any, error)
+	Method    bool
+	// functions like 'array.push' change their arguments when called
+	SideEffect bool
+	// the type arguments given explicitly, like in 'array.new<float>()'
+	TypeArgs []Type
+}
+
+// NewGenericFunction creates a builtin function from signatures, it panics
+// if a signature is malformed
+func NewGenericFunction(signatures ...string) GenericFunction {
+	gf := GenericFunction{}
+	for _, s := range signatures {
+		gs := MustParseSignature(s)
+		if gf.Name != "" && gf.Name != gs.Name {
+			panic(fmt.Sprintf("overload '%s' of '%s' has a different name", s, gf.Name))
+		}
+		gf.Name = gs.Name
+		gf.Overloads = append(gf.Overloads, gs)
+	}
+	return gf
+}
+
+func (gf GenericFunction) Call(args []any) (any, error) {
+	if gf.Function == nil {
+		return nil, fmt.Errorf("function %s cannot be called", gf.Name)
+	}
+	return gf.Function(args...)
+}
+
+// Dispatch tries the overloads in order, and reports the error of the one
+// which accepts the most arguments if none of them matches
+func (gf GenericFunction) Dispatch(version metainfo.LanguageVersion, args []Type, kwargs map[string]Type) (Type, error) {
+	var closest error
+	best := -1
+	for _, gs := range gf.Overloads {
+		if gf.TypeArgs != nil && len(gf.TypeArgs) != len(gs.TypeParams) {
+			continue
+		}
+		out, matched, err := gs.bind(version, gf.TypeArgs, args, kwargs)
+		if err == nil {
+			return out, nil
+		}
+		if matched > best {
+			closest, best = err, matched
+		}
+	}
+
+	if closest == nil {
+		return nil, fmt.Errorf("function %s cannot be called", gf.Name)
+	}
+	return nil, closest
+}
+
+// Instantiate gives the type variables of the function explicitly, only the
+// overloads with as many type variables are kept
+func (gf GenericFunction) Instantiate(typeArgs []Type) (GenericFunction, error) {
+	if gf.TypeArgs != nil {
+		return gf, fmt.Errorf("'%s' is already instantiated", gf.Name)
+	}
+	for _, gs := range gf.Overloads {
+		if len(gs.TypeParams) == len(typeArgs) {
+			gf.TypeArgs = typeArgs
+			return gf, nil
+		}
+	}
+	return gf, fmt.Errorf("'%s' does not take %d type arguments", gf.Name, len(typeArgs))
+}
+
+func (gf GenericFunction) HasSideEffect() bool {
+	return gf.SideEffect
+}
+
+func (gf GenericFunction) IsMethod() bool {
+	return gf.Method
+}
+
+// FirstArgType is unknown for generic methods, see MethodAccepts
+func (gf GenericFunction) FirstArgType() Type {
+	return nil
+}
+
+func (gf GenericFunction) acceptsSelf(version metainfo.LanguageVersion, self Type) bool {
+	for _, gs := range gf.Overloads {
+		if len(gs.Params) > 0 && Unify(version, gs.Params[0].GenericType, self, NewBindings()) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSignature parses a generic signature, see GenericSignature
+func ParseSignature(text string) (GenericSignature, error) {
+	sp := signatureParser{text: text}
+	gs, err := sp.signature()
+	if err != nil {
+		return gs, fmt.Errorf("signature '%s': %w", text, err)
+	}
+	return gs, nil
+}
+
+func MustParseSignature(text string) GenericSignature {
+	gs, err := ParseSignature(text)
+	if err != nil {
+		panic(err)
+	}
+	return gs
+}
+
+// ParseType parses the type of a value, like 'series float', which is
+// written like in a signature but without variables
+func ParseType(text string) (GenericType, error) {
+	sp := signatureParser{text: text}
+	gt, err := sp.genericType()
+	if err == nil && gt.QualifierVar != "" {
+		err = fmt.Errorf("unknown qualifier '%s'", gt.QualifierVar)
+	}
+	if err == nil && sp.peek() != 0 {
+		err = sp.errorf("unexpected '%c'", sp.peek())
+	}
+	if err != nil {
+		return gt, fmt.Errorf("type '%s': %w", text, err)
+	}
+	return gt, nil
+}
+
+var signatureTypes = map[string]Type{
+	"void":        Void,
+	"bool":        Bool,
+	"int":         Int,
+	"float":       Float,
+	"string":      String,
+	"box":         Box,
+	"color":       Color,
+	"chart.point": Point,
+	"label":       Label,
+	"line":        Line,
+	"linefill":    LineFill,
+	"polyline":    PolyLine,
+	"table":       Table,
+}
+
+var signatureQualifiers = map[string]QualifierKind{
+	"const":  Const,
+	"input":  Input,
+	"simple": Simple,
+	"series": Series,
+}
+
+type signatureParser struct {
+	text       string
+	pos        int
+	typeParams map[string]bool
+}
+
+func (sp *signatureParser) skipSpaces() {
+	for sp.pos < len(sp.text) && sp.text[sp.pos] == ' ' {
+		sp.pos++
+	}
+}
+
+func (sp *signatureParser) peek() byte {
+	sp.skipSpaces()
+	if sp.pos >= len(sp.text) {
+		return 0
+	}
+	return sp.text[sp.pos]
+}
+
+func (sp *signatureParser) accept(s string) bool {
+	sp.skipSpaces()
+	if strings.HasPrefix(sp.text[sp.pos:], s) {
+		sp.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (sp *signatureParser) expect(s string) error {
+	if !sp.accept(s) {
+		return sp.errorf("expected '%s'", s)
+	}
+	return nil
+}
+
+func (sp *signatureParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%s at column %d", fmt.Sprintf(format, args...), sp.pos+1)
+}
+
+func isNameByte(c byte, first bool) bool {
+	r := rune(c)
+	return r == '_' || unicode.IsLetter(r) || !first && (unicode.IsDigit(r) || r == '.')
+}
+
+// word reads a name, dotted names like 'chart.point' included
+func (sp *signatureParser) word() (string, error) {
+	sp.skipSpaces()
+	begin := sp.pos
+	for sp.pos < len(sp.text) && isNameByte(sp.text[sp.pos], sp.pos == begin) {
+		sp.pos++
+	}
+	if begin == sp.pos {
+		return "", sp.errorf("expected a name")
+	}
+	return sp.text[begin:sp.pos], nil
+}
+
+func (sp *signatureParser) signature() (GenericSignature, error) {
+	gs := GenericSignature{
+		TypeParams: []string{},
+		Params:     []GenericParam{},
+		Out:        GenericType{Type: Void},
+	}
+	sp.typeParams = map[string]bool{}
+
+	name, err := sp.word()
+	if err != nil {
+		return gs, err
+	}
+	gs.Name = name
+
+	if sp.accept("<") {
+		for {
+			tp, err := sp.word()
+			if err != nil {
+				return gs, err
+			}
+			if sp.typeParams[tp] {
+				return gs, sp.errorf("duplicate type variable '%s'", tp)
+			}
+			sp.typeParams[tp] = true
+			gs.TypeParams = append(gs.TypeParams, tp)
+			if !sp.accept(",") {
+				break
+			}
+		}
+		if err := sp.expect(">"); err != nil {
+			return gs, err
+		}
+	}
+
+	if err := sp.expect("("); err != nil {
+		return gs, err
+	}
+	if !sp.accept(")") {
+		for {
+			p, err := sp.param()
+			if err != nil {
+				return gs, err
+			}
+			for _, prev := range gs.Params {
+				if prev.Name == p.Name {
+					return gs, sp.errorf("duplicate parameter '%s'", p.Name)
+				}
+			}
+			gs.Params = append(gs.Params, p)
+			if !sp.accept(",") {
+				break
+			}
+		}
+		if err := sp.expect(")"); err != nil {
+			return gs, err
+		}
+	}
+
+	if sp.accept("->") {
+		if gs.Out, err = sp.genericType(); err != nil {
+			return gs, err
+		}
+	}
+	if sp.peek() != 0 {
+		return gs, sp.errorf("unexpected '%c'", sp.peek())
+	}
+	return gs, nil
+}
+
+func (sp *signatureParser) param() (GenericParam, error) {
+	p := GenericParam{}
+	name, err := sp.word()
+	if err != nil {
+		return p, err
+	}
+	p.Name = name
+	p.Optional = sp.accept("?")
+	if err := sp.expect(":"); err != nil {
+		return p, err
+	}
+	if p.GenericType, err = sp.genericType(); err != nil {
+		return p, err
+	}
+	if sp.accept("=") {
+		p.Optional = true
+		if p.Default, err = sp.defaultValue(); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// defaultValue reads the default value of a parameter as it is, up to the
+// next parameter
+func (sp *signatureParser) defaultValue() (string, error) {
+	sp.skipSpaces()
+	begin := sp.pos
+	depth := 0
+	var quote byte
+	for ; sp.pos < len(sp.text); sp.pos++ {
+		c := sp.text[sp.pos]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				sp.pos++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[':
+			depth++
+		case (c == ')' || c == ']') && depth > 0:
+			depth--
+		case (c == ',' || c == ')') && depth == 0:
+			value := strings.TrimSpace(sp.text[begin:sp.pos])
+			if value == "" {
+				return "", sp.errorf("expected a default value")
+			}
+			return value, nil
+		}
+	}
+	return "", sp.errorf("unterminated default value")
+}
+
+func (sp *signatureParser) genericType() (GenericType, error) {
+	gt := GenericType{}
+
+	// a word followed by another type is a qualifier
+	save := sp.pos
+	if c := sp.peek(); c != 0 && isNameByte(c, true) {
+		w, err := sp.word()
+		if err != nil {
+			return gt, err
+		}
+		if c := sp.peek(); c == '[' || c != 0 && isNameByte(c, true) {
+			if q, ok := signatureQualifiers[w]; ok {
+				gt.Qualifier = q
+			} else if _, ok := signatureTypes[w]; ok || sp.typeParams[w] {
+				return gt, sp.errorf("'%s' is not a qualifier", w)
+			} else {
+				gt.QualifierVar = w
+			}
+		} else {
+			sp.pos = save
+		}
+	}
+
+	t, err := sp.unionType()
+	if err != nil {
+		return gt, err
+	}
+	gt.Type = t
+	return gt, nil
+}
+
+func (sp *signatureParser) unionType() (Type, error) {
+	members := []Type{}
+	for {
+		t, err := sp.singleType()
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, t)
+		if !sp.accept("|") {
+			break
+		}
+	}
+	return UnionOf(members), nil
+}
+
+func (sp *signatureParser) typeArgs(count int) ([]Type, error) {
+	if err := sp.expect("<"); err != nil {
+		return nil, err
+	}
+	args := []Type{}
+	for {
+		t, err := sp.unionType()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, t)
+		if !sp.accept(",") {
+			break
+		}
+	}
+	if err := sp.expect(">"); err != nil {
+		return nil, err
+	}
+	if len(args) != count {
+		return nil, sp.errorf("expected %d type arguments, got %d", count, len(args))
+	}
+	return args, nil
+}
+
+func (sp *signatureParser) singleType() (Type, error) {
+	if sp.accept("[") {
+		items := []Type{}
+		for {
+			t, err := sp.unionType()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, t)
+			if !sp.accept(",") {
+				break
+			}
+		}
+		if err := sp.expect("]"); err != nil {
+			return nil, err
+		}
+		return TupleOf(items), nil
+	}
+
+	name, err := sp.word()
+	if err != nil {
+		return nil, err
+	}
+	if sp.typeParams[name] {
+		return TypeVar(name), nil
+	}
+	if t, ok := signatureTypes[name]; ok {
+		return t, nil
+	}
+
+	switch name {
+	case "array", "matrix":
+		args, err := sp.typeArgs(1)
+		if err != nil {
+			return nil, err
+		}
+		if name == "array" {
+			return ArrayOf(args[0]), nil
+		}
+		return MatrixOf(args[0]), nil
+	case "map":
+		args, err := sp.typeArgs(2)
+		if err != nil {
+			return nil, err
+		}
+		return MapOf(args[0], args[1]), nil
+	}
+	return nil, sp.errorf("unknown type '%s'", name)
+}
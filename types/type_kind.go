@@ -25,6 +25,7 @@ const (
 	MatrixKind
 	MapKind
 	StructKind // User Defined Types
+	EnumKind   // User Defined Enums
 	TupleKind  // Used as function return value
 	FunctionKind
 
@@ -32,6 +33,7 @@ const (
 	NamespaceKind
 	TypeOrCtorKind
 	CallableKind
+	TypeVarKind // type parameters of generic signatures
 
 	// private types
 	UnionKind
@@ -1,7 +1,5 @@
 package types
 
-import "fmt"
-
 type TocKind byte
 
 const (
@@ -14,6 +12,8 @@ type TypeOrCtor struct {
 	Tag  TocKind
 	Type Type
 	Ctor func(args []Type) (Type, error)
+	// how a constructor is written, like 'map<K, V>'
+	Form string
 }
 
 func NewTocType(t Type) TypeOrCtor {
@@ -23,10 +23,11 @@ func NewTocType(t Type) TypeOrCtor {
 	}
 }
 
-func NewTocCtor(ctor func(args []Type) (Type, error)) TypeOrCtor {
+func NewTocCtor(form string, ctor func(args []Type) (Type, error)) TypeOrCtor {
 	return TypeOrCtor{
 		Tag: TocCtor,
 		Ctor: ctor,
+		Form: form,
 	}
 }
 
@@ -35,5 +36,8 @@ func (toc TypeOrCtor) Kind() TypeKind {
 }
 
 func (toc TypeOrCtor) String() string {
-	return fmt.Sprintf("toc(%v)", toc.Tag)
+	if toc.Tag == TocType {
+		return toc.Type.String()
+	}
+	return toc.Form
 }
@@ -52,6 +52,10 @@ func UnionOf(types []Type) Type {
 		}
 	}
 
+	if len(result.members) == 1 {
+		return result.members[0]
+	}
+
 	return result
 }
 